@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidAPIKey is returned by a KeyValidator for unknown API keys.
+	// Requests with such keys are answered with a 401 "Unauthorized".
+	ErrInvalidAPIKey = errors.New("handlers: invalid API key")
+	// ErrForbiddenAPIKey is returned by a KeyValidator for API keys which are
+	// valid but not allowed to make the request. Requests with such keys are
+	// answered with a 403 "Forbidden".
+	ErrForbiddenAPIKey = errors.New("handlers: API key not allowed")
+)
+
+type apiKeyPrincipalKey struct{}
+
+// KeyValidator resolves API keys to the principal they belong to, such as a
+// user or service account. It returns ErrInvalidAPIKey or ErrForbiddenAPIKey
+// (possibly wrapped) for keys which must be rejected. Any other error is
+// treated as a failure of the validator and answered with a 500 "Internal
+// Server Error".
+type KeyValidator interface {
+	ValidateKey(r *http.Request, key string) (principal interface{}, err error)
+}
+
+// KeyValidatorFunc is an adapter to allow the use of ordinary functions as
+// KeyValidators.
+type KeyValidatorFunc func(r *http.Request, key string) (interface{}, error)
+
+// ValidateKey calls f(r, key).
+func (f KeyValidatorFunc) ValidateKey(r *http.Request, key string) (interface{}, error) {
+	return f(r, key)
+}
+
+type apiKeySource struct {
+	header string
+	query  string
+	bearer bool
+}
+
+type apiKeyHandler struct {
+	h         http.Handler
+	validator KeyValidator
+	sources   []apiKeySource
+	realm     string
+	cache     *apiKeyCache
+	cacheKey  func(r *http.Request) string
+	onFailure func(r *http.Request, err error)
+}
+
+// APIKeyOption provides a functional approach to configure the
+// APIKeyHandler middleware.
+type APIKeyOption func(*apiKeyHandler)
+
+// APIKeyHandler is HTTP middleware that requires requests to carry an API key
+// accepted by validator. Keys are looked for in the X-API-Key header unless
+// other sources are configured with APIKeyHeader, APIKeyQuery or APIKeyBearer,
+// in which case the sources are tried in the order given.
+//
+// Requests without a key or with an invalid key are answered with a 401
+// "Unauthorized" and a WWW-Authenticate challenge, requests with a forbidden
+// key with a 403 "Forbidden". The principal resolved by the validator is
+// available to the next handler via APIKeyPrincipalFromContext.
+//
+// Example:
+//
+//	auth := handlers.APIKeyHandler(handlers.KeyValidatorFunc(lookupKey),
+//		handlers.APIKeyBearer(),
+//		handlers.APIKeyCache(time.Minute, 10000),
+//	)
+//	http.ListenAndServe(":8000", auth(r))
+func APIKeyHandler(validator KeyValidator, opts ...APIKeyOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		a := &apiKeyHandler{h: h, validator: validator, realm: defaultBasicAuthRealm}
+		for _, option := range opts {
+			option(a)
+		}
+		if len(a.sources) == 0 {
+			a.sources = []apiKeySource{{header: "X-API-Key"}}
+		}
+		return a
+	}
+}
+
+// APIKeyHeader is a functional option that looks for the API key in the
+// request header name.
+func APIKeyHeader(name string) APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.sources = append(a.sources, apiKeySource{header: name})
+	}
+}
+
+// APIKeyQuery is a functional option that looks for the API key in the query
+// parameter name. Query parameters tend to end up in logs, so prefer headers
+// where clients allow it.
+func APIKeyQuery(name string) APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.sources = append(a.sources, apiKeySource{query: name})
+	}
+}
+
+// APIKeyBearer is a functional option that looks for the API key in an
+// Authorization header using the Bearer scheme. Challenges then use the
+// Bearer scheme too (RFC 6750).
+func APIKeyBearer() APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.sources = append(a.sources, apiKeySource{bearer: true})
+	}
+}
+
+// APIKeyRealm is a functional option that sets the realm advertised in the
+// authentication challenge.
+func APIKeyRealm(realm string) APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.realm = realm
+	}
+}
+
+// APIKeyCache is a functional option that caches the results of the
+// validator for ttl. At most maxEntries keys are cached. Keys are only held as
+// SHA-256 hashes.
+//
+// Cached results are request-independent: a key accepted for one request is
+// accepted for any other until its result expires, without calling the
+// validator. Use APIKeyCacheKey if the validator decides on more than the key,
+// e.g. on the path or method. Results with ErrForbiddenAPIKey, which depend on
+// the request, are only cached along with APIKeyCacheKey.
+func APIKeyCache(ttl time.Duration, maxEntries int) APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.cache = &apiKeyCache{
+			ttl:        ttl,
+			maxEntries: maxEntries,
+			entries:    make(map[[sha256.Size]byte]apiKeyResult),
+			now:        time.Now,
+		}
+	}
+}
+
+// APIKeyCacheKey is a functional option that scopes the results cached by
+// APIKeyCache to fn(r) in addition to the key, e.g. to the request path or
+// method when the validator grants keys access to some routes only. fn must
+// return the same value for requests the validator decides alike.
+func APIKeyCacheKey(fn func(r *http.Request) string) APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.cacheKey = fn
+	}
+}
+
+// APIKeyOnFailure is a functional option that registers fn to be called
+// whenever a request is rejected, with ErrInvalidAPIKey if the request
+// carried no key.
+func APIKeyOnFailure(fn func(r *http.Request, err error)) APIKeyOption {
+	return func(a *apiKeyHandler) {
+		a.onFailure = fn
+	}
+}
+
+// APIKeyPrincipalFromContext returns the principal resolved by the
+// KeyValidator of APIKeyHandler, or nil if there is none.
+func APIKeyPrincipalFromContext(ctx context.Context) interface{} {
+	return ctx.Value(apiKeyPrincipalKey{})
+}
+
+func (a *apiKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, bearer := a.key(r)
+	if key == "" {
+		a.reject(w, r, ErrInvalidAPIKey, false)
+		return
+	}
+
+	cacheKey := key
+	if a.cacheKey != nil {
+		scope := a.cacheKey(r)
+		cacheKey = strconv.Itoa(len(scope)) + ":" + scope + key
+	}
+	res, ok := a.cache.get(cacheKey)
+	if !ok {
+		res.principal, res.err = a.validator.ValidateKey(r, key)
+		if res.err == nil || errors.Is(res.err, ErrInvalidAPIKey) ||
+			(a.cacheKey != nil && errors.Is(res.err, ErrForbiddenAPIKey)) {
+			a.cache.put(cacheKey, res)
+		}
+	}
+	if res.err != nil {
+		a.reject(w, r, res.err, bearer)
+		return
+	}
+
+	a.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyPrincipalKey{}, res.principal)))
+}
+
+// key returns the API key of r and whether it was a bearer token.
+func (a *apiKeyHandler) key(r *http.Request) (string, bool) {
+	for _, src := range a.sources {
+		switch {
+		case src.header != "":
+			if key := strings.TrimSpace(r.Header.Get(src.header)); key != "" {
+				return key, false
+			}
+		case src.query != "":
+			if key := r.URL.Query().Get(src.query); key != "" {
+				return key, false
+			}
+		case src.bearer:
+			auth := r.Header.Get("Authorization")
+			if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+				return strings.TrimSpace(auth[len("Bearer "):]), true
+			}
+		}
+	}
+	return "", false
+}
+
+func (a *apiKeyHandler) reject(w http.ResponseWriter, r *http.Request, err error, bearer bool) {
+	if a.onFailure != nil {
+		a.onFailure(r, err)
+	}
+	switch {
+	case errors.Is(err, ErrForbiddenAPIKey):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case errors.Is(err, ErrInvalidAPIKey):
+		w.Header().Set(wwwAuthenticateHeader, a.challenge(bearer))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// challenge returns the WWW-Authenticate challenge. Requests which carried
+// an invalid bearer token are told so, as per RFC 6750.
+func (a *apiKeyHandler) challenge(invalidBearer bool) string {
+	scheme := "APIKey"
+	for _, src := range a.sources {
+		if src.bearer {
+			scheme = "Bearer"
+		}
+	}
+	c := scheme + ` realm=` + quoteAuthParam(a.realm)
+	if invalidBearer {
+		c += `, error="invalid_token"`
+	}
+	return c
+}
+
+type apiKeyResult struct {
+	principal interface{}
+	err       error
+	expires   time.Time
+}
+
+// apiKeyCache caches validation results by key hash. A nil *apiKeyCache
+// caches nothing.
+type apiKeyCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]apiKeyResult
+}
+
+func (c *apiKeyCache) get(key string) (apiKeyResult, bool) {
+	if c == nil {
+		return apiKeyResult{}, false
+	}
+	sum := sha256.Sum256([]byte(key))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, ok := c.entries[sum]
+	if !ok || !c.now().Before(res.expires) {
+		delete(c.entries, sum)
+		return apiKeyResult{}, false
+	}
+	return res, true
+}
+
+func (c *apiKeyCache) put(key string, res apiKeyResult) {
+	if c == nil || c.maxEntries <= 0 {
+		return
+	}
+	sum := sha256.Sum256([]byte(key))
+	now := c.now()
+	res.expires = now.Add(c.ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[sum]; !ok && len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full: make room by dropping an arbitrary entry.
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[sum] = res
+}
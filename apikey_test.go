@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyHandler(t *testing.T) {
+	calls := 0
+	validator := KeyValidatorFunc(func(r *http.Request, key string) (interface{}, error) {
+		calls++
+		switch key {
+		case "good":
+			return "alice", nil
+		case "readonly":
+			return nil, fmt.Errorf("key %q: %w", key, ErrForbiddenAPIKey)
+		case "broken":
+			return nil, errors.New("database down")
+		}
+		return nil, ErrInvalidAPIKey
+	})
+	var principal interface{}
+	h := APIKeyHandler(validator,
+		APIKeyHeader("X-Key"),
+		APIKeyQuery("api_key"),
+		APIKeyBearer(),
+		APIKeyCache(time.Minute, 10),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = APIKeyPrincipalFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		header, value, query string
+		code                 int
+		challenge            string
+	}{
+		{"", "", "", http.StatusUnauthorized, `Bearer realm="Restricted"`},
+		{"X-Key", "good", "", http.StatusOK, ""},
+		{"", "", "api_key=good", http.StatusOK, ""},
+		{"Authorization", "Bearer good", "", http.StatusOK, ""},
+		{"Authorization", "Bearer bad", "", http.StatusUnauthorized, `Bearer realm="Restricted", error="invalid_token"`},
+		{"X-Key", "readonly", "", http.StatusForbidden, ""},
+		{"X-Key", "broken", "", http.StatusInternalServerError, ""},
+	}
+	for i, test := range tests {
+		principal = nil
+		r := newRequest(http.MethodGet, "/?"+test.query)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != test.code {
+			t.Fatalf("%d: bad status: got %v want %v", i, rr.Code, test.code)
+		}
+		if got := rr.Header().Get("WWW-Authenticate"); got != test.challenge {
+			t.Fatalf("%d: bad challenge: got %q want %q", i, got, test.challenge)
+		}
+		if test.code == http.StatusOK && principal != "alice" {
+			t.Fatalf("%d: bad principal: %v", i, principal)
+		}
+	}
+
+	// good was validated once, then served from the cache; errors other than
+	// rejections are not cached.
+	if calls != 4 {
+		t.Fatalf("bad number of validator calls: %d", calls)
+	}
+}
+
+func TestAPIKeyHandlerCacheScope(t *testing.T) {
+	calls := 0
+	validator := KeyValidatorFunc(func(r *http.Request, key string) (interface{}, error) {
+		calls++
+		if r.URL.Path == "/admin" {
+			return nil, ErrForbiddenAPIKey
+		}
+		return "alice", nil
+	})
+
+	serve := func(h http.Handler, path string) int {
+		r := newRequest(http.MethodGet, path)
+		r.Header.Set("X-API-Key", "key")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	// Forbidden keys aren't cached without a cache key, so that they aren't
+	// locked out of other routes.
+	h := APIKeyHandler(validator, APIKeyCache(time.Minute, 10))(okHandler)
+	if code := serve(h, "/admin"); code != http.StatusForbidden {
+		t.Fatalf("bad status for /admin: %v", code)
+	}
+	if code := serve(h, "/public"); code != http.StatusOK {
+		t.Fatalf("bad status for /public: %v", code)
+	}
+
+	// Scoped results don't let a key through other routes.
+	calls = 0
+	h = APIKeyHandler(validator, APIKeyCache(time.Minute, 10), APIKeyCacheKey(func(r *http.Request) string {
+		return r.URL.Path
+	}))(okHandler)
+	for i := 0; i < 2; i++ {
+		if code := serve(h, "/public"); code != http.StatusOK {
+			t.Fatalf("bad status for /public: %v", code)
+		}
+		if code := serve(h, "/admin"); code != http.StatusForbidden {
+			t.Fatalf("bad status for /admin: %v", code)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("bad number of validator calls: %d", calls)
+	}
+}
+
+func TestAPIKeyHandlerDefaultHeader(t *testing.T) {
+	h := APIKeyHandler(KeyValidatorFunc(func(r *http.Request, key string) (interface{}, error) {
+		if key != "secret" {
+			return nil, ErrInvalidAPIKey
+		}
+		return key, nil
+	}))(okHandler)
+
+	r := newRequest(http.MethodGet, "/")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != `APIKey realm="Restricted"` {
+		t.Fatalf("bad response: %v %v", rr.Code, rr.Header())
+	}
+
+	r.Header.Set("X-API-Key", "secret")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status: %v", rr.Code)
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const defaultAsyncLogBufferSize = 1024
+
+// ErrAsyncLogWriterClosed is returned by the Write method of an AsyncLogWriter
+// which has been closed.
+var ErrAsyncLogWriterClosed = errors.New("handlers: async log writer is closed")
+
+// AsyncLogWriter is an io.Writer queueing log lines in a bounded buffer, from
+// which a background goroutine writes them to an underlying writer. Used as
+// the writer of the logging handlers, it takes log I/O latency off the request
+// path.
+//
+// When the buffer is full, writes block until there is room, unless
+// AsyncLogDropWhenFull is set. Close must be called to write out the buffered
+// lines and stop the background goroutine, e.g. after the server has shut
+// down.
+type AsyncLogWriter struct {
+	w       io.Writer
+	lines   chan asyncLogLine
+	done    chan struct{}
+	drop    bool
+	dropped atomic.Uint64
+	size    int
+
+	mu     sync.RWMutex // guards closed against concurrent sends
+	closed bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+// asyncLogLine is a queued log line, or a flush marker if flushed is not nil.
+type asyncLogLine struct {
+	b       []byte
+	flushed chan struct{}
+}
+
+// AsyncLogOption provides a functional approach to configure an
+// AsyncLogWriter.
+type AsyncLogOption func(*AsyncLogWriter)
+
+// NewAsyncLogWriter returns an AsyncLogWriter writing to w, and starts its
+// background goroutine.
+//
+// Example:
+//
+//	out := handlers.NewAsyncLogWriter(logFile, handlers.AsyncLogDropWhenFull())
+//	defer out.Close()
+//	http.ListenAndServe(":8000", handlers.LoggingHandler(out, r))
+func NewAsyncLogWriter(w io.Writer, opts ...AsyncLogOption) *AsyncLogWriter {
+	a := &AsyncLogWriter{w: w, size: defaultAsyncLogBufferSize, done: make(chan struct{})}
+	for _, option := range opts {
+		option(a)
+	}
+	a.lines = make(chan asyncLogLine, a.size)
+	go a.run()
+	return a
+}
+
+// AsyncLogBufferSize is a functional option that sets the number of log lines
+// which can be queued. It defaults to 1024.
+func AsyncLogBufferSize(n int) AsyncLogOption {
+	return func(a *AsyncLogWriter) {
+		if n > 0 {
+			a.size = n
+		}
+	}
+}
+
+// AsyncLogDropWhenFull is a functional option that drops log lines when the
+// buffer is full instead of blocking the request until there is room. The
+// number of dropped lines is reported by Dropped.
+func AsyncLogDropWhenFull() AsyncLogOption {
+	return func(a *AsyncLogWriter) {
+		a.drop = true
+	}
+}
+
+// Write queues a copy of p to be written to the underlying writer. It never
+// reports the errors of the underlying writer, which are returned by Close
+// instead.
+func (a *AsyncLogWriter) Write(p []byte) (int, error) {
+	line := asyncLogLine{b: append([]byte(nil), p...)}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return 0, ErrAsyncLogWriterClosed
+	}
+
+	if a.drop {
+		select {
+		case a.lines <- line:
+		default:
+			a.dropped.Add(1)
+		}
+	} else {
+		a.lines <- line
+	}
+	return len(p), nil
+}
+
+// Flush blocks until the lines queued before the call have been written to
+// the underlying writer.
+func (a *AsyncLogWriter) Flush() {
+	flushed := make(chan struct{})
+
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return
+	}
+	a.lines <- asyncLogLine{flushed: flushed}
+	a.mu.RUnlock()
+
+	<-flushed
+}
+
+// Close writes out the queued lines and stops the background goroutine. It
+// returns the first error returned by the underlying writer, if any. The
+// underlying writer is not closed.
+func (a *AsyncLogWriter) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.lines)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	return a.err
+}
+
+// Dropped returns the number of log lines dropped because the buffer was
+// full.
+func (a *AsyncLogWriter) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+func (a *AsyncLogWriter) run() {
+	defer close(a.done)
+	for line := range a.lines {
+		if line.flushed != nil {
+			close(line.flushed)
+			continue
+		}
+		if _, err := a.w.Write(line.b); err != nil {
+			a.errMu.Lock()
+			if a.err == nil {
+				a.err = err
+			}
+			a.errMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAsyncLogWriter(t *testing.T) {
+	var buf lockedBuffer
+	out := NewAsyncLogWriter(&buf)
+	h := LoggingHandler(out, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := 0; i < 10; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/"))
+	}
+	out.Flush()
+	if got := strings.Count(buf.String(), "\n"); got != 10 {
+		t.Fatalf("got %d log lines after Flush, want 10", got)
+	}
+
+	if err := out.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := out.Write([]byte("late\n")); !errors.Is(err, ErrAsyncLogWriterClosed) {
+		t.Fatalf("Write after Close: got %v, want %v", err, ErrAsyncLogWriterClosed)
+	}
+}
+
+// blockingWriter blocks writes until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	err     error
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), w.err
+}
+
+func TestAsyncLogWriterDropWhenFull(t *testing.T) {
+	bw := &blockingWriter{release: make(chan struct{}), err: errors.New("disk full")}
+	out := NewAsyncLogWriter(bw, AsyncLogBufferSize(2), AsyncLogDropWhenFull())
+
+	// One line is held by the background goroutine and two are buffered, so
+	// at least 7 of these are dropped.
+	for i := 0; i < 10; i++ {
+		if _, err := out.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := out.Dropped(); got < 7 {
+		t.Fatalf("got %d dropped lines, want at least 7", got)
+	}
+
+	close(bw.release)
+	if err := out.Close(); err == nil || err.Error() != "disk full" {
+		t.Fatalf("Close: got %v, want the write error", err)
+	}
+}
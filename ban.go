@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BanList is HTTP middleware rejecting clients that have been banned
+// temporarily. Clients are identified by a key, their IP address by default.
+// Bans are added at runtime by operators or other middleware, or
+// automatically after repeated error responses (see BanAfter), and expire on
+// their own.
+type BanList struct {
+	keyFunc  func(*http.Request) string
+	code     int
+	response http.Handler
+	now      func() time.Time
+
+	strikeCodes     []int
+	strikeThreshold int
+	strikeWindow    time.Duration
+	strikeBan       time.Duration
+
+	mu        sync.Mutex
+	bans      map[string]time.Time
+	strikes   map[string]*banStrikes
+	lastSweep time.Time
+}
+
+type banStrikes struct {
+	count int
+	start time.Time
+}
+
+// BanListOption provides a functional approach to configure a BanList.
+type BanListOption func(*BanList)
+
+// NewBanList returns an empty BanList.
+//
+// Example, banning clients for an hour after 10 failed logins in a minute:
+//
+//	bans := handlers.NewBanList(handlers.BanAfter(10, time.Minute, time.Hour, http.StatusUnauthorized))
+//	r.Handle("/login", bans.Handler(loginHandler))
+func NewBanList(opts ...BanListOption) *BanList {
+	b := &BanList{
+		keyFunc: func(r *http.Request) string {
+			if ip, ok := remoteIP(r); ok {
+				return ip.String()
+			}
+			return r.RemoteAddr
+		},
+		code:    http.StatusTooManyRequests,
+		now:     time.Now,
+		bans:    make(map[string]time.Time),
+		strikes: make(map[string]*banStrikes),
+	}
+	for _, option := range opts {
+		option(b)
+	}
+	return b
+}
+
+// BanKey is a functional option that sets the function identifying clients,
+// e.g. by API key or user name. Requests for which fn returns "" are never
+// rejected.
+func BanKey(fn func(*http.Request) string) BanListOption {
+	return func(b *BanList) {
+		b.keyFunc = fn
+	}
+}
+
+// BanStatus is a functional option that sets the status code of the response
+// to banned clients, http.StatusTooManyRequests by default. Use
+// http.StatusForbidden to not suggest that the client may simply slow down.
+func BanStatus(code int) BanListOption {
+	return func(b *BanList) {
+		b.code = code
+	}
+}
+
+// BanResponse is a functional option that sets the handler answering banned
+// clients. The Retry-After header is set before it is called.
+func BanResponse(h http.Handler) BanListOption {
+	return func(b *BanList) {
+		b.response = h
+	}
+}
+
+// BanAfter is a functional option that bans a client for banFor once the
+// handler has answered it with one of the given status codes threshold times
+// within window.
+func BanAfter(threshold int, window, banFor time.Duration, codes ...int) BanListOption {
+	return func(b *BanList) {
+		b.strikeThreshold = threshold
+		b.strikeWindow = window
+		b.strikeBan = banFor
+		b.strikeCodes = codes
+	}
+}
+
+// Key returns the key identifying the client of r.
+func (b *BanList) Key(r *http.Request) string {
+	return b.keyFunc(r)
+}
+
+// Ban bans the client identified by key for d. An existing ban is only ever
+// extended.
+func (b *BanList) Ban(key string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ban(key, d)
+}
+
+// BanRequest bans the client of r for d.
+func (b *BanList) BanRequest(r *http.Request, d time.Duration) {
+	if key := b.Key(r); key != "" {
+		b.Ban(key, d)
+	}
+}
+
+// Unban lifts the ban on the client identified by key.
+func (b *BanList) Unban(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.bans, key)
+	delete(b.strikes, key)
+}
+
+// Banned returns the remaining ban duration of the client identified by key
+// and whether it is banned.
+func (b *BanList) Banned(key string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.banned(key)
+}
+
+// Handler returns a http.Handler rejecting banned clients and calling h for
+// everyone else.
+func (b *BanList) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := b.Key(r)
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if remaining, banned := b.Banned(key); banned {
+			setRetryAfter(w.Header(), remaining)
+			if b.response != nil {
+				b.response.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(b.code), b.code)
+			return
+		}
+
+		if b.strikeThreshold <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		logger, w := makeLogger(w)
+		h.ServeHTTP(w, r)
+		for _, code := range b.strikeCodes {
+			if logger.Status() == code {
+				b.strike(key)
+				break
+			}
+		}
+	})
+}
+
+func (b *BanList) strike(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	// clients staying under the threshold never get banned, so sweep here too
+	b.sweep(now)
+	s := b.strikes[key]
+	if s == nil || now.Sub(s.start) >= b.strikeWindow {
+		s = &banStrikes{start: now}
+		b.strikes[key] = s
+	}
+	s.count++
+	if s.count >= b.strikeThreshold {
+		delete(b.strikes, key)
+		b.ban(key, b.strikeBan)
+	}
+}
+
+// ban must be called with b.mu held.
+func (b *BanList) ban(key string, d time.Duration) {
+	now := b.now()
+	b.sweep(now)
+	until := now.Add(d)
+	if until.After(b.bans[key]) {
+		b.bans[key] = until
+	}
+}
+
+// banned must be called with b.mu held.
+func (b *BanList) banned(key string) (time.Duration, bool) {
+	until, ok := b.bans[key]
+	if !ok {
+		return 0, false
+	}
+	remaining := until.Sub(b.now())
+	if remaining <= 0 {
+		delete(b.bans, key)
+		return 0, false
+	}
+	return remaining, true
+}
+
+// sweep drops expired bans and strikes, at most once a minute. It must be
+// called with b.mu held.
+func (b *BanList) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < time.Minute {
+		return
+	}
+	b.lastSweep = now
+	for key, until := range b.bans {
+		if !now.Before(until) {
+			delete(b.bans, key)
+		}
+	}
+	for key, s := range b.strikes {
+		if now.Sub(s.start) >= b.strikeWindow {
+			delete(b.strikes, key)
+		}
+	}
+}
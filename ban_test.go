@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	now := time.Now()
+	b := NewBanList(BanStatus(http.StatusForbidden))
+	b.now = func() time.Time { return now }
+	h := b.Handler(okHandler)
+
+	r := newRequest(http.MethodGet, "/")
+	r.RemoteAddr = "192.0.2.1:1234"
+	if b.Key(r) != "192.0.2.1" {
+		t.Fatalf("bad key: %q", b.Key(r))
+	}
+
+	b.BanRequest(r, 90*time.Second)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusForbidden || rr.Header().Get("Retry-After") != "90" {
+		t.Fatalf("bad response: %v %v", rr.Code, rr.Header())
+	}
+
+	// Shorter bans do not shorten existing ones.
+	b.Ban("192.0.2.1", time.Second)
+	if remaining, _ := b.Banned("192.0.2.1"); remaining != 90*time.Second {
+		t.Fatalf("bad remaining ban: %v", remaining)
+	}
+
+	now = now.Add(90 * time.Second)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ban did not expire: %v", rr.Code)
+	}
+
+	b.Ban("192.0.2.1", time.Minute)
+	b.Unban("192.0.2.1")
+	if _, banned := b.Banned("192.0.2.1"); banned {
+		t.Fatal("client still banned after Unban")
+	}
+}
+
+func TestBanAfter(t *testing.T) {
+	b := NewBanList(BanAfter(2, time.Minute, time.Hour, http.StatusUnauthorized))
+	h := b.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	r := newRequest(http.MethodGet, "/login")
+	r.RemoteAddr = "[2001:db8::1]:443"
+	codes := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, want := range codes {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != want {
+			t.Fatalf("%d: bad status: got %v want %v", i, rr.Code, want)
+		}
+	}
+	if _, banned := b.Banned("2001:db8::1"); !banned {
+		t.Fatal("client not banned")
+	}
+}
+
+func TestBanStrikesSwept(t *testing.T) {
+	now := time.Now()
+	b := NewBanList(BanAfter(3, time.Minute, time.Hour, http.StatusNotFound))
+	b.now = func() time.Time { return now }
+	h := b.Handler(http.NotFoundHandler())
+
+	// Each client stays under the threshold.
+	for i := 0; i < 100; i++ {
+		r := newRequest(http.MethodGet, "/missing")
+		r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		now = now.Add(time.Second)
+	}
+
+	// Their strikes are dropped once the window is over.
+	now = now.Add(time.Minute)
+	r := newRequest(http.MethodGet, "/missing")
+	r.RemoteAddr = "198.51.100.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	b.mu.Lock()
+	n := len(b.strikes)
+	b.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("%d strikes kept", n)
+	}
+}
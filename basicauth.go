@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const (
+	wwwAuthenticateHeader = "WWW-Authenticate"
+	defaultBasicAuthRealm = "Restricted"
+)
+
+type basicAuthUserKey struct{}
+
+// BasicAuthValidator reports whether the credentials supplied with a request
+// are valid. It is given the request so that implementations backed by a
+// database or a directory can take its context, client address or path into
+// account.
+type BasicAuthValidator func(username, password string, r *http.Request) bool
+
+type basicAuthHandler struct {
+	h         http.Handler
+	validator BasicAuthValidator
+	realm     string
+	onFailure func(r *http.Request, username string)
+}
+
+// BasicAuthOption provides a functional approach to configure the
+// BasicAuthHandler middleware.
+type BasicAuthOption func(*basicAuthHandler)
+
+// BasicAuthHandler is HTTP middleware that requires requests to carry HTTP
+// Basic credentials (RFC 7617) accepted by validator. Other requests are
+// answered with a 401 "Unauthorized" and a WWW-Authenticate challenge.
+//
+// The name of the authenticated user is available to the next handler via
+// BasicAuthUserFromContext.
+//
+// Basic authentication sends credentials in the clear and must only be used
+// over TLS.
+//
+// Example:
+//
+//	auth := handlers.BasicAuthHandler(
+//		handlers.BasicAuthCredentials(map[string]string{"admin": os.Getenv("ADMIN_PASSWORD")}),
+//		handlers.BasicAuthRealm("Admin area"),
+//	)
+//	r.Handle("/admin", auth(AdminHandler))
+func BasicAuthHandler(validator BasicAuthValidator, opts ...BasicAuthOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		b := &basicAuthHandler{h: h, validator: validator, realm: defaultBasicAuthRealm}
+		for _, option := range opts {
+			option(b)
+		}
+		return b
+	}
+}
+
+// BasicAuthRealm is a functional option that sets the realm advertised in the
+// authentication challenge.
+func BasicAuthRealm(realm string) BasicAuthOption {
+	return func(b *basicAuthHandler) {
+		b.realm = realm
+	}
+}
+
+// BasicAuthOnFailure is a functional option that registers fn to be called
+// whenever a request is rejected, e.g. to log the failure or to count failed
+// attempts towards a lockout enforced by the validator. username is empty if
+// the request carried no credentials.
+func BasicAuthOnFailure(fn func(r *http.Request, username string)) BasicAuthOption {
+	return func(b *basicAuthHandler) {
+		b.onFailure = fn
+	}
+}
+
+// BasicAuthCredentials returns a BasicAuthValidator accepting the given
+// username to password pairs. Comparisons are made in constant time, and take
+// the same time whether or not the username exists.
+func BasicAuthCredentials(credentials map[string]string) BasicAuthValidator {
+	hashed := make(map[string][sha256.Size]byte, len(credentials))
+	for user, pass := range credentials {
+		hashed[user] = sha256.Sum256([]byte(pass))
+	}
+	// Compared against when the user doesn't exist, so that timing doesn't
+	// reveal valid usernames.
+	var missing [sha256.Size]byte
+
+	return func(username, password string, r *http.Request) bool {
+		want, ok := hashed[username]
+		if !ok {
+			want = missing
+		}
+		got := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && ok
+	}
+}
+
+// BasicAuthUserFromContext returns the name of the user authenticated by
+// BasicAuthHandler, or the empty string if there is none.
+func BasicAuthUserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(basicAuthUserKey{}).(string)
+	return user
+}
+
+func (b *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || !b.validator(username, password, r) {
+		if b.onFailure != nil {
+			b.onFailure(r, username)
+		}
+		w.Header().Set(wwwAuthenticateHeader, `Basic realm=`+quoteAuthParam(b.realm)+`, charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	b.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), basicAuthUserKey{}, username)))
+}
+
+// quoteAuthParam renders s as a quoted-string for use in an authentication
+// parameter.
+func quoteAuthParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
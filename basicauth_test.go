@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBasicAuthHandler(t *testing.T) {
+	var failures []string
+	var user string
+	h := BasicAuthHandler(
+		BasicAuthCredentials(map[string]string{"gopher": "secret"}),
+		BasicAuthRealm(`My "realm"`),
+		BasicAuthOnFailure(func(r *http.Request, username string) {
+			failures = append(failures, username)
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = BasicAuthUserFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name       string
+		user, pass string
+		set        bool
+		code       int
+	}{
+		{"valid", "gopher", "secret", true, http.StatusOK},
+		{"wrong password", "gopher", "guess", true, http.StatusUnauthorized},
+		{"unknown user", "mole", "secret", true, http.StatusUnauthorized},
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		user = ""
+		r := newRequest(http.MethodGet, "/")
+		if tt.set {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if rr.Code != tt.code {
+			t.Fatalf("%s: bad status: got %v want %v", tt.name, rr.Code, tt.code)
+		}
+		if tt.code == http.StatusOK {
+			if user != tt.user {
+				t.Fatalf("%s: bad user in context: got %q want %q", tt.name, user, tt.user)
+			}
+			continue
+		}
+		want := `Basic realm="My \"realm\"", charset="UTF-8"`
+		if got := rr.Header().Get("WWW-Authenticate"); got != want {
+			t.Fatalf("%s: bad challenge: got %q want %q", tt.name, got, want)
+		}
+	}
+
+	if len(failures) != 3 || failures[0] != "gopher" || failures[1] != "mole" || failures[2] != "" {
+		t.Fatalf("bad failure callbacks: %q", failures)
+	}
+}
+
+func TestBasicAuthValidatorRequest(t *testing.T) {
+	h := BasicAuthHandler(func(username, password string, r *http.Request) bool {
+		return r.URL.Path == "/"+username
+	})(okHandler)
+
+	r := newRequest(http.MethodGet, "/gopher")
+	r.SetBasicAuth("gopher", "")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
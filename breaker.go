@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial requests through.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker is HTTP middleware that stops calling a failing handler.
+//
+// A closed breaker trips open after a number of consecutive failures, or once
+// the failure rate within a window exceeds a threshold. Failures are
+// responses with a status of 500 or greater and panics. While open, requests
+// are answered with a 503 without calling the handler. After the open timeout
+// the breaker half-opens and lets trial requests through: it closes again if
+// they all succeed and reopens on the first failure.
+type CircuitBreaker struct {
+	maxConsecutive int
+	failureRate    float64
+	minRequests    int
+	window         time.Duration
+	openTimeout    time.Duration
+	halfOpenMax    int
+	isFailure      func(code int) bool
+	onStateChange  func(from, to CircuitState)
+	response       http.Handler
+	now            func() time.Time
+
+	mu          sync.Mutex
+	state       CircuitState
+	generation  uint64
+	openUntil   time.Time
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+	trials      int
+	successes   int
+}
+
+// CircuitBreakerOption provides a functional approach to configure a
+// CircuitBreaker.
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// NewCircuitBreaker returns a closed CircuitBreaker. By default it trips after
+// 5 consecutive failures, stays open for 30 seconds and closes after a single
+// successful trial request.
+//
+// Example:
+//
+//	cb := handlers.NewCircuitBreaker(
+//		handlers.CircuitBreakerFailureRate(0.5, 20, time.Minute),
+//		handlers.CircuitBreakerOnStateChange(func(from, to handlers.CircuitState) {
+//			log.Printf("backend circuit %s -> %s", from, to)
+//		}),
+//	)
+//	r.Handle("/search", cb.Handler(searchHandler))
+func NewCircuitBreaker(opts ...CircuitBreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		maxConsecutive: 5,
+		openTimeout:    30 * time.Second,
+		halfOpenMax:    1,
+		isFailure: func(code int) bool {
+			return code >= http.StatusInternalServerError
+		},
+		now: time.Now,
+	}
+	for _, option := range opts {
+		option(cb)
+	}
+	return cb
+}
+
+// CircuitBreakerConsecutiveFailures is a functional option that sets the
+// number of consecutive failures tripping the breaker. Zero disables the
+// consecutive failure threshold.
+func CircuitBreakerConsecutiveFailures(n int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.maxConsecutive = n
+	}
+}
+
+// CircuitBreakerFailureRate is a functional option that trips the breaker
+// once the ratio of failures to requests within a window of the given
+// duration reaches rate, provided at least minRequests were served in it.
+func CircuitBreakerFailureRate(rate float64, minRequests int, window time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.failureRate = rate
+		cb.minRequests = minRequests
+		cb.window = window
+	}
+}
+
+// CircuitBreakerOpenTimeout is a functional option that sets how long the
+// breaker stays open before half-opening.
+func CircuitBreakerOpenTimeout(d time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.openTimeout = d
+	}
+}
+
+// CircuitBreakerHalfOpenRequests is a functional option that sets the number
+// of trial requests let through while half-open, all of which must succeed for
+// the breaker to close.
+func CircuitBreakerHalfOpenRequests(n int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		if n > 0 {
+			cb.halfOpenMax = n
+		}
+	}
+}
+
+// CircuitBreakerIsFailure is a functional option that sets the function
+// deciding whether a response status counts as a failure.
+func CircuitBreakerIsFailure(fn func(code int) bool) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.isFailure = fn
+	}
+}
+
+// CircuitBreakerOnStateChange is a functional option that registers fn to be
+// called whenever the breaker changes state.
+func CircuitBreakerOnStateChange(fn func(from, to CircuitState)) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.onStateChange = fn
+	}
+}
+
+// CircuitBreakerResponse is a functional option that sets the handler
+// answering rejected requests. The Retry-After header is set before it is
+// called, when known.
+func CircuitBreakerResponse(h http.Handler) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.response = h
+	}
+}
+
+// State returns the current state of the breaker.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen && !cb.now().Before(cb.openUntil) {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+// Reset closes the breaker and clears its failure counts.
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	from := cb.state
+	cb.setState(CircuitClosed)
+	cb.mu.Unlock()
+	cb.notify(from, CircuitClosed)
+}
+
+// Handler returns a http.Handler calling h through the breaker.
+func (cb *CircuitBreaker) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		generation, retryAfter, ok := cb.allow()
+		if !ok {
+			setRetryAfter(w.Header(), retryAfter)
+			if cb.response != nil {
+				cb.response.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		logger, w := makeLogger(w)
+		completed := false
+		defer func() {
+			cb.record(generation, !completed || cb.isFailure(logger.Status()))
+		}()
+		h.ServeHTTP(w, r)
+		completed = true
+	})
+}
+
+// allow reports whether a request may proceed, along with the generation of
+// the state it was admitted in, or how long the breaker stays open if not.
+func (cb *CircuitBreaker) allow() (uint64, time.Duration, bool) {
+	cb.mu.Lock()
+	from := cb.state
+	now := cb.now()
+	if cb.state == CircuitOpen {
+		if now.Before(cb.openUntil) {
+			cb.mu.Unlock()
+			return 0, cb.openUntil.Sub(now), false
+		}
+		cb.setState(CircuitHalfOpen)
+	}
+	ok := true
+	if cb.state == CircuitHalfOpen {
+		ok = cb.trials < cb.halfOpenMax
+		if ok {
+			cb.trials++
+		}
+	}
+	generation, to := cb.generation, cb.state
+	cb.mu.Unlock()
+	cb.notify(from, to)
+	return generation, 0, ok
+}
+
+// record records the outcome of a request admitted in generation.
+func (cb *CircuitBreaker) record(generation uint64, failed bool) {
+	cb.mu.Lock()
+	from := cb.state
+	if generation != cb.generation {
+		// The state changed while the request was being served.
+		cb.mu.Unlock()
+		return
+	}
+	switch cb.state {
+	case CircuitClosed:
+		if failed {
+			cb.consecutive++
+		} else {
+			cb.consecutive = 0
+		}
+		if cb.window > 0 {
+			now := cb.now()
+			if now.Sub(cb.windowStart) >= cb.window {
+				cb.windowStart, cb.requests, cb.failures = now, 0, 0
+			}
+			cb.requests++
+			if failed {
+				cb.failures++
+			}
+		}
+		if cb.shouldTrip() {
+			cb.setState(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			cb.setState(CircuitOpen)
+		} else if cb.successes++; cb.successes >= cb.halfOpenMax {
+			cb.setState(CircuitClosed)
+		}
+	}
+	to := cb.state
+	cb.mu.Unlock()
+	cb.notify(from, to)
+}
+
+func (cb *CircuitBreaker) shouldTrip() bool {
+	if cb.maxConsecutive > 0 && cb.consecutive >= cb.maxConsecutive {
+		return true
+	}
+	return cb.window > 0 && cb.requests >= cb.minRequests &&
+		float64(cb.failures) >= cb.failureRate*float64(cb.requests)
+}
+
+// setState switches to state s, resetting the counts. It must be called with
+// cb.mu held.
+func (cb *CircuitBreaker) setState(s CircuitState) {
+	cb.state = s
+	cb.generation++
+	cb.consecutive, cb.requests, cb.failures = 0, 0, 0
+	cb.trials, cb.successes = 0, 0
+	cb.windowStart = cb.now()
+	if s == CircuitOpen {
+		cb.openUntil = cb.windowStart.Add(cb.openTimeout)
+	}
+}
+
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && cb.onStateChange != nil {
+		cb.onStateChange(from, to)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	var changes []string
+	cb := NewCircuitBreaker(
+		CircuitBreakerConsecutiveFailures(2),
+		CircuitBreakerOpenTimeout(10*time.Second),
+		CircuitBreakerOnStateChange(func(from, to CircuitState) {
+			changes = append(changes, from.String()+">"+to.String())
+		}),
+	)
+	cb.now = func() time.Time { return now }
+
+	fail := true
+	h := cb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(ok))
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+		return rr
+	}
+
+	serve()
+	if cb.State() != CircuitClosed {
+		t.Fatalf("tripped after a single failure")
+	}
+	serve()
+	if cb.State() != CircuitOpen {
+		t.Fatalf("bad state: got %v want %v", cb.State(), CircuitOpen)
+	}
+
+	rr := serve()
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "10" {
+		t.Fatalf("bad open response: %v %v", rr.Code, rr.Header())
+	}
+
+	// A failed trial reopens the breaker.
+	now = now.Add(10 * time.Second)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("bad state: got %v want %v", cb.State(), CircuitHalfOpen)
+	}
+	if rr := serve(); rr.Code != http.StatusBadGateway {
+		t.Fatalf("trial request not let through: %v", rr.Code)
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("bad state: got %v want %v", cb.State(), CircuitOpen)
+	}
+
+	// A successful trial closes it.
+	now = now.Add(10 * time.Second)
+	fail = false
+	if rr := serve(); rr.Code != http.StatusOK {
+		t.Fatalf("trial request not let through: %v", rr.Code)
+	}
+	if cb.State() != CircuitClosed {
+		t.Fatalf("bad state: got %v want %v", cb.State(), CircuitClosed)
+	}
+
+	want := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("bad state changes: got %v want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("bad state changes: got %v want %v", changes, want)
+		}
+	}
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	cb := NewCircuitBreaker(
+		CircuitBreakerConsecutiveFailures(0),
+		CircuitBreakerFailureRate(0.5, 4, time.Minute),
+	)
+	code := http.StatusOK
+	h := cb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if code == 0 {
+			panic("boom")
+		}
+		w.WriteHeader(code)
+	}))
+
+	for _, c := range []int{http.StatusOK, http.StatusInternalServerError, http.StatusOK} {
+		code = c
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	}
+	if cb.State() != CircuitClosed {
+		t.Fatalf("tripped below the minimum number of requests")
+	}
+
+	code = 0
+	func() {
+		defer func() { _ = recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	}()
+	if cb.State() != CircuitOpen {
+		t.Fatalf("bad state: got %v want %v", cb.State(), CircuitOpen)
+	}
+
+	cb.Reset()
+	if cb.State() != CircuitClosed {
+		t.Fatalf("bad state after reset: %v", cb.State())
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+const (
+	cacheControlHeader     = "Cache-Control"
+	expiresHeader          = "Expires"
+	surrogateControlHeader = "Surrogate-Control"
+)
+
+// CachePolicy describes the caching headers applied to a response by
+// CacheControlHandler. Empty fields leave the corresponding header untouched.
+type CachePolicy struct {
+	// CacheControl is the value of the Cache-Control header, e.g.
+	// "public, max-age=31536000, immutable" or "no-store".
+	CacheControl string
+	// Expires, if positive, sets the Expires header to the time of the
+	// response plus Expires, for the benefit of HTTP/1.0 caches.
+	Expires time.Duration
+	// SurrogateControl is the value of the Surrogate-Control header, which is
+	// honoured by CDNs and stripped before the response reaches clients.
+	SurrogateControl string
+	// Override replaces headers already set by the wrapped handler. By
+	// default, a handler setting Cache-Control itself keeps its value.
+	Override bool
+}
+
+type cachePolicyRule struct {
+	prefix string
+	policy CachePolicy
+}
+
+type cacheControlHandler struct {
+	h             http.Handler
+	paths         []cachePolicyRule
+	contentTypes  []cachePolicyRule
+	defaultPolicy *CachePolicy
+}
+
+// CacheControlOption provides a functional approach to configure the
+// CacheControlHandler middleware.
+type CacheControlOption func(*cacheControlHandler)
+
+// CacheControlHandler is HTTP middleware that applies Cache-Control, Expires
+// and Surrogate-Control headers to responses according to a table of
+// policies, so that static assets and API responses get consistent caching
+// directives without touching every handler.
+//
+// The policy for a response is chosen as follows: the policy of the longest
+// matching path prefix (see CachePathPolicy) if any, otherwise that of the
+// longest matching Content-Type prefix (see CacheContentTypePolicy), otherwise
+// the default policy (see CacheDefaultPolicy). Policies are only applied to
+// responses with a status code below 400, so that errors are never cached
+// for long.
+//
+// Example:
+//
+//	cache := handlers.CacheControlHandler(
+//		handlers.CachePathPolicy("/static/", handlers.CachePolicy{CacheControl: "public, max-age=31536000, immutable"}),
+//		handlers.CacheContentTypePolicy("application/json", handlers.CachePolicy{CacheControl: "no-cache"}),
+//		handlers.CacheDefaultPolicy(handlers.CachePolicy{CacheControl: "no-store"}),
+//	)
+//	http.ListenAndServe(":8000", cache(r))
+func CacheControlHandler(opts ...CacheControlOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		c := &cacheControlHandler{h: h}
+		for _, option := range opts {
+			option(c)
+		}
+
+		// Longest prefix first.
+		for _, rules := range [][]cachePolicyRule{c.paths, c.contentTypes} {
+			rules := rules
+			sort.SliceStable(rules, func(i, j int) bool {
+				return len(rules[i].prefix) > len(rules[j].prefix)
+			})
+		}
+		return c
+	}
+}
+
+// CachePathPolicy is a functional option that applies p to requests whose
+// path starts with prefix.
+func CachePathPolicy(prefix string, p CachePolicy) CacheControlOption {
+	return func(c *cacheControlHandler) {
+		c.paths = append(c.paths, cachePolicyRule{prefix, p})
+	}
+}
+
+// CacheContentTypePolicy is a functional option that applies p to responses
+// whose Content-Type starts with prefix, e.g. "image/" or "text/html".
+func CacheContentTypePolicy(prefix string, p CachePolicy) CacheControlOption {
+	return func(c *cacheControlHandler) {
+		c.contentTypes = append(c.contentTypes, cachePolicyRule{prefix, p})
+	}
+}
+
+// CacheDefaultPolicy is a functional option that applies p to responses not
+// covered by any other policy.
+func CacheDefaultPolicy(p CachePolicy) CacheControlOption {
+	return func(c *cacheControlHandler) {
+		c.defaultPolicy = &p
+	}
+}
+
+func (c *cacheControlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var applied bool
+	apply := func(code int) {
+		if applied {
+			return
+		}
+		applied = true
+		if code < http.StatusBadRequest {
+			if p := c.policyFor(r, w.Header()); p != nil {
+				p.apply(w.Header(), time.Now())
+			}
+		}
+	}
+
+	c.h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+					apply(code)
+				}
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				apply(http.StatusOK)
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				apply(http.StatusOK)
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				apply(http.StatusOK)
+				next()
+			}
+		},
+	}), r)
+}
+
+// policyFor returns the policy applying to the response to r with headers h,
+// or nil if there is none.
+func (c *cacheControlHandler) policyFor(r *http.Request, h http.Header) *CachePolicy {
+	for i := range c.paths {
+		if strings.HasPrefix(r.URL.Path, c.paths[i].prefix) {
+			return &c.paths[i].policy
+		}
+	}
+	if ct := h.Get("Content-Type"); ct != "" {
+		for i := range c.contentTypes {
+			if strings.HasPrefix(ct, c.contentTypes[i].prefix) {
+				return &c.contentTypes[i].policy
+			}
+		}
+	}
+	return c.defaultPolicy
+}
+
+// apply sets the headers described by p on h for a response generated at now.
+func (p *CachePolicy) apply(h http.Header, now time.Time) {
+	set := func(key, value string) {
+		if value == "" || (!p.Override && h.Get(key) != "") {
+			return
+		}
+		h.Set(key, value)
+	}
+
+	set(cacheControlHeader, p.CacheControl)
+	set(surrogateControlHeader, p.SurrogateControl)
+	if p.Expires > 0 {
+		set(expiresHeader, now.Add(p.Expires).UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheControlHandler(t *testing.T) {
+	content := func(ct string, code int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", ct)
+			w.WriteHeader(code)
+		})
+	}
+	preset := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private")
+		_, _ = w.Write([]byte(ok))
+	})
+
+	opts := []CacheControlOption{
+		CachePathPolicy("/static/", CachePolicy{CacheControl: "public, max-age=31536000, immutable", SurrogateControl: "max-age=86400"}),
+		CachePathPolicy("/static/live/", CachePolicy{CacheControl: "no-cache", Override: true}),
+		CacheContentTypePolicy("image/", CachePolicy{CacheControl: "public, max-age=3600"}),
+		CacheDefaultPolicy(CachePolicy{CacheControl: "no-store"}),
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		handler   http.Handler
+		cc        string
+		surrogate string
+	}{
+		{"path prefix", "/static/app.js", content("text/javascript", http.StatusOK), "public, max-age=31536000, immutable", "max-age=86400"},
+		{"longest path prefix", "/static/live/feed", preset, "no-cache", ""},
+		{"content type", "/avatar", content("image/png", http.StatusOK), "public, max-age=3600", ""},
+		{"default", "/api", okHandler, "no-store", ""},
+		{"handler value kept", "/api", preset, "private", ""},
+		{"error status", "/static/missing.js", content("text/plain", http.StatusNotFound), "", ""},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		CacheControlHandler(opts...)(tt.handler).ServeHTTP(rr, newRequest(http.MethodGet, tt.path))
+
+		if got := rr.Header().Get("Cache-Control"); got != tt.cc {
+			t.Errorf("%s: bad Cache-Control: got %q want %q", tt.name, got, tt.cc)
+		}
+		if got := rr.Header().Get("Surrogate-Control"); got != tt.surrogate {
+			t.Errorf("%s: bad Surrogate-Control: got %q want %q", tt.name, got, tt.surrogate)
+		}
+	}
+}
+
+func TestCacheControlHandlerExpires(t *testing.T) {
+	rr := httptest.NewRecorder()
+	CacheControlHandler(CacheDefaultPolicy(CachePolicy{Expires: time.Hour}))(okHandler).
+		ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	expires, err := http.ParseTime(rr.Header().Get("Expires"))
+	if err != nil {
+		t.Fatalf("bad Expires header: %v", err)
+	}
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("bad Expires header: %v from now", d)
+	}
+}
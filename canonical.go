@@ -3,18 +3,33 @@ package handlers
 import (
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
+const strictTransportSecurityHeader = "Strict-Transport-Security"
+
 type canonical struct {
-	h      http.Handler
-	domain string
-	code   int
+	h             http.Handler
+	domain        string
+	code          int
+	hsts          string
+	keepQuery     bool
+	keepFragment  bool
+	keepUserInfo  bool
+	lowercasePath bool
 }
 
+// CanonicalOption provides a functional approach to configure optional
+// behaviour of the CanonicalHostWithOptions middleware.
+type CanonicalOption func(*canonical)
+
 // CanonicalHost is HTTP middleware that re-directs requests to the canonical
 // domain. It accepts a domain and a status code (e.g. 301 or 302) and
-// re-directs clients to this domain. The existing request path is maintained.
+// re-directs clients to this domain. The existing request path and query
+// string are maintained; see CanonicalHostWithOptions for finer control over
+// the components of the re-direct location.
 //
 // Note: If the provided domain is considered invalid by url.Parse or otherwise
 // returns an empty scheme or host, clients are not re-directed.
@@ -27,14 +42,105 @@ type canonical struct {
 //
 //	log.Fatal(http.ListenAndServe(":7000", canonical(r)))
 func CanonicalHost(domain string, code int) func(h http.Handler) http.Handler {
+	return CanonicalHostWithOptions(domain, code)
+}
+
+// CanonicalHostWithOptions is like CanonicalHost, with the optional behaviour
+// configured by the Canonical* options.
+//
+// Example:
+//
+//	canonical := handlers.CanonicalHostWithOptions("https://www.gorillatoolkit.org", 301,
+//		handlers.CanonicalHSTS(365*24*time.Hour, true, false),
+//		handlers.CanonicalLowercasePath(),
+//	)
+func CanonicalHostWithOptions(domain string, code int, opts ...CanonicalOption) func(h http.Handler) http.Handler {
 	fn := func(h http.Handler) http.Handler {
-		return canonical{h, domain, code}
+		c := canonical{h: h, domain: domain, code: code, keepQuery: true}
+		for _, option := range opts {
+			option(&c)
+		}
+		return c
 	}
 
 	return fn
 }
 
+// CanonicalHSTS is a functional option that sets a Strict-Transport-Security
+// header on the responses served by the CanonicalHostWithOptions middleware over HTTPS,
+// both on re-directs and on requests passed through to the next handler. This
+// allows host canonicalization and HSTS onboarding to be handled in one place.
+// As required by RFC 6797, section 7.2, the header is not sent over plain
+// HTTP; place ProxyHeaders in front of the middleware when TLS is terminated by
+// a reverse proxy.
+//
+// A maxAge of zero or less instructs clients to forget any previously cached
+// policy, as described in RFC 6797, section 6.1.1.
+func CanonicalHSTS(maxAge time.Duration, includeSubDomains, preload bool) CanonicalOption {
+	return func(c *canonical) {
+		c.hsts = hstsValue(maxAge, includeSubDomains, preload)
+	}
+}
+
+// CanonicalKeepQuery is a functional option that controls whether the query
+// string of the original request is preserved in the re-direct location. The
+// query string is preserved by default.
+func CanonicalKeepQuery(keep bool) CanonicalOption {
+	return func(c *canonical) {
+		c.keepQuery = keep
+	}
+}
+
+// CanonicalKeepFragment is a functional option that controls whether a URL
+// fragment is preserved in the re-direct location. Browsers never send
+// fragments, but some proxies and non-browser clients forward them as part of
+// the request URI. Fragments are stripped by default.
+func CanonicalKeepFragment(keep bool) CanonicalOption {
+	return func(c *canonical) {
+		c.keepFragment = keep
+	}
+}
+
+// CanonicalKeepUserInfo is a functional option that controls whether the
+// userinfo (user:password@) component of the request URL is preserved in the
+// re-direct location. Userinfo is stripped by default.
+func CanonicalKeepUserInfo(keep bool) CanonicalOption {
+	return func(c *canonical) {
+		c.keepUserInfo = keep
+	}
+}
+
+// CanonicalLowercasePath is a functional option that lowercases the request
+// path as part of canonicalization. Requests for the canonical host whose path
+// contains upper-case characters are re-directed as well.
+func CanonicalLowercasePath() CanonicalOption {
+	return func(c *canonical) {
+		c.lowercasePath = true
+	}
+}
+
+// hstsValue builds the value of a Strict-Transport-Security header.
+func hstsValue(maxAge time.Duration, includeSubDomains, preload bool) string {
+	secs := int64(maxAge / time.Second)
+	if secs < 0 {
+		secs = 0
+	}
+
+	v := "max-age=" + strconv.FormatInt(secs, 10)
+	if includeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if preload {
+		v += "; preload"
+	}
+	return v
+}
+
 func (c canonical) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.hsts != "" && isHTTPS(r) {
+		w.Header().Set(strictTransportSecurityHeader, c.hsts)
+	}
+
 	dest, err := url.Parse(c.domain)
 	if err != nil {
 		// Call the next handler if the provided domain fails to parse.
@@ -49,19 +155,36 @@ func (c canonical) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !strings.EqualFold(cleanHost(r.Host), dest.Host) {
-		// Re-build the destination URL
-		dest := dest.Scheme + "://" + dest.Host + r.URL.Path
-		if r.URL.RawQuery != "" {
-			dest += "?" + r.URL.RawQuery
-		}
-		http.Redirect(w, r, dest, c.code)
+	path := r.URL.Path
+	if c.lowercasePath {
+		path = strings.ToLower(path)
+	}
+
+	if !strings.EqualFold(cleanHost(r.Host), dest.Host) || path != r.URL.Path {
+		http.Redirect(w, r, c.location(dest, r.URL, path), c.code)
 		return
 	}
 
 	c.h.ServeHTTP(w, r)
 }
 
+// location re-builds the destination URL from the canonical scheme and host,
+// the given path and the components of u selected by the options.
+func (c canonical) location(dest *url.URL, u *url.URL, path string) string {
+	loc := dest.Scheme + "://"
+	if c.keepUserInfo && u.User != nil {
+		loc += u.User.String() + "@"
+	}
+	loc += dest.Host + path
+	if c.keepQuery && u.RawQuery != "" {
+		loc += "?" + u.RawQuery
+	}
+	if c.keepFragment && u.Fragment != "" {
+		loc += "#" + u.EscapedFragment()
+	}
+	return loc
+}
+
 // cleanHost cleans invalid Host headers by stripping anything after '/' or ' '.
 // This is backported from Go 1.5 (in response to issue #11206) and attempts to
 // mitigate malformed Host headers that do not match the format in RFC7230.
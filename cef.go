@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// CEFLogFormatter returns a LogFormatter writing log entries in the Common
+// Event Format (CEF) read by security appliances such as ArcSight. vendor,
+// product and version identify the device in the CEF header.
+//
+// The signature ID of an event is the response status code, and its severity
+// is 3 for successful requests, 5 for client errors and 8 for server errors.
+// The request is described with the rt, src, suser, requestMethod, request,
+// app, dhost, requestContext, requestClientApplication and out extensions,
+// msg holds the error attached with SetLogError and cs1 the request ID, if
+// any.
+//
+// Example:
+//
+//	h := handlers.CustomLoggingHandler(siem, r, handlers.CEFLogFormatter("Example", "Shop", "1.0"))
+func CEFLogFormatter(vendor, product, version string) LogFormatter {
+	header := "CEF:0|" + cefHeaderEscaper.Replace(vendor) +
+		"|" + cefHeaderEscaper.Replace(product) +
+		"|" + cefHeaderEscaper.Replace(version) + "|"
+
+	return func(writer io.Writer, params LogFormatterParams) {
+		req := params.Request
+
+		buf := make([]byte, 0, 512)
+		buf = append(buf, header...)
+		buf = strconv.AppendInt(buf, int64(params.StatusCode), 10)
+		buf = append(buf, "|HTTP request|"...)
+		buf = strconv.AppendInt(buf, int64(cefSeverity(params.StatusCode)), 10)
+		buf = append(buf, '|')
+
+		buf = append(buf, "rt="...)
+		buf = strconv.AppendInt(buf, params.TimeStamp.UnixNano()/1e6, 10)
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		buf = appendCEFExtension(buf, "src", host)
+		if params.URL.User != nil {
+			buf = appendCEFExtension(buf, "suser", params.URL.User.Username())
+		}
+		buf = appendCEFExtension(buf, "requestMethod", req.Method)
+		buf = appendCEFExtension(buf, "request", params.URL.RequestURI())
+		buf = appendCEFExtension(buf, "app", req.Proto)
+		buf = appendCEFExtension(buf, "dhost", req.Host)
+		buf = appendCEFExtension(buf, "requestContext", req.Referer())
+		buf = appendCEFExtension(buf, "requestClientApplication", req.UserAgent())
+		buf = append(buf, " out="...)
+		buf = strconv.AppendInt(buf, int64(params.Size), 10)
+		if params.Error != nil {
+			buf = appendCEFExtension(buf, "msg", params.Error.Error())
+		}
+		if params.RequestID != "" {
+			buf = appendCEFExtension(buf, "cs1", params.RequestID)
+			buf = appendCEFExtension(buf, "cs1Label", "requestId")
+		}
+		buf = append(buf, '\n')
+		_, _ = writer.Write(buf)
+	}
+}
+
+// cefSeverity maps a response status code to a CEF severity.
+func cefSeverity(status int) int {
+	switch {
+	case status >= 500:
+		return 8
+	case status >= 400:
+		return 5
+	default:
+		return 3
+	}
+}
+
+// appendCEFExtension appends the key=value extension, omitting empty values.
+func appendCEFExtension(buf []byte, key, value string) []byte {
+	if value == "" {
+		return buf
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, key...)
+	buf = append(buf, '=')
+	return append(buf, cefExtensionEscaper.Replace(value)...)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCEFLogFormatter(t *testing.T) {
+	req := constructTypicalRequestOk()
+	req.URL.User = url.User("kim")
+	req.Header.Set("Referer", "http://example.com/a=b")
+	req.Header.Set("User-Agent", "evil\nCEF:0|fake")
+
+	var buf bytes.Buffer
+	CEFLogFormatter("Acme|Corp", "Shop", "1.0")(&buf, LogFormatterParams{
+		Request:    req,
+		URL:        *req.URL,
+		TimeStamp:  time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC),
+		StatusCode: http.StatusNotFound,
+		Size:       100,
+		RequestID:  "abc",
+		Error:      errors.New("not=found"),
+	})
+
+	want := `CEF:0|Acme\|Corp|Shop|1.0|404|HTTP request|5|rt=422767845000 src=192.168.100.5 suser=kim ` +
+		`requestMethod=GET request=/ app=HTTP/1.1 dhost=example.com ` +
+		`requestContext=http://example.com/a\=b requestClientApplication=evil\nCEF:0|fake ` +
+		"out=100 msg=not\\=found cs1=abc cs1Label=requestId\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCEFSeverity(t *testing.T) {
+	for status, want := range map[int]int{200: 3, 302: 3, 403: 5, 503: 8} {
+		if got := cefSeverity(status); got != want {
+			t.Errorf("cefSeverity(%d) = %d, want %d", status, got, want)
+		}
+	}
+}
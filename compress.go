@@ -5,24 +5,393 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/felixge/httpsnoop"
 )
 
-const acceptEncoding string = "Accept-Encoding"
+const (
+	acceptEncoding      string = "Accept-Encoding"
+	availableDictionary string = "Available-Dictionary"
+)
+
+// An Encoder creates the writers compressing responses with a content coding.
+type Encoder interface {
+	// NewWriter returns a writer compressing the data written to it to w at
+	// the given level, or nil if it can't. Closing the writer must flush the
+	// compressed data to w, but not close w.
+	NewWriter(w io.Writer, level int) io.WriteCloser
+}
+
+// EncoderFunc adapts a function to the Encoder interface.
+type EncoderFunc func(w io.Writer, level int) io.WriteCloser
+
+// NewWriter calls f(w, level).
+func (f EncoderFunc) NewWriter(w io.Writer, level int) io.WriteCloser {
+	return f(w, level)
+}
+
+// compressLevels is the number of compression levels supported by both gzip
+// and flate, from DefaultCompression to BestCompression.
+const compressLevels = gzip.BestCompression - gzip.DefaultCompression + 1
+
+// The gzip and flate writers are pooled by level, since they are expensive to
+// allocate.
+var (
+	gzipWriterPools  [compressLevels]sync.Pool
+	flateWriterPools [compressLevels]sync.Pool
+)
+
+type gzipEncoder struct{}
+
+func (gzipEncoder) NewWriter(w io.Writer, level int) io.WriteCloser {
+	if level < gzip.DefaultCompression || level > gzip.BestCompression {
+		return nil
+	}
+	pool := &gzipWriterPools[level-gzip.DefaultCompression]
+	gw, ok := pool.Get().(*gzip.Writer)
+	if ok {
+		gw.Reset(w)
+	} else {
+		gw, _ = gzip.NewWriterLevel(w, level)
+	}
+	return &pooledWriter{w: gw, pool: pool}
+}
+
+// dictionaryEncoderFunc is the factory of a dictionary content coding,
+// registered with RegisterDictionaryEncoder. Such codings are only negotiated
+// separately from the others, for the responses compressed with a dictionary.
+type dictionaryEncoderFunc func(w io.Writer, level int, dict []byte) io.WriteCloser
+
+// NewWriter returns nil, since the coding requires a dictionary.
+func (f dictionaryEncoderFunc) NewWriter(w io.Writer, level int) io.WriteCloser {
+	return nil
+}
+
+type flateEncoder struct{}
+
+func (flateEncoder) NewWriter(w io.Writer, level int) io.WriteCloser {
+	if level < gzip.DefaultCompression || level > gzip.BestCompression {
+		return nil
+	}
+	pool := &flateWriterPools[level-gzip.DefaultCompression]
+	fw, ok := pool.Get().(*flate.Writer)
+	if ok {
+		fw.Reset(w)
+	} else {
+		fw, _ = flate.NewWriter(w, level)
+	}
+	return &pooledWriter{w: fw, pool: pool}
+}
+
+// resettableWriter is implemented by gzip.Writer and flate.Writer.
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// pooledWriter returns its writer to the pool when closed, and fails
+// afterwards, since the writer may be reused by another response.
+type pooledWriter struct {
+	w    resettableWriter
+	pool *sync.Pool
+}
+
+func (p *pooledWriter) Write(b []byte) (int, error) {
+	if p.w == nil {
+		return 0, errPooledWriterClosed
+	}
+	return p.w.Write(b)
+}
+
+func (p *pooledWriter) Flush() error {
+	if p.w == nil {
+		return errPooledWriterClosed
+	}
+	return p.w.Flush()
+}
+
+func (p *pooledWriter) Close() error {
+	if p.w == nil {
+		return nil
+	}
+	err := p.w.Close()
+	// don't keep the response alive while pooled
+	p.w.Reset(io.Discard)
+	p.pool.Put(p.w)
+	p.w = nil
+	return err
+}
+
+var errPooledWriterClosed = errors.New("handlers: write to a closed compressor")
+
+type namedEncoder struct {
+	name string
+	enc  Encoder
+}
+
+var (
+	encodersMu sync.RWMutex
+	// encoders lists the encoders negotiated by CompressHandler, by order of
+	// preference. It is copied on write, and never modified.
+	encoders = []namedEncoder{
+		{"gzip", gzipEncoder{}},
+		{"deflate", flateEncoder{}},
+	}
+)
+
+// RegisterEncoder makes CompressHandler negotiate the content coding name,
+// "br" or "zstd" for instance, with the writers created by factory. factory is
+// passed the compression level of the handler, gzip.DefaultCompression by
+// default, and its writers must flush the compressed data to the underlying
+// writer, but not close it, when closed.
+//
+// Registering a coding which is already registered, such as the built-in
+// "gzip" and "deflate", replaces its factory, and a nil factory unregisters
+// it. Otherwise the codings registered last are preferred when the client
+// accepts several of them equally. Types implementing Encoder are registered with
+// their NewWriter method:
+//
+//	handlers.RegisterEncoder("zstd", zstdEncoder.NewWriter)
+//
+// RegisterEncoder is usually called from an init function, but it is safe to
+// call concurrently with the handlers.
+func RegisterEncoder(name string, factory func(io.Writer, int) io.WriteCloser) {
+	var enc Encoder
+	if factory != nil {
+		enc = EncoderFunc(factory)
+	}
+	registerEncoder(name, enc)
+}
+
+// RegisterDictionaryEncoder registers, like RegisterEncoder, a content coding
+// compressing with a preset dictionary, such as "dcb" or "dcz", the Brotli and
+// Zstandard codings with a shared dictionary of RFC 9842. factory is passed
+// the dictionary selected with CompressDictionaries for the response, and must
+// produce the whole coding, including any header identifying the dictionary.
+//
+// Dictionary codings are only negotiated for the responses compressed with a
+// dictionary available to the client, and the other ones are used otherwise.
+// They must have names of their own: clients only decode the responses
+// compressed with a dictionary under these names, never under "gzip",
+// "deflate" or "br" for instance, so registering them under such names
+// corrupts the responses.
+func RegisterDictionaryEncoder(name string, factory func(w io.Writer, level int, dict []byte) io.WriteCloser) {
+	var enc Encoder
+	if factory != nil {
+		enc = dictionaryEncoderFunc(factory)
+	}
+	registerEncoder(name, enc)
+}
+
+func registerEncoder(name string, enc Encoder) {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	registered := make([]namedEncoder, 0, len(encoders)+1)
+	replaced := false
+	for _, e := range encoders {
+		if e.name != name {
+			registered = append(registered, e)
+			continue
+		}
+		if enc != nil {
+			registered = append(registered, namedEncoder{name, enc})
+		}
+		replaced = true
+	}
+	if !replaced && enc != nil {
+		registered = append([]namedEncoder{{name, enc}}, registered...)
+	}
+	encoders = registered
+}
+
+// SetBrotliEncoder enables the Brotli ("br") encoding in CompressHandler,
+// using newWriter to create the writers compressing the responses, so that the
+// package doesn't depend on a Brotli implementation. It is a shorthand for
+// RegisterEncoder("br", newWriter): a nil newWriter disables the encoding
+// again.
+//
+// Example, with github.com/andybalholm/brotli:
+//
+//	handlers.SetBrotliEncoder(func(w io.Writer, level int) io.WriteCloser {
+//		if level < 0 {
+//			level = brotli.DefaultCompression
+//		}
+//		return brotli.NewWriterLevel(w, level)
+//	})
+func SetBrotliEncoder(newWriter func(w io.Writer, level int) io.WriteCloser) {
+	RegisterEncoder("br", newWriter)
+}
+
+// registeredEncoders returns the registered encoders, which must not be
+// modified.
+func registeredEncoders() []namedEncoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	return encoders
+}
+
+// negotiateEncoding returns the registered encoder with the highest quality
+// value in the Accept-Encoding header value, if any, and whether the identity
+// encoding, i.e. no compression, is acceptable. Ties are broken by the order
+// of preference of the encoders, or of the enabled encodings if any, which
+// restrict the encoders. Only the dictionary codings are considered if dict
+// is true, and only the other ones otherwise.
+func negotiateEncoding(accept string, enabled []string, dict bool) (name string, enc Encoder, identity bool) {
+	qs := parseAcceptEncoding(accept)
+	q, ok := qs["identity"]
+	if !ok {
+		q, ok = qs["*"]
+	}
+	identity = !ok || q > 0
+
+	candidates := registeredEncoders()
+	if len(enabled) > 0 {
+		candidates = make([]namedEncoder, 0, len(enabled))
+		for _, name := range enabled {
+			name = strings.ToLower(strings.TrimSpace(name))
+			for _, e := range registeredEncoders() {
+				if e.name == name {
+					candidates = append(candidates, e)
+				}
+			}
+		}
+	}
+
+	var best namedEncoder
+	var bestQ float64
+	for _, e := range candidates {
+		if _, ok := e.enc.(dictionaryEncoderFunc); ok != dict {
+			continue
+		}
+		q, ok := qs[e.name]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best.name, best.enc, identity
+}
+
+// parseAcceptEncoding returns the quality values of the codings listed in an
+// Accept-Encoding header value, keyed by lower case name, "*" included.
+// Codings with an invalid quality value are ignored.
+func parseAcceptEncoding(accept string) map[string]float64 {
+	qs := make(map[string]float64)
+	for _, elem := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(elem, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q, valid := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			q, valid = f, err == nil && f >= 0 && f <= 1
+		}
+		if valid {
+			qs[name] = q
+		}
+	}
+	return qs
+}
 
 type compressResponseWriter struct {
-	compressor io.Writer
-	w          http.ResponseWriter
+	w        http.ResponseWriter
+	wire     wireCounter
+	encoding string
+	encoder  Encoder
+	level    int
+	minSize  int
+	bufLimit int
+	maxRatio float64
+	sample   int
+	types    []string
+	dicts    []compressDictionary
+	// dictionary coding, used instead of encoding with a dictionary
+	dictEncoding string
+	dictEncoder  dictionaryEncoderFunc
+	dictLevel    int
+	path         string
+	avail        string // Available-Dictionary request header
+	stats        *compressionStats
+
+	decided    bool           // whether the response is compressed or not yet known
+	out        io.Writer      // the compressor or wire once decided
+	compressor io.WriteCloser // nil if the response isn't compressed
+	buf        []byte         // start of the response, until minSize or bufLimit is reached
+	status     int            // status code written while buffering
+	switched   bool           // whether the handler switched protocols
+}
+
+// compressionStats counts the bytes of a compressed response before and after
+// compression, for the logging handlers.
+type compressionStats struct {
+	uncompressed atomic.Int64
+	wire         atomic.Int64
+
+	once  sync.Once
+	close func() error
+}
+
+// finish closes the compressor, after which the counts are final. Logging
+// handlers wrapped by the CompressHandler call it to log the final sizes
+// before the CompressHandler returns.
+func (s *compressionStats) finish() {
+	s.once.Do(func() {
+		_ = s.close()
+	})
+}
+
+// wireCounter counts the bytes written to the underlying ResponseWriter.
+type wireCounter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c wireCounter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n.Add(int64(n))
+	return n, err
 }
 
 func (cw *compressResponseWriter) WriteHeader(c int) {
-	cw.w.Header().Del("Content-Length")
+	if c == http.StatusSwitchingProtocols {
+		cw.switchProtocols()
+	}
+	if !cw.decided && c >= http.StatusOK {
+		if cw.status == 0 {
+			cw.status = c
+		}
+		_ = cw.startUnbuffered()
+		return
+	}
+	if cw.compressor != nil {
+		cw.w.Header().Del("Content-Length")
+	}
 	cw.w.WriteHeader(c)
 }
 
@@ -31,13 +400,38 @@ func (cw *compressResponseWriter) Write(b []byte) (int, error) {
 	if h.Get("Content-Type") == "" {
 		h.Set("Content-Type", http.DetectContentType(b))
 	}
-	h.Del("Content-Length")
 
-	return cw.compressor.Write(b)
+	if err := cw.startUnbuffered(); err != nil {
+		return 0, err
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		cw.stats.uncompressed.Add(int64(len(b)))
+		if len(cw.buf) < cw.minSize || len(cw.buf) <= cw.bufLimit || len(cw.buf) < cw.sample {
+			return len(b), nil
+		}
+		return len(b), cw.start(true)
+	}
+
+	if cw.compressor != nil {
+		h.Del("Content-Length")
+	}
+	n, err := cw.out.Write(b)
+	cw.stats.uncompressed.Add(int64(n))
+	return n, err
 }
 
 func (cw *compressResponseWriter) ReadFrom(r io.Reader) (int64, error) {
-	return io.Copy(cw.compressor, r)
+	if err := cw.startUnbuffered(); err != nil {
+		return 0, err
+	}
+	if !cw.decided {
+		// hide ReadFrom to buffer the response through Write
+		return io.Copy(struct{ io.Writer }{cw}, r)
+	}
+	n, err := io.Copy(cw.out, r)
+	cw.stats.uncompressed.Add(n)
+	return n, err
 }
 
 type flusher interface {
@@ -45,6 +439,10 @@ type flusher interface {
 }
 
 func (cw *compressResponseWriter) Flush() {
+	// A flushed response is streamed, so compress it whatever its size.
+	if !cw.decided {
+		_ = cw.start(!cw.encoded())
+	}
 	// Flush compressed data if compressor supports it.
 	if f, ok := cw.compressor.(flusher); ok {
 		_ = f.Flush()
@@ -55,8 +453,207 @@ func (cw *compressResponseWriter) Flush() {
 	}
 }
 
+// newWriter returns a compressor writing to w and the name of its encoding,
+// the dictionary coding if a dictionary is selected for the response.
+func (cw *compressResponseWriter) newWriter(w io.Writer) (string, io.WriteCloser) {
+	if cw.dictEncoder != nil {
+		if dict := cw.dictionary(); dict != nil {
+			return cw.dictEncoding, cw.dictEncoder(w, cw.dictLevel, dict)
+		}
+	}
+	if cw.encoder == nil {
+		return "", nil
+	}
+	return cw.encoding, cw.encoder.NewWriter(w, cw.level)
+}
+
+// dictionary returns the first dictionary matching the response and
+// available to the client, if any.
+func (cw *compressResponseWriter) dictionary() []byte {
+	if cw.avail == "" {
+		return nil
+	}
+	ct := cw.w.Header().Get("Content-Type")
+	for _, d := range cw.dicts {
+		if d.id == cw.avail && strings.HasPrefix(cw.path, d.PathPrefix) && strings.HasPrefix(ct, d.ContentType) {
+			return d.Data
+		}
+	}
+	return nil
+}
+
+// compressesWell reports whether b, the start or the whole of the response,
+// compresses to at most maxRatio of its size, if set. Already compressed data
+// such as images or archives usually don't, and are better sent uncompressed.
+func (cw *compressResponseWriter) compressesWell(b []byte) bool {
+	if cw.maxRatio <= 0 || len(b) == 0 {
+		return true
+	}
+	var n atomic.Int64
+	_, zw := cw.newWriter(wireCounter{w: io.Discard, n: &n})
+	if zw == nil {
+		return false
+	}
+	_, _ = zw.Write(b)
+	_ = zw.Close()
+	return float64(n.Load()) <= cw.maxRatio*float64(len(b))
+}
+
+// encoded reports whether the handler encoded the response itself, e.g. when
+// proxying a compressed response or serving a precompressed file.
+func (cw *compressResponseWriter) encoded() bool {
+	return cw.w.Header().Get("Content-Encoding") != ""
+}
+
+// startUnbuffered starts the response if it isn't buffered until minSize,
+// bufLimit or the sample size is reached, i.e. if there are none or the
+// response is already encoded by the handler, in which case it is sent as is.
+func (cw *compressResponseWriter) startUnbuffered() error {
+	if cw.decided {
+		return nil
+	}
+	// the Content-Type is needed to choose, detected on the first write if
+	// the handler doesn't set it
+	if len(cw.types) > 0 && cw.w.Header().Get("Content-Type") == "" {
+		return nil
+	}
+	if encoded := cw.encoded(); encoded || (cw.minSize <= 0 && cw.bufLimit <= 0 && cw.sample <= 0) {
+		return cw.start(!encoded)
+	}
+	return nil
+}
+
+// compressible reports whether the Content-Type of the response is one of the
+// types to compress, if restricted.
+func (cw *compressResponseWriter) compressible() bool {
+	return len(cw.types) == 0 || hasAnyPrefix(cw.w.Header().Get("Content-Type"), cw.types)
+}
+
+// start decides whether the response is compressed, then sends the status
+// code and the start of the response buffered so far.
+func (cw *compressResponseWriter) start(compress bool) error {
+	cw.decided = true
+	cw.out = cw.wire
+	var encoding string
+	if compress && cw.compressible() && cw.compressesWell(cw.buf) {
+		encoding, cw.compressor = cw.newWriter(cw.wire)
+	}
+	if cw.compressor != nil {
+		cw.out = cw.compressor
+		h := cw.w.Header()
+		h.Set("Content-Encoding", encoding)
+		h.Del("Content-Length")
+	}
+
+	if cw.status != 0 {
+		cw.w.WriteHeader(cw.status)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.out.Write(buf)
+	return err
+}
+
+// compressBuffer replaces the buffered response with its compressed version,
+// and sets the headers to send it with its Content-Length.
+func (cw *compressResponseWriter) compressBuffer() {
+	if !cw.compressible() {
+		return
+	}
+	var compressed bytes.Buffer
+	encoding, zw := cw.newWriter(&compressed)
+	if zw == nil {
+		return
+	}
+	_, _ = zw.Write(cw.buf)
+	if err := zw.Close(); err != nil {
+		return
+	}
+	if cw.maxRatio > 0 && float64(compressed.Len()) > cw.maxRatio*float64(len(cw.buf)) {
+		return
+	}
+
+	cw.buf = compressed.Bytes()
+	h := cw.w.Header()
+	h.Set("Content-Encoding", encoding)
+	h.Set("Content-Length", strconv.Itoa(len(cw.buf)))
+}
+
+// switchProtocols stops compressing the response when the handler switches
+// protocols, with a 101 response or by hijacking the connection, and sends the
+// rest of the response as is.
+func (cw *compressResponseWriter) switchProtocols() {
+	cw.switched = true
+	if !cw.decided {
+		cw.decided, cw.out, cw.buf = true, cw.wire, nil
+	}
+}
+
+func (cw *compressResponseWriter) hijack(hijack httpsnoop.HijackFunc) (net.Conn, *bufio.ReadWriter, error) {
+	cw.switchProtocols()
+	return hijack()
+}
+
+// close sends the response buffered so far, uncompressed if it is below
+// minSize, compressed as a whole if it is below bufLimit, or closes the
+// compressor.
+func (cw *compressResponseWriter) close() error {
+	if cw.switched {
+		// the connection belongs to the new protocol
+		return nil
+	}
+	if !cw.decided {
+		if len(cw.buf) == 0 || len(cw.buf) < cw.minSize {
+			return cw.start(false)
+		}
+		if cw.bufLimit > 0 {
+			cw.compressBuffer()
+			return cw.start(false)
+		}
+		// the whole response is smaller than the sample
+		if err := cw.start(true); err != nil {
+			return err
+		}
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Close()
+	}
+	return nil
+}
+
+type compressHandler struct {
+	h         http.Handler
+	level     int
+	levels    map[string]int
+	encodings []string
+	minSize   int
+	bufLimit  int
+	maxRatio  float64
+	sample    int
+	types     []string
+	dicts     []compressDictionary
+	strict    bool
+	skipPaths []string
+	skipFunc  func(*http.Request) bool
+}
+
+// CompressOption provides a functional approach to configure the
+// CompressHandler middleware.
+type CompressOption func(*compressHandler)
+
 // CompressHandler gzip compresses HTTP responses for clients that support it
-// via the 'Accept-Encoding' header.
+// via the 'Accept-Encoding' header. Deflate is supported as well, and other
+// encodings such as Brotli once registered with RegisterEncoder. The encoding
+// with the highest quality value in the header is chosen, e.g. deflate for
+// "gzip;q=0.5, deflate", the server preferring gzip over deflate in case of a
+// tie. Responses whose Content-Encoding header is set by the handler before
+// writing them, e.g. precompressed files or proxied responses, are sent as is.
+// Requests to switch protocols, such as WebSocket handshakes, are passed to h
+// untouched, and compression stops when h switches protocols anyway, with a
+// 101 response or by hijacking the connection.
 //
 // Compressing TLS traffic may leak the page contents to an attacker if the
 // page contains user input: http://security.stackexchange.com/a/102015/12208
@@ -71,73 +668,352 @@ func CompressHandler(h http.Handler) http.Handler {
 // or any integer value between gzip.BestSpeed and gzip.BestCompression inclusive.
 // gzip.DefaultCompression is used in case of invalid compression level.
 func CompressHandlerLevel(h http.Handler, level int) http.Handler {
-	if level < gzip.DefaultCompression || level > gzip.BestCompression {
-		level = gzip.DefaultCompression
-	}
-
-	const (
-		gzipEncoding  = "gzip"
-		flateEncoding = "deflate"
-	)
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// detect what encoding to use
-		var encoding string
-		for _, curEnc := range strings.Split(r.Header.Get(acceptEncoding), ",") {
-			curEnc = strings.TrimSpace(curEnc)
-			if curEnc == gzipEncoding || curEnc == flateEncoding {
-				encoding = curEnc
-				break
-			}
+	return Compress(CompressLevel(level))(h)
+}
+
+// Compress returns a CompressHandler middleware configured with opts.
+//
+// Example:
+//
+//	r.Use(handlers.Compress(handlers.CompressMinSize(1400)))
+func Compress(opts ...CompressOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		c := &compressHandler{h: h, level: gzip.DefaultCompression}
+		for _, option := range opts {
+			option(c)
 		}
+		return c
+	}
+}
 
-		// always add Accept-Encoding to Vary to prevent intermediate caches corruption
-		w.Header().Add("Vary", acceptEncoding)
+// CompressLevel is a functional option that sets the compression level, as
+// CompressHandlerLevel does.
+func CompressLevel(level int) CompressOption {
+	return func(c *compressHandler) {
+		if level < gzip.DefaultCompression || level > gzip.BestCompression {
+			level = gzip.DefaultCompression
+		}
+		c.level = level
+	}
+}
 
-		// if we weren't able to identify an encoding we're familiar with, pass on the
-		// request to the handler and return
-		if encoding == "" {
-			h.ServeHTTP(w, r)
-			return
+// CompressEncodingLevel is a functional option that sets the compression level
+// of an encoding, overriding the one set by CompressLevel, e.g. for Brotli
+// whose levels go up to 11. The level must be valid for the encoder: the
+// responses are sent uncompressed otherwise.
+func CompressEncodingLevel(encoding string, level int) CompressOption {
+	return func(c *compressHandler) {
+		if c.levels == nil {
+			c.levels = make(map[string]int)
 		}
+		c.levels[strings.ToLower(encoding)] = level
+	}
+}
 
-		if r.Header.Get("Upgrade") != "" {
-			h.ServeHTTP(w, r)
-			return
+// CompressEncodings is a functional option that restricts the encodings
+// negotiated to the given ones, among the registered ones, by order of
+// preference, e.g. "br", "gzip". All the registered encodings are negotiated
+// by default.
+func CompressEncodings(encodings ...string) CompressOption {
+	return func(c *compressHandler) {
+		c.encodings = encodings
+	}
+}
+
+// CompressContentTypes is a functional option that restricts compression to
+// responses whose Content-Type starts with one of the given prefixes, such as
+// "text/" or "application/json", since compressing images, videos or archives
+// is a waste. All content types are compressed by default.
+func CompressContentTypes(prefixes ...string) CompressOption {
+	return func(c *compressHandler) {
+		c.types = prefixes
+	}
+}
+
+// CompressMinSize is a functional option that compresses responses only once
+// they reach size bytes, e.g. 1400 to fill a network packet, since compressing
+// smaller ones hardly saves anything, and may even make them larger. The start
+// of the responses is buffered until then, and smaller responses are sent
+// uncompressed, keeping their Content-Length. Responses flushed by the handler,
+// or to clients refusing the identity encoding, are compressed whatever their
+// size.
+func CompressMinSize(size int) CompressOption {
+	return func(c *compressHandler) {
+		c.minSize = size
+	}
+}
+
+// CompressBuffered is a functional option that buffers the responses up to
+// limit bytes to compress them as a whole, and send them with a Content-Length
+// rather than with the chunked transfer encoding, which some clients and
+// proxies handle poorly. Larger responses, and responses flushed by the
+// handler, are streamed as usual.
+func CompressBuffered(limit int) CompressOption {
+	return func(c *compressHandler) {
+		c.bufLimit = limit
+	}
+}
+
+// CompressMaxRatio is a functional option that compresses the first sample
+// bytes of each response before choosing to compress it, and sends the
+// response uncompressed if they don't compress to at most ratio of their size,
+// e.g. 0.9, to save the CPU spent on already compressed data, such as images
+// or archives. The start of the responses is buffered until the sample is
+// complete.
+func CompressMaxRatio(ratio float64, sample int) CompressOption {
+	return func(c *compressHandler) {
+		if ratio > 0 && sample > 0 {
+			c.maxRatio, c.sample = ratio, sample
 		}
+	}
+}
+
+// A CompressionDictionary is a preset dictionary improving the compression of
+// small responses, which share many strings with it, e.g. JSON documents with
+// the same keys. Clients need the dictionary to decompress the responses.
+type CompressionDictionary struct {
+	// PathPrefix and ContentType restrict the dictionary to the responses
+	// to the requests whose path starts with PathPrefix, and whose
+	// Content-Type starts with ContentType, if set.
+	PathPrefix  string
+	ContentType string
+	// Data is the dictionary.
+	Data []byte
+}
+
+type compressDictionary struct {
+	CompressionDictionary
+	id string // Available-Dictionary header value
+}
 
-		// wrap the ResponseWriter with the writer for the chosen encoding
-		var encWriter io.WriteCloser
-		if encoding == gzipEncoding {
-			encWriter, _ = gzip.NewWriterLevel(w, level)
-		} else if encoding == flateEncoding {
-			encWriter, _ = flate.NewWriter(w, level)
+// CompressDictionaries is a functional option that compresses responses with
+// the first of dicts matching them, using a dictionary coding registered with
+// RegisterDictionaryEncoder. Since clients need the dictionary to decompress
+// the responses, it is only used if the client lists it in the
+// Available-Dictionary request header, as a structured field byte sequence
+// holding its SHA-256 hash, i.e. ":" + base64(sha256(Data)) + ":" as defined
+// by RFC 9842, and accepts a dictionary coding. The other responses are
+// compressed as usual. Available-Dictionary is added to the Vary header of the
+// responses.
+//
+// Example, with a "dcb" factory writing RFC 9842 Brotli streams:
+//
+//	handlers.RegisterDictionaryEncoder("dcb", newDCBWriter)
+//	h := handlers.Compress(handlers.CompressDictionaries(handlers.CompressionDictionary{
+//		PathPrefix:  "/api/",
+//		ContentType: "application/json",
+//		Data:        apiDictionary,
+//	}))(r)
+func CompressDictionaries(dicts ...CompressionDictionary) CompressOption {
+	return func(c *compressHandler) {
+		for _, d := range dicts {
+			sum := sha256.Sum256(d.Data)
+			c.dicts = append(c.dicts, compressDictionary{d, ":" + base64.StdEncoding.EncodeToString(sum[:]) + ":"})
 		}
-		defer encWriter.Close()
+	}
+}
 
-		w.Header().Set("Content-Encoding", encoding)
-		r.Header.Del(acceptEncoding)
+// CompressStrict is a functional option that responds with a 406 "Not
+// Acceptable" to the requests refusing both the identity encoding, with
+// "identity;q=0" or "*;q=0", and all the supported encodings. Such requests
+// are served uncompressed by default.
+func CompressStrict() CompressOption {
+	return func(c *compressHandler) {
+		c.strict = true
+	}
+}
+
+// CompressSkipPaths is a functional option that serves the requests whose
+// path starts with any of the given prefixes uncompressed, e.g. for streams,
+// metrics or signed downloads.
+func CompressSkipPaths(prefixes ...string) CompressOption {
+	return func(c *compressHandler) {
+		c.skipPaths = append(c.skipPaths, prefixes...)
+	}
+}
+
+// CompressSkipFunc is a functional option that serves the requests for which
+// fn returns true uncompressed, like CompressSkipPaths.
+func CompressSkipFunc(fn func(*http.Request) bool) CompressOption {
+	return func(c *compressHandler) {
+		c.skipFunc = fn
+	}
+}
 
-		cw := &compressResponseWriter{
-			w:          w,
-			compressor: encWriter,
+func (c *compressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (len(c.skipPaths) > 0 && hasAnyPrefix(r.URL.Path, c.skipPaths)) || (c.skipFunc != nil && c.skipFunc(r)) {
+		c.h.ServeHTTP(w, r)
+		return
+	}
+
+	// don't get in the way of the handlers switching protocols, such as
+	// WebSocket handlers
+	if r.Header.Get("Upgrade") != "" || headerHasToken(r.Header, "Connection", "upgrade") {
+		c.h.ServeHTTP(w, r)
+		return
+	}
+
+	// detect what encoding to use
+	accept := strings.Join(r.Header.Values(acceptEncoding), ",")
+	encoding, encoder, identity := negotiateEncoding(accept, c.encodings, false)
+	avail := strings.TrimSpace(r.Header.Get(availableDictionary))
+	var dictEncoding string
+	var dictEncoder Encoder
+	if len(c.dicts) > 0 && avail != "" {
+		dictEncoding, dictEncoder, _ = negotiateEncoding(accept, c.encodings, true)
+	}
+
+	// always add Accept-Encoding to Vary to prevent intermediate caches corruption
+	AddVary(w.Header(), acceptEncoding)
+	if len(c.dicts) > 0 {
+		AddVary(w.Header(), availableDictionary)
+	}
+
+	// if we weren't able to identify an encoding we're familiar with, pass on the
+	// request to the handler and return
+	if encoding == "" && dictEncoding == "" {
+		if c.strict && !identity {
+			http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			return
 		}
+		c.h.ServeHTTP(w, r)
+		return
+	}
 
-		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
-			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
-				return cw.Write
-			},
-			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
-				return cw.WriteHeader
-			},
-			Flush: func(httpsnoop.FlushFunc) httpsnoop.FlushFunc {
-				return cw.Flush
-			},
-			ReadFrom: func(rff httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
-				return cw.ReadFrom
-			},
-		})
+	// wrap the ResponseWriter with the writer for the chosen encoding
+	level, ok := c.levels[encoding]
+	if !ok {
+		level = c.level
+	}
+	stats := &compressionStats{}
+	cw := &compressResponseWriter{
+		w:        w,
+		wire:     wireCounter{w: w, n: &stats.wire},
+		encoding: encoding,
+		encoder:  encoder,
+		level:    level,
+		minSize:  c.minSize,
+		bufLimit: c.bufLimit,
+		maxRatio: c.maxRatio,
+		sample:   c.sample,
+		types:    c.types,
+		dicts:    c.dicts,
+		avail:    avail,
+		stats:    stats,
+	}
+	if dictEncoding != "" {
+		cw.dictEncoding = dictEncoding
+		cw.dictEncoder = dictEncoder.(dictionaryEncoderFunc)
+		if cw.dictLevel, ok = c.levels[dictEncoding]; !ok {
+			cw.dictLevel = c.level
+		}
+		cw.path = r.URL.Path
+	}
+	// responses can't be sent uncompressed if the client refuses them
+	if !identity {
+		cw.minSize, cw.types, cw.maxRatio = 0, nil, 0
+	}
+	stats.close = cw.close
+	defer stats.finish()
 
-		h.ServeHTTP(w, r)
+	// record the sizes for logging handlers, whichever wraps the other
+	r, rec := withRequestRecord(r)
+	rec.setCompressionStats(stats)
+
+	r.Header.Del(acceptEncoding)
+
+	w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return cw.Write
+		},
+		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return cw.WriteHeader
+		},
+		Flush: func(httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return cw.Flush
+		},
+		ReadFrom: func(rff httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return cw.ReadFrom
+		},
+		Hijack: func(hijack httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				return cw.hijack(hijack)
+			}
+		},
 	})
+
+	c.h.ServeHTTP(w, r)
+}
+
+// CompressOptions gathers the settings of the CompressHandler middleware.
+type CompressOptions struct {
+	// Level is the compression level, see CompressLevel. The zero value uses
+	// gzip.DefaultCompression.
+	Level int
+	// Levels are compression levels by encoding, see CompressEncodingLevel.
+	Levels map[string]int
+	// Encodings restricts the encodings negotiated, see CompressEncodings.
+	Encodings []string
+	// MinSize is the size from which responses are compressed, see
+	// CompressMinSize.
+	MinSize int
+	// BufferLimit is the size up to which responses are compressed as a whole
+	// and sent with a Content-Length, see CompressBuffered.
+	BufferLimit int
+	// MaxRatio is the compression ratio of the first MaxRatioSample bytes of
+	// a response above which it is sent uncompressed, see CompressMaxRatio.
+	MaxRatio       float64
+	MaxRatioSample int
+	// ContentTypes restricts compression to some types of responses, see
+	// CompressContentTypes.
+	ContentTypes []string
+	// SkipPaths are path prefixes served uncompressed, see CompressSkipPaths.
+	SkipPaths []string
+	// Skip is a predicate for requests served uncompressed, see
+	// CompressSkipFunc.
+	Skip func(*http.Request) bool
+	// Dictionaries are preset dictionaries, see CompressDictionaries.
+	Dictionaries []CompressionDictionary
+	// Strict rejects the requests refusing all the acceptable encodings, see
+	// CompressStrict.
+	Strict bool
+	// Options are applied after the settings above, for the settings which
+	// have no field.
+	Options []CompressOption
+}
+
+// CompressHandlerWithOpts returns a CompressHandler compressing the responses
+// of h as configured by opts, gathering all the settings of CompressHandler in
+// one call.
+//
+// Example:
+//
+//	h := handlers.CompressHandlerWithOpts(r, handlers.CompressOptions{
+//		Levels:       map[string]int{"gzip": gzip.BestSpeed},
+//		MinSize:      1400,
+//		ContentTypes: []string{"text/", "application/json"},
+//		SkipPaths:    []string{"/metrics"},
+//	})
+func CompressHandlerWithOpts(h http.Handler, opts CompressOptions) http.Handler {
+	options := []CompressOption{
+		CompressEncodings(opts.Encodings...),
+		CompressMinSize(opts.MinSize),
+		CompressBuffered(opts.BufferLimit),
+		CompressMaxRatio(opts.MaxRatio, opts.MaxRatioSample),
+		CompressContentTypes(opts.ContentTypes...),
+		CompressSkipPaths(opts.SkipPaths...),
+		CompressSkipFunc(opts.Skip),
+		CompressDictionaries(opts.Dictionaries...),
+	}
+	if opts.Level != 0 {
+		options = append(options, CompressLevel(opts.Level))
+	}
+	for encoding, level := range opts.Levels {
+		options = append(options, CompressEncodingLevel(encoding, level))
+	}
+	if opts.Strict {
+		options = append(options, CompressStrict())
+	}
+	options = append(options, opts.Options...)
+	return Compress(options...)(h)
 }
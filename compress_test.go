@@ -7,7 +7,11 @@ package handlers
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"io"
 	"log"
 	"net"
@@ -17,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -171,6 +176,664 @@ func TestCompressHandlerGzipDeflate(t *testing.T) {
 	}
 }
 
+func TestCompressHandlerBrotli(t *testing.T) {
+	// flate stands in for a brotli implementation.
+	SetBrotliEncoder(func(w io.Writer, level int) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, level)
+		return fw
+	})
+	defer SetBrotliEncoder(nil)
+
+	for accept, want := range map[string]string{
+		"gzip, deflate, br": "br",
+		"br":                "br",
+		"gzip":              "gzip",
+	} {
+		w := httptest.NewRecorder()
+		compressedRequest(w, accept)
+		if enc := w.Result().Header.Get("Content-Encoding"); enc != want {
+			t.Errorf("Accept-Encoding %q: wrong content encoding, got %q want %q", accept, enc, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	compressedRequest(w, "br")
+	body, err := io.ReadAll(flate.NewReader(w.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 9*1024 {
+		t.Errorf("wrong decompressed len, got %d want %d", len(body), 9*1024)
+	}
+
+	SetBrotliEncoder(nil)
+	w = httptest.NewRecorder()
+	compressedRequest(w, "br, gzip")
+	if enc := w.Result().Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("wrong content encoding without brotli encoder, got %q want %q", enc, "gzip")
+	}
+}
+
+// upperEncoder is a toy content coding upper-casing the response.
+type upperEncoder struct{}
+
+func (upperEncoder) NewWriter(w io.Writer, level int) io.WriteCloser {
+	return upperWriter{w}
+}
+
+type upperWriter struct{ w io.Writer }
+
+func (u upperWriter) Write(b []byte) (int, error) { return u.w.Write(bytes.ToUpper(b)) }
+func (upperWriter) Close() error                  { return nil }
+
+func TestRegisterEncoder(t *testing.T) {
+	RegisterEncoder("X-Upper", upperEncoder{}.NewWriter)
+	defer RegisterEncoder("x-upper", nil)
+
+	w := httptest.NewRecorder()
+	compressedRequest(w, "gzip, x-upper")
+	if enc := w.Result().Header.Get("Content-Encoding"); enc != "x-upper" {
+		t.Fatalf("wrong content encoding, got %q want %q", enc, "x-upper")
+	}
+	if !strings.HasPrefix(w.Body.String(), "GORILLA!\n") || w.Body.Len() != 9*1024 {
+		t.Errorf("wrong body, got %d bytes starting with %q", w.Body.Len(), w.Body.String()[:9])
+	}
+
+	// Replacing the built-in gzip encoder keeps its place.
+	RegisterEncoder("gzip", upperEncoder{}.NewWriter)
+	defer RegisterEncoder("gzip", gzipEncoder{}.NewWriter)
+	w = httptest.NewRecorder()
+	compressedRequest(w, "deflate, gzip")
+	if enc := w.Result().Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("wrong content encoding, got %q want %q", enc, "gzip")
+	}
+	if !strings.HasPrefix(w.Body.String(), "GORILLA!\n") {
+		t.Errorf("gzip encoder not replaced, got %q", w.Body.String()[:9])
+	}
+
+	RegisterEncoder("x-upper", nil)
+	w = httptest.NewRecorder()
+	compressedRequest(w, "x-upper")
+	if enc := w.Result().Header.Get("Content-Encoding"); enc != "" {
+		t.Errorf("wrong content encoding after unregistering, got %q want %q", enc, "")
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	handler := func(size int, flush bool) http.Handler {
+		return Compress(CompressMinSize(1400))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(bytes.Repeat([]byte("a"), size/2))
+			if flush {
+				w.(http.Flusher).Flush()
+			}
+			_, _ = w.Write(bytes.Repeat([]byte("a"), size-size/2))
+		}))
+	}
+
+	tests := []struct {
+		name     string
+		size     int
+		flush    bool
+		encoding string
+	}{
+		{"small", 100, false, ""},
+		{"threshold", 1400, false, "gzip"},
+		{"large", 10000, false, "gzip"},
+		{"small flushed", 100, true, "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(acceptEncoding, "gzip")
+			handler(tt.size, tt.flush).ServeHTTP(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("wrong status, got %d want %d", w.Code, http.StatusCreated)
+			}
+			if enc := w.Header().Get("Content-Encoding"); enc != tt.encoding {
+				t.Fatalf("wrong content encoding, got %q want %q", enc, tt.encoding)
+			}
+			body := w.Body.Bytes()
+			if tt.encoding == "" {
+				if l := w.Header().Get("Content-Length"); l != strconv.Itoa(tt.size) {
+					t.Errorf("wrong content-length, got %q want %d", l, tt.size)
+				}
+			} else {
+				if l := w.Header().Get("Content-Length"); l != "" {
+					t.Errorf("wrong content-length, got %q want none", l)
+				}
+				gr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(gr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(body) != tt.size {
+				t.Errorf("wrong body len, got %d want %d", len(body), tt.size)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip; q=0.5, deflate;q=0.8", "deflate"},
+		{"gzip;q=0, deflate;q=0.1", "deflate"},
+		{"gzip;q=0", ""},
+		{"gzip;q=abc, deflate", "deflate"},
+		{"gzip;q=2", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0.1", "deflate"},
+		{"*, gzip;q=0", "deflate"},
+		{"br;q=1.0, gzip;q=0.5", "gzip"},
+	}
+	for _, tt := range tests {
+		if got, _, _ := negotiateEncoding(tt.accept, nil, false); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompressBuffered(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int
+		status        int
+		encoding      string
+		contentLength bool
+	}{
+		{"small", 50, http.StatusOK, "", true},
+		{"buffered", 4096, http.StatusOK, "gzip", true},
+		{"too large", 10000, http.StatusOK, "gzip", false},
+		{"empty", 0, http.StatusNoContent, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress(CompressMinSize(100), CompressBuffered(4096))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write(bytes.Repeat([]byte("a"), tt.size/2))
+				_, _ = w.Write(bytes.Repeat([]byte("a"), tt.size-tt.size/2))
+			}))
+
+			// a real server, to check the Content-Length sent
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+			req.Header.Set(acceptEncoding, "gzip")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("wrong status, got %d want %d", resp.StatusCode, tt.status)
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != tt.encoding {
+				t.Fatalf("wrong content encoding, got %q want %q", enc, tt.encoding)
+			}
+			if tt.contentLength && resp.ContentLength < 0 {
+				t.Errorf("no Content-Length")
+			}
+			var r io.Reader = resp.Body
+			if tt.encoding != "" {
+				if r, err = gzip.NewReader(resp.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != tt.size {
+				t.Errorf("wrong body len, got %d want %d", len(body), tt.size)
+			}
+		})
+	}
+}
+
+func TestCompressMaxRatio(t *testing.T) {
+	random := make([]byte, 8192)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	text := bytes.Repeat([]byte("Gorilla!\n"), 1024)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		buffered bool
+		encoding string
+	}{
+		{"text", text, false, "gzip"},
+		{"small text", text[:500], false, "gzip"},
+		{"random", random, false, ""},
+		{"small random", random[:100], false, ""},
+		{"buffered text", text, true, "gzip"},
+		{"buffered random", random, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []CompressOption{CompressMaxRatio(0.9, 1024)}
+			if tt.buffered {
+				opts = append(opts, CompressBuffered(1<<20))
+			}
+			h := Compress(opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for b := tt.body; len(b) > 0; b = b[len(b)/2+1:] {
+					_, _ = w.Write(b[:len(b)/2+1])
+				}
+			}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(acceptEncoding, "gzip")
+			h.ServeHTTP(w, r)
+
+			if enc := w.Header().Get("Content-Encoding"); enc != tt.encoding {
+				t.Fatalf("wrong content encoding, got %q want %q", enc, tt.encoding)
+			}
+			body := w.Body.Bytes()
+			if tt.encoding != "" {
+				gr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(gr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(body, tt.body) {
+				t.Errorf("wrong body, got %d bytes want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestCompressStrict(t *testing.T) {
+	tests := []struct {
+		accept   string
+		status   int
+		encoding string
+	}{
+		{"identity;q=0", http.StatusNotAcceptable, ""},
+		{"*;q=0", http.StatusNotAcceptable, ""},
+		{"br, identity;q=0", http.StatusNotAcceptable, ""},
+		{"*;q=0, identity", http.StatusOK, ""},
+		{"br", http.StatusOK, ""},
+		{"gzip, identity;q=0", http.StatusOK, "gzip"},
+	}
+	for _, tt := range tests {
+		for _, strict := range []bool{false, true} {
+			opts := []CompressOption{CompressMinSize(1400)}
+			if strict {
+				opts = append(opts, CompressStrict())
+			}
+			h := Compress(opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "small")
+			}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(acceptEncoding, tt.accept)
+			h.ServeHTTP(w, r)
+
+			status := tt.status
+			if !strict {
+				status = http.StatusOK
+			}
+			if w.Code != status {
+				t.Errorf("%q (strict %t): wrong status, got %d want %d", tt.accept, strict, w.Code, status)
+			}
+			if enc := w.Header().Get("Content-Encoding"); enc != tt.encoding {
+				t.Errorf("%q (strict %t): wrong content encoding, got %q want %q", tt.accept, strict, enc, tt.encoding)
+			}
+		}
+	}
+}
+
+func TestCompressSkip(t *testing.T) {
+	h := Compress(
+		CompressSkipPaths("/stream", "/metrics"),
+		CompressSkipFunc(func(r *http.Request) bool { return r.URL.Query().Has("signature") }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "Gorilla!")
+	}))
+
+	for target, compressed := range map[string]bool{
+		"/":                       true,
+		"/downloads/a":            true,
+		"/stream":                 false,
+		"/stream/events":          false,
+		"/metrics":                false,
+		"/downloads/a?signature=": false,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set(acceptEncoding, "gzip")
+		h.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding") == "gzip"; got != compressed {
+			t.Errorf("%s: compressed %t, want %t", target, got, compressed)
+		}
+		if !compressed && w.Body.String() != "Gorilla!" {
+			t.Errorf("%s: wrong body, got %q", target, w.Body.String())
+		}
+	}
+}
+
+func TestCompressHandlerEncodedResponse(t *testing.T) {
+	var blob bytes.Buffer
+	gw := gzip.NewWriter(&blob)
+	_, _ = gw.Write(bytes.Repeat([]byte("Gorilla!\n"), 1024))
+	_ = gw.Close()
+
+	for _, minSize := range []int{0, 1400} {
+		h := Compress(CompressMinSize(minSize))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(blob.Len()))
+			_, _ = w.Write(blob.Bytes())
+		}))
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(acceptEncoding, "deflate, gzip")
+		h.ServeHTTP(w, r)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+			t.Errorf("min size %d: wrong content encoding, got %q want %q", minSize, enc, "gzip")
+		}
+		if l := w.Header().Get("Content-Length"); l != strconv.Itoa(blob.Len()) {
+			t.Errorf("min size %d: wrong content-length, got %q want %d", minSize, l, blob.Len())
+		}
+		if !bytes.Equal(w.Body.Bytes(), blob.Bytes()) {
+			t.Errorf("min size %d: response compressed again", minSize)
+		}
+	}
+}
+
+func TestCompressHandlerWithOpts(t *testing.T) {
+	h := CompressHandlerWithOpts(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.URL.Query().Get("type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		_, _ = w.Write(bytes.Repeat([]byte("<p>Gorilla!</p>\n"), 128))
+	}), CompressOptions{
+		Levels:       map[string]int{"gzip": gzip.BestSpeed},
+		Encodings:    []string{"deflate", "gzip"},
+		ContentTypes: []string{"text/", "application/json"},
+		SkipPaths:    []string{"/metrics"},
+	})
+
+	tests := []struct {
+		target   string
+		accept   string
+		encoding string
+	}{
+		{"/", "gzip", "gzip"},
+		{"/", "gzip, deflate", "deflate"},
+		{"/?type=application/json", "gzip", "gzip"},
+		{"/?type=image/png", "gzip", ""},
+		{"/metrics", "gzip", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Header.Set(acceptEncoding, tt.accept)
+		h.ServeHTTP(w, r)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != tt.encoding {
+			t.Errorf("%s with %q: wrong content encoding, got %q want %q", tt.target, tt.accept, enc, tt.encoding)
+		}
+		// the XFL byte of the gzip header tells the fastest algorithm was used
+		if tt.encoding == "gzip" && w.Body.Bytes()[8] != 4 {
+			t.Errorf("%s with %q: wrong compression level", tt.target, tt.accept)
+		}
+	}
+}
+
+func TestCompressHandlerSwitchingProtocols(t *testing.T) {
+	t.Run("upgrade request", func(t *testing.T) {
+		h := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v := w.Header().Get("Vary"); v != "" {
+				t.Errorf("upgrade request not bypassed, got Vary %q", v)
+			}
+			if r.Header.Get(acceptEncoding) == "" {
+				t.Errorf("upgrade request not bypassed, Accept-Encoding dropped")
+			}
+		}))
+		for _, upgrade := range []http.Header{
+			{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"websocket"}},
+			{"Connection": {"upgrade"}},
+		} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header = upgrade
+			r.Header.Set(acceptEncoding, "gzip")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}
+	})
+
+	t.Run("101 response", func(t *testing.T) {
+		h := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusSwitchingProtocols)
+			_, _ = io.WriteString(w, "raw")
+		}))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(acceptEncoding, "gzip")
+		h.ServeHTTP(w, r)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("wrong content encoding, got %q want none", enc)
+		}
+		if w.Body.String() != "raw" {
+			t.Errorf("wrong body, got %q want %q", w.Body.String(), "raw")
+		}
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		var errorLog bytes.Buffer
+		s := httptest.NewUnstartedServer(CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\nraw")
+			_ = rw.Flush()
+		})))
+		s.Config.ErrorLog = log.New(&errorLog, "", 0)
+		s.Start()
+
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\n\r\n")
+		got, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		if want := "HTTP/1.1 101 Switching Protocols\r\n\r\nraw"; string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if errorLog.Len() > 0 {
+			t.Errorf("response written after hijacking: %s", errorLog.String())
+		}
+	})
+}
+
+func TestCompressDictionaries(t *testing.T) {
+	dict := []byte(`{"id":0,"name":"","email":"","created_at":"","updated_at":""}`)
+	sum := sha256.Sum256(dict)
+	id := ":" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	body := `{"id":42,"name":"Kim","email":"kim@example.com","created_at":"2024-01-01","updated_at":"2024-01-02"}`
+
+	RegisterDictionaryEncoder("x-dcd", func(w io.Writer, level int, dict []byte) io.WriteCloser {
+		fw, err := flate.NewWriterDict(w, level, dict)
+		if err != nil {
+			return nil
+		}
+		return fw
+	})
+	defer RegisterEncoder("x-dcd", nil)
+
+	// small inputs are only compressed at the best level
+	h := Compress(CompressLevel(flate.BestCompression), CompressDictionaries(CompressionDictionary{
+		PathPrefix:  "/api/",
+		ContentType: "application/json",
+		Data:        dict,
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, body)
+	}))
+
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		avail    string
+		encoding string
+	}{
+		{"dictionary", "/api/users/42", "x-dcd, deflate", id, "x-dcd"},
+		{"dictionary only", "/api/users/42", "x-dcd", id, "x-dcd"},
+		{"no dictionary coding", "/api/users/42", "deflate", id, "deflate"},
+		{"not available", "/api/users/42", "x-dcd, deflate", "", "deflate"},
+		{"other dictionary", "/api/users/42", "x-dcd, deflate", ":AAAA:", "deflate"},
+		{"other path", "/users/42", "x-dcd, deflate", id, "deflate"},
+		{"other path dictionary only", "/users/42", "x-dcd", id, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Header.Set(acceptEncoding, tt.accept)
+			if tt.avail != "" {
+				r.Header.Set(availableDictionary, tt.avail)
+			}
+			h.ServeHTTP(w, r)
+
+			if !headerHasToken(w.Header(), "Vary", availableDictionary) {
+				t.Errorf("Available-Dictionary missing from Vary %q", w.Header().Values("Vary"))
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+
+			var rd io.Reader = w.Body
+			switch tt.encoding {
+			case "x-dcd":
+				var plain bytes.Buffer
+				fw, _ := flate.NewWriter(&plain, flate.BestCompression)
+				_, _ = io.WriteString(fw, body)
+				_ = fw.Close()
+				if w.Body.Len() >= plain.Len() {
+					t.Errorf("got %d bytes with the dictionary, %d without", w.Body.Len(), plain.Len())
+				}
+				rd = flate.NewReaderDict(w.Body, dict)
+			case "deflate":
+				rd = flate.NewReader(w.Body)
+			}
+			got, err := io.ReadAll(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != body {
+				t.Errorf("got %q, want %q", got, body)
+			}
+		})
+	}
+
+	// the dictionary codings aren't negotiated without dictionaries
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/users/42", nil)
+	r.Header.Set(acceptEncoding, "x-dcd")
+	r.Header.Set(availableDictionary, id)
+	CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	})).ServeHTTP(w, r)
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q without dictionaries", got)
+	}
+}
+
+func TestPooledWriter(t *testing.T) {
+	for _, enc := range []Encoder{gzipEncoder{}, flateEncoder{}} {
+		// the second writer may be the first one, reused
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			w := enc.NewWriter(&buf, gzip.BestSpeed)
+			if _, err := io.WriteString(w, "Gorilla!"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, "late"); err == nil {
+				t.Errorf("%T: write after close succeeded", enc)
+			}
+
+			var r io.Reader = flate.NewReader(&buf)
+			if _, ok := enc.(gzipEncoder); ok {
+				var err error
+				if r, err = gzip.NewReader(&buf); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if body, err := io.ReadAll(r); err != nil || string(body) != "Gorilla!" {
+				t.Errorf("%T: got %q, %v, want %q", enc, body, err, "Gorilla!")
+			}
+		}
+	}
+
+	if w := (gzipEncoder{}).NewWriter(io.Discard, 42); w != nil {
+		t.Errorf("got a writer for an invalid level")
+	}
+}
+
+func benchmarkCompressHandler(b *testing.B, encoding string) {
+	body := bytes.Repeat([]byte("Gorilla!\n"), 1024)
+	h := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := &headerOnlyResponseWriter{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Header.Set(acceptEncoding, encoding)
+		w.h = make(http.Header, 4)
+		h.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkCompressHandlerGzip(b *testing.B) {
+	benchmarkCompressHandler(b, "gzip")
+}
+
+func BenchmarkCompressHandlerDeflate(b *testing.B) {
+	benchmarkCompressHandler(b, "deflate")
+}
+
 // Make sure we can compress and serve an *os.File properly. We need
 // to use a real http server to trigger the net/http sendfile special
 // case.
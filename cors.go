@@ -1,57 +1,144 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/felixge/httpsnoop"
 )
 
 // CORSOption represents a functional option for configuring the CORS middleware.
 type CORSOption func(*cors) error
 
 type cors struct {
-	h                      http.Handler
 	allowedHeaders         []string
+	allowAllHeaders        bool
+	reflectRequestHeaders  bool
 	allowedMethods         []string
 	allowedOrigins         []string
 	allowedOriginValidator OriginValidator
+	originRequestValidator OriginRequestValidator
 	exposedHeaders         []string
+	exposeAllHeaders       bool
+	timingAllowedOrigins   []string
 	maxAge                 int
+	maxAgeLimit            int
 	ignoreOptions          bool
+	optionsPassthrough     bool
+	noPreflightVary        bool
+	onOriginRejected       func(*http.Request, string)
+	originDenied           http.Handler
+	onMethodDenied         func(*http.Request, string)
+	onHeaderDisallowed     func(*http.Request, string)
 	allowCredentials       bool
+	reflectOrigin          bool
+	preserveExisting       bool
+	skipPaths              []string
+	skipFunc               func(*http.Request) bool
+	normalizeOrigins       bool
+	dropDisallowedHeaders  bool
+	webSocketBypass        bool
+	webSocketOriginCheck   OriginRequestValidator
 	optionStatusCode       int
+	deniedStatusCode       int
+	deniedContentType      string
+	deniedBody             []byte
+
+	// Derived from the fields above by compile.
+	originSet       map[string]struct{}
+	originPatterns  []string
+	anyOrigin       bool
+	returnAnyOrigin bool
+	varyOrigin      bool
+	methodSet       map[string]struct{}
+	headerSet       corsHeaderSet
+	exposedValue    string
+	maxAgeValue     string
+	timingSet       map[string]struct{}
+	timingAny       bool
 }
 
 // OriginValidator takes an origin string and returns whether or not that origin is allowed.
 type OriginValidator func(string) bool
 
+// OriginRequestValidator takes a request and its origin and returns whether or
+// not that origin is allowed for the request.
+type OriginRequestValidator func(*http.Request, string) bool
+
 var (
 	defaultCorsOptionStatusCode = http.StatusOK
+	defaultCorsMaxAgeLimit      = 600
 	defaultCorsMethods          = []string{http.MethodGet, http.MethodHead, http.MethodPost}
 	defaultCorsHeaders          = []string{"Accept", "Accept-Language", "Content-Language", "Origin"}
 	// (WebKit/Safari v9 sends the Origin header by default in AJAX requests).
+
+	defaultCorsHeaderSet    = newCORSHeaderSet(defaultCorsHeaders)
+	corsPreflightVaryValues = []string{corsOriginHeader, corsRequestMethodHeader, corsRequestHeadersHeader}
 )
 
 const (
-	corsOptionMethod           string = http.MethodOptions
-	corsAllowOriginHeader      string = "Access-Control-Allow-Origin"
-	corsExposeHeadersHeader    string = "Access-Control-Expose-Headers"
-	corsMaxAgeHeader           string = "Access-Control-Max-Age"
-	corsAllowMethodsHeader     string = "Access-Control-Allow-Methods"
-	corsAllowHeadersHeader     string = "Access-Control-Allow-Headers"
-	corsAllowCredentialsHeader string = "Access-Control-Allow-Credentials"
-	corsRequestMethodHeader    string = "Access-Control-Request-Method"
-	corsRequestHeadersHeader   string = "Access-Control-Request-Headers"
-	corsOriginHeader           string = "Origin"
-	corsVaryHeader             string = "Vary"
-	corsOriginMatchAll         string = "*"
+	corsOptionMethod            string = http.MethodOptions
+	corsAllowOriginHeader       string = "Access-Control-Allow-Origin"
+	corsExposeHeadersHeader     string = "Access-Control-Expose-Headers"
+	corsMaxAgeHeader            string = "Access-Control-Max-Age"
+	corsAllowMethodsHeader      string = "Access-Control-Allow-Methods"
+	corsAllowHeadersHeader      string = "Access-Control-Allow-Headers"
+	corsAllowCredentialsHeader  string = "Access-Control-Allow-Credentials"
+	corsRequestMethodHeader     string = "Access-Control-Request-Method"
+	corsRequestHeadersHeader    string = "Access-Control-Request-Headers"
+	corsOriginHeader            string = "Origin"
+	corsVaryHeader              string = "Vary"
+	corsTimingAllowOriginHeader string = "Timing-Allow-Origin"
+	corsOriginMatchAll          string = "*"
 )
 
-func (ch *cors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// serve applies the CORS policy of ch to a request for next.
+func (ch *cors) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if hasAnyPrefix(r.URL.Path, ch.skipPaths) || (ch.skipFunc != nil && ch.skipFunc(r)) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
 	origin := r.Header.Get(corsOriginHeader)
-	if !ch.isOriginAllowed(origin) {
-		if r.Method != corsOptionMethod || ch.ignoreOptions {
-			ch.h.ServeHTTP(w, r)
+
+	if ch.webSocketBypass && isWebSocketUpgrade(r) {
+		if ch.webSocketOriginCheck != nil && !ch.webSocketOriginCheck(r, origin) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	// Preflight responses depend on all of the preflight request headers, so
+	// that shared caches must not serve one client's result to another.
+	h := w.Header()
+	_, preflight := r.Header[corsRequestMethodHeader]
+	preflightVary := preflight && r.Method == corsOptionMethod && !ch.ignoreOptions && !ch.noPreflightVary
+	if preflightVary {
+		for _, name := range corsPreflightVaryValues {
+			AddVary(h, name)
+		}
+	}
+
+	if !ch.isOriginAllowed(r, origin) {
+		if origin != "" && ch.onOriginRejected != nil {
+			ch.onOriginRejected(r, origin)
+		}
+		if origin != "" && ch.originDenied != nil {
+			ch.originDenied.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != corsOptionMethod || ch.ignoreOptions || ch.optionsPassthrough {
+			next.ServeHTTP(w, r)
 		}
 
 		return
@@ -59,81 +146,131 @@ func (ch *cors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == corsOptionMethod {
 		if ch.ignoreOptions {
-			ch.h.ServeHTTP(w, r)
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		if _, ok := r.Header[corsRequestMethodHeader]; !ok {
+		if !preflight {
+			if ch.optionsPassthrough {
+				next.ServeHTTP(w, r)
+				return
+			}
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
 		method := r.Header.Get(corsRequestMethodHeader)
-		if !ch.isMatch(method, ch.allowedMethods) {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+		if _, ok := ch.methodSet[method]; !ok {
+			if ch.onMethodDenied != nil {
+				ch.onMethodDenied(r, method)
+			}
+			ch.denyPreflight(w, http.StatusMethodNotAllowed)
 			return
 		}
 
-		requestHeaders := strings.Split(r.Header.Get(corsRequestHeadersHeader), ",")
-		allowedHeaders := []string{}
-		for _, v := range requestHeaders {
-			canonicalHeader := http.CanonicalHeaderKey(strings.TrimSpace(v))
-			if canonicalHeader == "" || ch.isMatch(canonicalHeader, defaultCorsHeaders) {
-				continue
-			}
+		// The header may be split across several lines, and its lists may
+		// contain empty elements.
+		requested := r.Header.Values(corsRequestHeadersHeader)
+		var buf [8]string
+		allowedHeaders := buf[:0]
+		authorization, dropped := false, false
+		for _, line := range requested {
+			for rest := line; rest != ""; {
+				var v string
+				v, rest, _ = strings.Cut(rest, ",")
+				v = strings.TrimSpace(v)
+				if v == "" {
+					continue
+				}
+				if _, ok := defaultCorsHeaderSet.lookup(v); ok {
+					continue
+				}
 
-			if !ch.isMatch(canonicalHeader, ch.allowedHeaders) {
-				w.WriteHeader(http.StatusForbidden)
-				return
-			}
+				canonicalHeader, ok := ch.headerSet.lookup(v)
+				if !ok && !ch.allowAllHeaders {
+					if ch.onHeaderDisallowed != nil {
+						ch.onHeaderDisallowed(r, http.CanonicalHeaderKey(v))
+					}
+					if ch.dropDisallowedHeaders {
+						dropped = true
+						continue
+					}
+					ch.denyPreflight(w, http.StatusForbidden)
+					return
+				}
+				if !ok {
+					canonicalHeader = http.CanonicalHeaderKey(v)
+				}
+				authorization = authorization || canonicalHeader == "Authorization"
 
-			allowedHeaders = append(allowedHeaders, canonicalHeader)
+				allowedHeaders = append(allowedHeaders, canonicalHeader)
+			}
 		}
 
-		if len(allowedHeaders) > 0 {
-			w.Header().Set(corsAllowHeadersHeader, strings.Join(allowedHeaders, ","))
+		if reflected := ch.reflectedRequestHeaders(requested, dropped); reflected != "" {
+			h[corsAllowHeadersHeader] = []string{reflected}
+		} else if len(allowedHeaders) > 0 {
+			if ch.allowAllHeaders && !ch.allowCredentials {
+				// The wildcard is not honoured for credentialed requests, nor
+				// does it cover the Authorization header, which must be listed
+				// explicitly.
+				allowedHeaders = append(allowedHeaders[:0], corsOriginMatchAll)
+				if authorization {
+					allowedHeaders = append(allowedHeaders, "Authorization")
+				}
+			}
+			h[corsAllowHeadersHeader] = []string{strings.Join(allowedHeaders, ",")}
 		}
 
-		if ch.maxAge > 0 {
-			w.Header().Set(corsMaxAgeHeader, strconv.Itoa(ch.maxAge))
+		if ch.maxAgeValue != "" {
+			h[corsMaxAgeHeader] = []string{ch.maxAgeValue}
 		}
 
-		if !ch.isMatch(method, defaultCorsMethods) {
-			w.Header().Set(corsAllowMethodsHeader, method)
+		if !isDefaultCorsMethod(method) {
+			h[corsAllowMethodsHeader] = []string{method}
+		}
+	} else {
+		if ch.exposeAllHeaders && !ch.allowCredentials {
+			h[corsExposeHeadersHeader] = []string{corsOriginMatchAll}
+		} else if ch.exposeAllHeaders {
+			// The wildcard is not honoured for credentialed requests, so the
+			// headers of the response are listed once they are known.
+			w = exposeResponseHeaders(w, ch.exposedHeaders)
+		} else if ch.exposedValue != "" {
+			h[corsExposeHeadersHeader] = []string{ch.exposedValue}
+		}
+		if ch.timingAny {
+			h[corsTimingAllowOriginHeader] = []string{corsOriginMatchAll}
+		} else if _, ok := ch.timingSet[origin]; ok {
+			h[corsTimingAllowOriginHeader] = []string{origin}
 		}
-	} else if len(ch.exposedHeaders) > 0 {
-		w.Header().Set(corsExposeHeadersHeader, strings.Join(ch.exposedHeaders, ","))
 	}
 
 	if ch.allowCredentials {
-		w.Header().Set(corsAllowCredentialsHeader, "true")
+		h[corsAllowCredentialsHeader] = []string{"true"}
 	}
 
-	if len(ch.allowedOrigins) > 1 {
-		w.Header().Set(corsVaryHeader, corsOriginHeader)
+	if (ch.reflectOrigin || ch.varyOrigin) && !preflightVary {
+		AddVary(h, corsOriginHeader)
 	}
 
-	returnOrigin := origin
-	if ch.allowedOriginValidator == nil && len(ch.allowedOrigins) == 0 {
-		returnOrigin = "*"
+	// A configuration of * is different than explicitly setting an allowed
+	// origin. Returning arbitrary origin headers in an access control allow
+	// origin header is unsafe and is not required by any use case.
+	if ch.returnAnyOrigin {
+		h[corsAllowOriginHeader] = []string{corsOriginMatchAll}
 	} else {
-		for _, o := range ch.allowedOrigins {
-			// A configuration of * is different than explicitly setting an allowed
-			// origin. Returning arbitrary origin headers in an access control allow
-			// origin header is unsafe and is not required by any use case.
-			if o == corsOriginMatchAll {
-				returnOrigin = "*"
-				break
-			}
-		}
+		h[corsAllowOriginHeader] = []string{origin}
 	}
-	w.Header().Set(corsAllowOriginHeader, returnOrigin)
 
-	if r.Method == corsOptionMethod {
+	if r.Method == corsOptionMethod && !ch.optionsPassthrough {
 		w.WriteHeader(ch.optionStatusCode)
 		return
 	}
-	ch.h.ServeHTTP(w, r)
+	if ch.preserveExisting {
+		w = preserveCORSHeaders(w)
+	}
+	next.ServeHTTP(w, r)
 }
 
 // CORS provides Cross-Origin Resource Sharing middleware.
@@ -155,26 +292,370 @@ func (ch *cors) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 //	    http.ListenAndServe(":8000", handlers.CORS()(r))
 //	}
 func CORS(opts ...CORSOption) func(http.Handler) http.Handler {
+	ch := parseCORSOptions(opts...)
 	return func(h http.Handler) http.Handler {
-		ch := parseCORSOptions(opts...)
-		ch.h = h
-		return ch
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ch.serve(w, r, h)
+		})
+	}
+}
+
+// CORSGroup provides Cross-Origin Resource Sharing middleware applying a
+// different policy depending on the path of the request. policies maps path
+// prefixes to the options of the policy applied to requests below them; the
+// policy with the longest matching prefix is applied, so that the empty prefix
+// can be used for a default policy. Requests matching no prefix are passed
+// through to the next handler without CORS processing.
+//
+// Example:
+//
+//	cors := handlers.CORSGroup(map[string][]handlers.CORSOption{
+//		"/api/public/":  {handlers.AllowedOrigins([]string{"*"})},
+//		"/api/private/": {handlers.AllowedOrigins([]string{"https://app.example.com"}), handlers.AllowCredentials()},
+//	})
+//	http.ListenAndServe(":8000", cors(r))
+func CORSGroup(policies map[string][]CORSOption) func(http.Handler) http.Handler {
+	g := make(corsGroup, 0, len(policies))
+	for prefix, opts := range policies {
+		g = append(g, corsPolicy{prefix: prefix, cors: parseCORSOptions(opts...)})
+	}
+	sort.Slice(g, func(i, j int) bool {
+		return len(g[i].prefix) > len(g[j].prefix)
+	})
+	return func(h http.Handler) http.Handler {
+		return corsGroupHandler{group: g, h: h}
+	}
+}
+
+type corsPolicy struct {
+	prefix string
+	cors   *cors
+}
+
+// corsGroup holds policies ordered by decreasing prefix length.
+type corsGroup []corsPolicy
+
+type corsGroupHandler struct {
+	group corsGroup
+	h     http.Handler
+}
+
+func (g corsGroupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, p := range g.group {
+		if strings.HasPrefix(r.URL.Path, p.prefix) {
+			p.cors.serve(w, r, g.h)
+			return
+		}
+	}
+	g.h.ServeHTTP(w, r)
+}
+
+// CORSWithError is like CORS, but returns an error if any of the options
+// fails or if the resulting configuration is invalid, e.g. because of a
+// negative MaxAge or because credentials are allowed for any origin, which
+// browsers refuse. It is meant to catch configuration errors at startup.
+func CORSWithError(opts ...CORSOption) (func(http.Handler) http.Handler, error) {
+	if _, err := newCORS(opts...); err != nil {
+		return nil, err
+	}
+	return CORS(opts...), nil
+}
+
+// CORSConfig is a declarative CORS configuration, e.g. loaded from a
+// configuration file, which can be used in place of functional options with
+// CORSFromConfig. Zero values select the defaults of the corresponding
+// options.
+type CORSConfig struct {
+	AllowedOrigins       []string `json:"allowedOrigins,omitempty" yaml:"allowedOrigins,omitempty"`
+	AllowedMethods       []string `json:"allowedMethods,omitempty" yaml:"allowedMethods,omitempty"`
+	AllowedHeaders       []string `json:"allowedHeaders,omitempty" yaml:"allowedHeaders,omitempty"`
+	ExposedHeaders       []string `json:"exposedHeaders,omitempty" yaml:"exposedHeaders,omitempty"`
+	TimingAllowedOrigins []string `json:"timingAllowedOrigins,omitempty" yaml:"timingAllowedOrigins,omitempty"`
+	MaxAge               int      `json:"maxAge,omitempty" yaml:"maxAge,omitempty"`
+	MaxAgeLimit          int      `json:"maxAgeLimit,omitempty" yaml:"maxAgeLimit,omitempty"` // negative removes the cap
+	AllowCredentials     bool     `json:"allowCredentials,omitempty" yaml:"allowCredentials,omitempty"`
+	ReflectOrigin        bool     `json:"reflectOrigin,omitempty" yaml:"reflectOrigin,omitempty"`
+	IgnoreOptions        bool     `json:"ignoreOptions,omitempty" yaml:"ignoreOptions,omitempty"`
+	OptionsPassthrough   bool     `json:"optionsPassthrough,omitempty" yaml:"optionsPassthrough,omitempty"`
+	OptionStatusCode     int      `json:"optionStatusCode,omitempty" yaml:"optionStatusCode,omitempty"`
+	NoPreflightVary      bool     `json:"noPreflightVary,omitempty" yaml:"noPreflightVary,omitempty"`
+}
+
+// Options returns the functional options equivalent to cfg.
+func (cfg CORSConfig) Options() []CORSOption {
+	var opts []CORSOption
+	if len(cfg.AllowedOrigins) > 0 {
+		opts = append(opts, AllowedOrigins(cfg.AllowedOrigins))
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		opts = append(opts, AllowedMethods(cfg.AllowedMethods))
+	}
+	if len(cfg.AllowedHeaders) > 0 {
+		opts = append(opts, AllowedHeaders(cfg.AllowedHeaders))
+	}
+	if len(cfg.ExposedHeaders) > 0 {
+		opts = append(opts, ExposedHeaders(cfg.ExposedHeaders))
+	}
+	if len(cfg.TimingAllowedOrigins) > 0 {
+		opts = append(opts, TimingAllowedOrigins(cfg.TimingAllowedOrigins))
+	}
+	if cfg.MaxAge != 0 {
+		opts = append(opts, MaxAge(cfg.MaxAge))
+	}
+	if cfg.MaxAgeLimit != 0 {
+		opts = append(opts, MaxAgeLimit(cfg.MaxAgeLimit))
+	}
+	if cfg.AllowCredentials {
+		opts = append(opts, AllowCredentials())
+	}
+	if cfg.ReflectOrigin {
+		opts = append(opts, ReflectOrigin())
+	}
+	if cfg.IgnoreOptions {
+		opts = append(opts, IgnoreOptions())
+	}
+	if cfg.OptionsPassthrough {
+		opts = append(opts, OptionsPassthrough())
+	}
+	if cfg.OptionStatusCode != 0 {
+		opts = append(opts, OptionStatusCode(cfg.OptionStatusCode))
+	}
+	if cfg.NoPreflightVary {
+		opts = append(opts, NoPreflightVary())
+	}
+	return opts
+}
+
+// CORSFromConfig provides Cross-Origin Resource Sharing middleware configured
+// by cfg, which is validated as by CORSWithError.
+//
+// Example:
+//
+//	var cfg struct {
+//		CORS handlers.CORSConfig `json:"cors"`
+//	}
+//	if err := json.Unmarshal(data, &cfg); err != nil {
+//		log.Fatal(err)
+//	}
+//	cors, err := handlers.CORSFromConfig(cfg.CORS)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":8000", cors(r))
+func CORSFromConfig(cfg CORSConfig) (func(http.Handler) http.Handler, error) {
+	return CORSWithError(cfg.Options()...)
+}
+
+// CORSHandler provides Cross-Origin Resource Sharing middleware whose policy
+// can be changed at runtime, e.g. to refresh allowed origins managed in a
+// database without restarting the server. Changes are applied atomically and
+// take effect for the following requests.
+//
+// A CORSHandler may wrap any number of handlers with its Handler method, all
+// of which share its policy. The policy is exposed with Config rather than as
+// fields, so that it can't be changed while requests are being served.
+type CORSHandler struct {
+	mu     sync.Mutex // serializes updates
+	policy atomic.Value
+}
+
+// NewCORS returns a CORSHandler with the policy configured by opts. Errors
+// returned by options are ignored, as with CORS; use CORSWithError to check a
+// configuration.
+//
+// Example:
+//
+//	cors := handlers.NewCORS(handlers.AllowedOrigins(loadOrigins()))
+//	http.ListenAndServe(":8000", cors.Handler(r))
+//
+//	// Later, e.g. when the origins change:
+//	err := cors.SetAllowedOrigins(loadOrigins())
+func NewCORS(opts ...CORSOption) *CORSHandler {
+	c := &CORSHandler{}
+	c.policy.Store(parseCORSOptions(opts...))
+	return c
+}
+
+// Handler returns a handler applying the current CORS policy to requests for
+// h.
+func (c *CORSHandler) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.load().serve(w, r, h)
+	})
+}
+
+// SetAllowedOrigins replaces the allowed origins, as set with AllowedOrigins.
+func (c *CORSHandler) SetAllowedOrigins(origins []string) error {
+	return c.Update(AllowedOrigins(origins))
+}
+
+// SetAllowedMethods replaces the allowed methods, as set with AllowedMethods.
+func (c *CORSHandler) SetAllowedMethods(methods []string) error {
+	return c.Update(AllowedMethods(methods))
+}
+
+// SetAllowedHeaders replaces the allowed headers, as set with AllowedHeaders.
+func (c *CORSHandler) SetAllowedHeaders(headers []string) error {
+	return c.Update(func(ch *cors) error {
+		ch.allowedHeaders = defaultCorsHeaders
+		ch.allowAllHeaders = false
+		return AllowedHeaders(headers)(ch)
+	})
+}
+
+// Update applies opts to the current policy. If an option fails or the
+// resulting policy is invalid, as reported by CORSWithError, the policy is
+// left unchanged and the error is returned.
+func (c *CORSHandler) Update(opts ...CORSOption) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := *c.load()
+	for _, option := range opts {
+		if err := option(&ch); err != nil {
+			return err
+		}
 	}
+	ch.compile()
+	if err := ch.validate(); err != nil {
+		return err
+	}
+	c.policy.Store(&ch)
+	return nil
+}
+
+// Config returns the current policy. Validators, callbacks and handlers
+// configured by options have no counterpart in CORSConfig and are omitted.
+func (c *CORSHandler) Config() CORSConfig {
+	ch := c.load()
+	cfg := CORSConfig{
+		AllowedOrigins:       append([]string(nil), ch.allowedOrigins...),
+		AllowedMethods:       append([]string(nil), ch.allowedMethods...),
+		AllowedHeaders:       append([]string(nil), ch.allowedHeaders...),
+		ExposedHeaders:       append([]string(nil), ch.exposedHeaders...),
+		TimingAllowedOrigins: append([]string(nil), ch.timingAllowedOrigins...),
+		MaxAge:               ch.maxAge,
+		MaxAgeLimit:          ch.maxAgeLimit,
+		AllowCredentials:     ch.allowCredentials,
+		ReflectOrigin:        ch.reflectOrigin,
+		IgnoreOptions:        ch.ignoreOptions,
+		OptionsPassthrough:   ch.optionsPassthrough,
+		OptionStatusCode:     ch.optionStatusCode,
+		NoPreflightVary:      ch.noPreflightVary,
+	}
+	if ch.allowAllHeaders {
+		cfg.AllowedHeaders = append(cfg.AllowedHeaders, corsOriginMatchAll)
+	}
+	if ch.exposeAllHeaders {
+		cfg.ExposedHeaders = append(cfg.ExposedHeaders, corsOriginMatchAll)
+	}
+	if cfg.MaxAgeLimit <= 0 {
+		cfg.MaxAgeLimit = -1
+	}
+	return cfg
+}
+
+// OriginAllowed reports whether the current policy allows CORS requests from
+// origin. A validator set with AllowedOriginRequestValidator is given a GET
+// request for "/" from origin; use OriginAllowedFor to query the policy for a
+// specific request.
+func (c *CORSHandler) OriginAllowed(origin string) bool {
+	r := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/"}, Header: http.Header{corsOriginHeader: {origin}}}
+	return c.OriginAllowedFor(r)
+}
+
+// OriginAllowedFor reports whether the current policy allows the origin of
+// r.
+func (c *CORSHandler) OriginAllowedFor(r *http.Request) bool {
+	return c.load().isOriginAllowed(r, r.Header.Get(corsOriginHeader))
+}
+
+// MethodAllowed reports whether the current policy allows preflight requests
+// for method.
+func (c *CORSHandler) MethodAllowed(method string) bool {
+	_, ok := c.load().methodSet[method]
+	return ok
+}
+
+// HeaderAllowed reports whether the current policy allows preflight requests
+// for requests with the given header.
+func (c *CORSHandler) HeaderAllowed(header string) bool {
+	ch := c.load()
+	if _, ok := defaultCorsHeaderSet.lookup(header); ok || ch.allowAllHeaders {
+		return true
+	}
+	_, ok := ch.headerSet.lookup(header)
+	return ok
+}
+
+// IsPreflight reports whether r is a CORS preflight request which the current
+// policy would answer itself, i.e. an OPTIONS request with Origin and
+// Access-Control-Request-Method headers which is neither skipped nor ignored
+// (see IgnoreOptions and OptionsPassthrough).
+func (c *CORSHandler) IsPreflight(r *http.Request) bool {
+	ch := c.load()
+	if r.Method != corsOptionMethod || r.Header.Get(corsOriginHeader) == "" {
+		return false
+	}
+	if _, ok := r.Header[corsRequestMethodHeader]; !ok {
+		return false
+	}
+	if ch.ignoreOptions || ch.optionsPassthrough || hasAnyPrefix(r.URL.Path, ch.skipPaths) || (ch.skipFunc != nil && ch.skipFunc(r)) {
+		return false
+	}
+	return true
+}
+
+func (c *CORSHandler) load() *cors {
+	return c.policy.Load().(*cors)
 }
 
 func parseCORSOptions(opts ...CORSOption) *cors {
+	ch, _ := newCORS(opts...)
+	return ch
+}
+
+// newCORS applies opts and validates the result. Options are applied in full
+// even if some of them fail, so that the returned cors is always usable.
+func newCORS(opts ...CORSOption) (*cors, error) {
 	ch := &cors{
 		allowedMethods:   defaultCorsMethods,
 		allowedHeaders:   defaultCorsHeaders,
 		allowedOrigins:   []string{},
 		optionStatusCode: defaultCorsOptionStatusCode,
+		maxAgeLimit:      defaultCorsMaxAgeLimit,
 	}
 
+	var err error
 	for _, option := range opts {
-		_ = option(ch) //TODO: @bharat-rajani, return error to caller if not nil?
+		if oerr := option(ch); oerr != nil && err == nil {
+			err = oerr
+		}
+	}
+	ch.compile()
+	if err == nil {
+		err = ch.validate()
 	}
 
-	return ch
+	return ch, err
+}
+
+// validate reports inconsistencies in the configuration of ch.
+func (ch *cors) validate() error {
+	if ch.maxAge < 0 {
+		return fmt.Errorf("handlers: CORS: negative max age %d", ch.maxAge)
+	}
+	if ch.optionStatusCode < 200 || ch.optionStatusCode > 299 {
+		return fmt.Errorf("handlers: CORS: invalid preflight status code %d", ch.optionStatusCode)
+	}
+	if ch.deniedStatusCode != 0 && (ch.deniedStatusCode < 200 || ch.deniedStatusCode > 599) {
+		return fmt.Errorf("handlers: CORS: invalid denied preflight status code %d", ch.deniedStatusCode)
+	}
+	if ch.allowCredentials && !ch.reflectOrigin && ch.allowedOriginValidator == nil && ch.originRequestValidator == nil &&
+		(len(ch.allowedOrigins) == 0 || ch.isMatch(corsOriginMatchAll, ch.allowedOrigins)) {
+		return errors.New("handlers: CORS: credentials cannot be allowed for any origin")
+	}
+	return nil
 }
 
 //
@@ -187,6 +668,10 @@ func parseCORSOptions(opts ...CORSOption) *cors {
 // and Content-Language are always allowed.
 // Content-Type must be explicitly declared if accepting Content-Types other than
 // application/x-www-form-urlencoded, multipart/form-data, or text/plain.
+// Passing in "*" allows any header: preflight requests are then answered with
+// an Access-Control-Allow-Headers value of "*", or with the requested headers
+// if AllowCredentials is set, since browsers don't honour the wildcard for
+// credentialed requests.
 func AllowedHeaders(headers []string) CORSOption {
 	return func(ch *cors) error {
 		for _, v := range headers {
@@ -194,6 +679,10 @@ func AllowedHeaders(headers []string) CORSOption {
 			if normalizedHeader == "" {
 				continue
 			}
+			if normalizedHeader == corsOriginMatchAll {
+				ch.allowAllHeaders = true
+				continue
+			}
 
 			if !ch.isMatch(normalizedHeader, ch.allowedHeaders) {
 				ch.allowedHeaders = append(ch.allowedHeaders, normalizedHeader)
@@ -204,6 +693,32 @@ func AllowedHeaders(headers []string) CORSOption {
 	}
 }
 
+// DropDisallowedHeaders causes the CORS middleware to leave headers which are
+// not allowed out of the Access-Control-Allow-Headers header of the responses
+// to preflight requests, instead of rejecting the preflight requests with a
+// 403 "Forbidden". Browsers still refuse to send requests with such headers,
+// but clients which merely advertise harmless headers they don't rely on can
+// proceed without them.
+func DropDisallowedHeaders() CORSOption {
+	return func(ch *cors) error {
+		ch.dropDisallowedHeaders = true
+		return nil
+	}
+}
+
+// ReflectRequestHeaders causes the CORS middleware to answer valid preflight
+// requests with an Access-Control-Allow-Headers header echoing their
+// Access-Control-Request-Headers header verbatim, preserving the case and
+// order of the header names, rather than the canonicalized list of allowed
+// headers. This helps clients and gateways comparing the lists as strings.
+// Requested headers are still checked against the allowed headers.
+func ReflectRequestHeaders() CORSOption {
+	return func(ch *cors) error {
+		ch.reflectRequestHeaders = true
+		return nil
+	}
+}
+
 // AllowedMethods can be used to explicitly allow methods in the
 // Access-Control-Allow-Methods header.
 // This is a replacement operation so you must also
@@ -229,6 +744,9 @@ func AllowedMethods(methods []string) CORSOption {
 // AllowedOrigins sets the allowed origins for CORS requests, as used in the
 // 'Allow-Access-Control-Origin' HTTP header.
 // Note: Passing in a []string{"*"} will allow any domain.
+// An origin ending in ":*", such as "http://localhost:*", allows its scheme and
+// host with any port, or none, which is useful for development servers
+// listening on random ports.
 func AllowedOrigins(origins []string) CORSOption {
 	return func(ch *cors) error {
 		for _, v := range origins {
@@ -243,6 +761,19 @@ func AllowedOrigins(origins []string) CORSOption {
 	}
 }
 
+// NormalizeOrigins causes origins to be compared to those set with
+// AllowedOrigins regardless of the case of their scheme and host and of the
+// presence of the default port of their scheme, so that e.g.
+// "https://Example.com", "https://example.com:443" and "https://example.com"
+// are considered the same. The origin is still reflected as sent by the
+// client. Validators are given origins as sent by the client as well.
+func NormalizeOrigins() CORSOption {
+	return func(ch *cors) error {
+		ch.normalizeOrigins = true
+		return nil
+	}
+}
+
 // AllowedOriginValidator sets a function for evaluating allowed origins in CORS requests, represented by the
 // 'Allow-Access-Control-Origin' HTTP header.
 func AllowedOriginValidator(fn OriginValidator) CORSOption {
@@ -252,6 +783,79 @@ func AllowedOriginValidator(fn OriginValidator) CORSOption {
 	}
 }
 
+// AllowedOriginRequestValidator sets a function for evaluating allowed origins
+// in CORS requests which, unlike AllowedOriginValidator, is also given the
+// request, so that the decision can depend on its path, host or headers.
+// It takes precedence over AllowedOriginValidator.
+//
+// Example, allowing any origin for public endpoints only:
+//
+//	handlers.AllowedOriginRequestValidator(func(r *http.Request, origin string) bool {
+//		return strings.HasPrefix(r.URL.Path, "/public/") || origin == "https://admin.example.com"
+//	})
+func AllowedOriginRequestValidator(fn OriginRequestValidator) CORSOption {
+	return func(ch *cors) error {
+		ch.originRequestValidator = fn
+		return nil
+	}
+}
+
+// PreflightDeniedResponse sets the response to preflight requests rejected
+// because of their method or headers, which are otherwise answered with a 405
+// "Method Not Allowed" or a 403 "Forbidden" without a body. If code is 0, the
+// default status codes are kept. body may be empty.
+func PreflightDeniedResponse(code int, contentType string, body []byte) CORSOption {
+	return func(ch *cors) error {
+		ch.deniedStatusCode = code
+		ch.deniedContentType = contentType
+		ch.deniedBody = body
+		return nil
+	}
+}
+
+// reflectedRequestHeaders returns the value of the
+// Access-Control-Allow-Headers header echoing the requested headers, if
+// ReflectRequestHeaders is set. If some of them were dropped, the allowed ones
+// are echoed in their original case and order.
+func (ch *cors) reflectedRequestHeaders(requested []string, dropped bool) string {
+	if !ch.reflectRequestHeaders {
+		return ""
+	}
+	if !dropped {
+		return strings.TrimSpace(strings.Join(requested, ","))
+	}
+
+	var kept []string
+	for _, line := range requested {
+		for _, v := range strings.Split(line, ",") {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			_, isDefault := defaultCorsHeaderSet.lookup(v)
+			if _, ok := ch.headerSet.lookup(v); ok || isDefault {
+				kept = append(kept, v)
+			}
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+// denyPreflight answers a rejected preflight request, with code unless
+// another status code is configured.
+func (ch *cors) denyPreflight(w http.ResponseWriter, code int) {
+	if ch.deniedStatusCode != 0 {
+		code = ch.deniedStatusCode
+	}
+	if len(ch.deniedBody) > 0 && ch.deniedContentType != "" {
+		w.Header().Set("Content-Type", ch.deniedContentType)
+	}
+	w.WriteHeader(code)
+	if len(ch.deniedBody) > 0 {
+		_, _ = w.Write(ch.deniedBody)
+	}
+}
+
 // OptionStatusCode sets a custom status code on the OPTIONS requests.
 // Default behaviour sets it to 200 to reflect best practices. This is option is not mandatory
 // and can be used if you need a custom status code (i.e 204).
@@ -267,14 +871,23 @@ func OptionStatusCode(code int) CORSOption {
 
 // ExposedHeaders can be used to specify headers that are available
 // and will not be stripped out by the user-agent.
+// Passing in "*" exposes all headers: responses then carry an
+// Access-Control-Expose-Headers value of "*", or, if AllowCredentials is set,
+// the list of the headers of the response, since browsers don't honour the
+// wildcard for credentialed requests.
 func ExposedHeaders(headers []string) CORSOption {
 	return func(ch *cors) error {
 		ch.exposedHeaders = []string{}
+		ch.exposeAllHeaders = false
 		for _, v := range headers {
 			normalizedHeader := http.CanonicalHeaderKey(strings.TrimSpace(v))
 			if normalizedHeader == "" {
 				continue
 			}
+			if normalizedHeader == corsOriginMatchAll {
+				ch.exposeAllHeaders = true
+				continue
+			}
 
 			if !ch.isMatch(normalizedHeader, ch.exposedHeaders) {
 				ch.exposedHeaders = append(ch.exposedHeaders, normalizedHeader)
@@ -285,21 +898,47 @@ func ExposedHeaders(headers []string) CORSOption {
 	}
 }
 
+// TimingAllowedOrigins sets the origins allowed to see Resource Timing data
+// of cross-origin responses, via the Timing-Allow-Origin header. The header is
+// set on responses to requests from allowed CORS origins which are also in
+// origins; passing in []string{"*"} allows timing data for any allowed origin.
+func TimingAllowedOrigins(origins []string) CORSOption {
+	return func(ch *cors) error {
+		ch.timingAllowedOrigins = origins
+		return nil
+	}
+}
+
 // MaxAge determines the maximum age (in seconds) between preflight requests. A
-// maximum of 10 minutes is allowed. An age above this value will default to 10
-// minutes.
+// maximum of 10 minutes is allowed by default. An age above this value will
+// default to 10 minutes, unless the limit is changed with MaxAgeLimit.
 func MaxAge(age int) CORSOption {
 	return func(ch *cors) error {
-		// Maximum of 10 minutes.
-		if age > 600 {
-			age = 600
-		}
-
 		ch.maxAge = age
 		return nil
 	}
 }
 
+// MaxAgeLimit changes the maximum age (in seconds) allowed by MaxAge from its
+// default of 10 minutes, letting browsers which honour longer durations
+// (e.g. Firefox, up to 24 hours) cache preflight responses for longer. A limit
+// of 0 or less removes the cap.
+func MaxAgeLimit(limit int) CORSOption {
+	return func(ch *cors) error {
+		ch.maxAgeLimit = limit
+		return nil
+	}
+}
+
+// effectiveMaxAge returns the max age of preflight responses, capped to the
+// limit.
+func (ch *cors) effectiveMaxAge() int {
+	if ch.maxAgeLimit > 0 && ch.maxAge > ch.maxAgeLimit {
+		return ch.maxAgeLimit
+	}
+	return ch.maxAge
+}
+
 // IgnoreOptions causes the CORS middleware to ignore OPTIONS requests, instead
 // passing them through to the next handler. This is useful when your application
 // or framework has a pre-existing mechanism for responding to OPTIONS requests.
@@ -310,6 +949,128 @@ func IgnoreOptions() CORSOption {
 	}
 }
 
+// OptionsPassthrough causes the CORS middleware to handle preflight requests
+// as usual, setting the CORS response headers, but to then pass them through
+// to the next handler rather than responding itself. Unlike IgnoreOptions,
+// this lets the next handler add its own headers (e.g. Allow) and choose the
+// response status, while the middleware remains in charge of the CORS policy.
+// OPTIONS requests which are not valid CORS preflight requests are passed
+// through unchanged. Preflight requests rejected by the policy are still
+// answered by the middleware. OptionStatusCode has no effect in this mode.
+func OptionsPassthrough() CORSOption {
+	return func(ch *cors) error {
+		ch.optionsPassthrough = true
+		return nil
+	}
+}
+
+// SkipPaths causes the CORS middleware to pass requests whose path starts with
+// any of the given prefixes through to the next handler untouched, without
+// CORS headers or preflight handling, e.g. for health checks, metrics or
+// webhook receivers.
+func SkipPaths(prefixes []string) CORSOption {
+	return func(ch *cors) error {
+		ch.skipPaths = append(ch.skipPaths, prefixes...)
+		return nil
+	}
+}
+
+// SkipFunc causes the CORS middleware to pass requests for which fn returns
+// true through to the next handler untouched, like SkipPaths.
+func SkipFunc(fn func(*http.Request) bool) CORSOption {
+	return func(ch *cors) error {
+		ch.skipFunc = fn
+		return nil
+	}
+}
+
+// WebSocketBypass causes the CORS middleware to pass WebSocket handshake
+// requests through to the next handler without CORS processing, since
+// browsers don't apply CORS to WebSocket connections. If check is not nil,
+// handshakes whose origin it rejects are answered with a 403 "Forbidden"
+// instead; WebSocket endpoints should check origins one way or another to
+// prevent cross-site WebSocket hijacking.
+func WebSocketBypass(check OriginRequestValidator) CORSOption {
+	return func(ch *cors) error {
+		ch.webSocketBypass = true
+		ch.webSocketOriginCheck = check
+		return nil
+	}
+}
+
+// isWebSocketUpgrade reports whether r is a WebSocket handshake request.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") && headerHasToken(r.Header, "Connection", "upgrade")
+}
+
+// PreserveExistingCORSHeaders causes the CORS middleware to leave the CORS
+// headers of responses alone if the next handler sets an
+// Access-Control-Allow-Origin header itself, as a reverse proxy to a backend
+// handling CORS does. Otherwise, the CORS headers of the middleware are set
+// when the response headers are written. Preflight requests answered by the
+// middleware are not affected.
+func PreserveExistingCORSHeaders() CORSOption {
+	return func(ch *cors) error {
+		ch.preserveExisting = true
+		return nil
+	}
+}
+
+// NoPreflightVary stops the CORS middleware from adding a Vary header listing
+// Origin, Access-Control-Request-Method and Access-Control-Request-Headers to
+// the responses to preflight requests, which it does by default so that
+// shared caches don't serve one client's preflight response to another.
+func NoPreflightVary() CORSOption {
+	return func(ch *cors) error {
+		ch.noPreflightVary = true
+		return nil
+	}
+}
+
+// CORSOnOriginRejected registers fn to be called with the request and its
+// Origin header whenever a cross-origin request is rejected because of its
+// origin, e.g. to log the rejection or count it. Requests without an Origin
+// header are not cross-origin requests and don't trigger it.
+func CORSOnOriginRejected(fn func(r *http.Request, origin string)) CORSOption {
+	return func(ch *cors) error {
+		ch.onOriginRejected = fn
+		return nil
+	}
+}
+
+// OriginDeniedHandler sets the handler responding to requests whose Origin
+// header is not allowed, in place of the default behaviour of passing them
+// through to the next handler without CORS headers, or of responding to them
+// with an empty body if they are preflight requests. The handler may for
+// example respond with an error body, or record the request before passing it
+// on. Requests without an Origin header are not affected.
+func OriginDeniedHandler(h http.Handler) CORSOption {
+	return func(ch *cors) error {
+		ch.originDenied = h
+		return nil
+	}
+}
+
+// CORSOnMethodDenied registers fn to be called with the request and the
+// requested method whenever a preflight request is answered with a 405
+// "Method Not Allowed" because the method is not allowed.
+func CORSOnMethodDenied(fn func(r *http.Request, method string)) CORSOption {
+	return func(ch *cors) error {
+		ch.onMethodDenied = fn
+		return nil
+	}
+}
+
+// CORSOnHeaderDisallowed registers fn to be called with the request and the
+// first offending header whenever a preflight request is answered with a 403
+// "Forbidden" because one of the requested headers is not allowed.
+func CORSOnHeaderDisallowed(fn func(r *http.Request, header string)) CORSOption {
+	return func(ch *cors) error {
+		ch.onHeaderDisallowed = fn
+		return nil
+	}
+}
+
 // AllowCredentials can be used to specify that the user agent may pass
 // authentication details along with the request.
 func AllowCredentials() CORSOption {
@@ -319,21 +1080,138 @@ func AllowCredentials() CORSOption {
 	}
 }
 
-func (ch *cors) isOriginAllowed(origin string) bool {
+// ReflectOrigin causes the CORS middleware to always respond with the
+// validated origin of the request in the Access-Control-Allow-Origin header,
+// rather than with "*", and to add Origin to the Vary header of every CORS
+// response accordingly. This is required for AllowCredentials to work with
+// origins allowed by a wildcard, since browsers refuse credentialed responses
+// allowing any origin with "*".
+//
+// Combining ReflectOrigin and AllowCredentials with the default of allowing
+// all origins lets any website make credentialed requests; restrict origins
+// with AllowedOrigins or a validator unless that is what you intend.
+func ReflectOrigin() CORSOption {
+	return func(ch *cors) error {
+		ch.reflectOrigin = true
+		return nil
+	}
+}
+
+// corsSafelistedResponseHeaders are exposed to clients without being listed in
+// Access-Control-Expose-Headers.
+var corsSafelistedResponseHeaders = []string{
+	"Cache-Control", "Content-Language", "Content-Length", "Content-Type",
+	"Expires", "Last-Modified", "Pragma",
+}
+
+// exposeResponseHeaders returns a ResponseWriter setting the
+// Access-Control-Expose-Headers header of the response to the names of its
+// headers, and extra, when the response headers are written.
+func exposeResponseHeaders(w http.ResponseWriter, extra []string) http.ResponseWriter {
+	return beforeWriteHeader(w, func() {
+		h := w.Header()
+		if h.Get(corsExposeHeadersHeader) != "" {
+			return
+		}
+		names := append([]string{}, extra...)
+		for name := range h {
+			if name == "Set-Cookie" || strings.HasPrefix(name, "Access-Control-") ||
+				containsString(corsSafelistedResponseHeaders, name) || containsString(names, name) {
+				continue
+			}
+			names = append(names, name)
+		}
+		if len(names) > 0 {
+			sort.Strings(names[len(extra):])
+			h.Set(corsExposeHeadersHeader, strings.Join(names, ","))
+		}
+	})
+}
+
+// preserveCORSHeaders removes the CORS headers set on w, and returns a
+// ResponseWriter setting them again when the response headers are written,
+// unless the response already has an Access-Control-Allow-Origin header.
+func preserveCORSHeaders(w http.ResponseWriter) http.ResponseWriter {
+	h := w.Header()
+	saved := http.Header{}
+	for name, values := range h {
+		if strings.HasPrefix(name, "Access-Control-") || name == corsTimingAllowOriginHeader {
+			saved[name] = values
+			delete(h, name)
+		}
+	}
+
+	return beforeWriteHeader(w, func() {
+		if _, ok := h[corsAllowOriginHeader]; ok {
+			return
+		}
+		for name, values := range saved {
+			h[name] = values
+		}
+	})
+}
+
+// beforeWriteHeader returns a ResponseWriter calling fn once, just before the
+// response headers are written.
+func beforeWriteHeader(w http.ResponseWriter, fn func()) http.ResponseWriter {
+	var once sync.Once
+	before := func() {
+		once.Do(fn)
+	}
+
+	return httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				before()
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				before()
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				before()
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				before()
+				next()
+			}
+		},
+	})
+}
+
+func (ch *cors) isOriginAllowed(r *http.Request, origin string) bool {
 	if origin == "" {
 		return false
 	}
 
+	if ch.originRequestValidator != nil {
+		return ch.originRequestValidator(r, origin)
+	}
+
 	if ch.allowedOriginValidator != nil {
 		return ch.allowedOriginValidator(origin)
 	}
 
-	if len(ch.allowedOrigins) == 0 {
+	if ch.anyOrigin {
 		return true
 	}
 
-	for _, allowedOrigin := range ch.allowedOrigins {
-		if allowedOrigin == origin || allowedOrigin == corsOriginMatchAll {
+	origin = ch.normalizeOrigin(origin)
+
+	if _, ok := ch.originSet[origin]; ok {
+		return true
+	}
+
+	for _, pattern := range ch.originPatterns {
+		if matchOriginPort(pattern, origin) {
 			return true
 		}
 	}
@@ -341,6 +1219,129 @@ func (ch *cors) isOriginAllowed(origin string) bool {
 	return false
 }
 
+// corsAnyPort is the suffix of allowed origins matching any port.
+const corsAnyPort = ":*"
+
+// matchOriginPort reports whether origin matches pattern, an origin whose port
+// is "*", with any port or none.
+func matchOriginPort(pattern, origin string) bool {
+	if !strings.HasSuffix(pattern, corsAnyPort) {
+		return false
+	}
+	base := strings.TrimSuffix(pattern, corsAnyPort)
+	if origin == base {
+		return true
+	}
+	if !strings.HasPrefix(origin, base+":") {
+		return false
+	}
+	port := origin[len(base)+1:]
+	if port == "" {
+		return false
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compile derives the lookup tables and precomputed header values used to
+// serve requests from the configuration of ch. It must be called after ch
+// is changed.
+func (ch *cors) compile() {
+	ch.originSet = make(map[string]struct{}, len(ch.allowedOrigins))
+	ch.originPatterns = nil
+	ch.anyOrigin = len(ch.allowedOrigins) == 0
+	for _, o := range ch.allowedOrigins {
+		switch {
+		case o == corsOriginMatchAll:
+			ch.anyOrigin = true
+		case strings.HasSuffix(o, corsAnyPort):
+			ch.originPatterns = append(ch.originPatterns, ch.normalizeOrigin(o))
+		default:
+			ch.originSet[ch.normalizeOrigin(o)] = struct{}{}
+		}
+	}
+	ch.returnAnyOrigin = !ch.reflectOrigin && (ch.isMatch(corsOriginMatchAll, ch.allowedOrigins) ||
+		(ch.allowedOriginValidator == nil && ch.originRequestValidator == nil && len(ch.allowedOrigins) == 0))
+	// The allowed origin depends on the origin of requests in a way not
+	// covered by other headers.
+	ch.varyOrigin = len(ch.allowedOrigins) > 1 || len(ch.originPatterns) > 0
+
+	ch.methodSet = make(map[string]struct{}, len(ch.allowedMethods))
+	for _, m := range ch.allowedMethods {
+		ch.methodSet[m] = struct{}{}
+	}
+	ch.headerSet = newCORSHeaderSet(ch.allowedHeaders)
+	ch.exposedValue = strings.Join(ch.exposedHeaders, ",")
+
+	ch.maxAgeValue = ""
+	if maxAge := ch.effectiveMaxAge(); maxAge > 0 {
+		ch.maxAgeValue = strconv.Itoa(maxAge)
+	}
+
+	ch.timingSet = make(map[string]struct{}, len(ch.timingAllowedOrigins))
+	ch.timingAny = false
+	for _, o := range ch.timingAllowedOrigins {
+		ch.timingSet[o] = struct{}{}
+		ch.timingAny = ch.timingAny || o == corsOriginMatchAll
+	}
+}
+
+// normalizeOrigin returns the form of origin used for matching: origin
+// itself, or if NormalizeOrigins is set, origin in lower case and without
+// the default port of its scheme.
+func (ch *cors) normalizeOrigin(origin string) string {
+	if !ch.normalizeOrigins {
+		return origin
+	}
+	origin = strings.ToLower(origin)
+	switch {
+	case strings.HasPrefix(origin, "http://"):
+		origin = strings.TrimSuffix(origin, ":80")
+	case strings.HasPrefix(origin, "https://"):
+		origin = strings.TrimSuffix(origin, ":443")
+	}
+	return origin
+}
+
+func isDefaultCorsMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPost
+}
+
+// corsHeaderSet maps lower-case header names to their canonical form.
+type corsHeaderSet map[string]string
+
+func newCORSHeaderSet(names []string) corsHeaderSet {
+	s := make(corsHeaderSet, len(names))
+	for _, name := range names {
+		s[strings.ToLower(name)] = http.CanonicalHeaderKey(name)
+	}
+	return s
+}
+
+// lookup returns the canonical form of name, if s contains it in any case.
+// It doesn't allocate for names of reasonable length.
+func (s corsHeaderSet) lookup(name string) (string, bool) {
+	var buf [64]byte
+	if len(name) > len(buf) {
+		canonical, ok := s[strings.ToLower(name)]
+		return canonical, ok
+	}
+	lower := buf[:len(name)]
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	canonical, ok := s[string(lower)]
+	return canonical, ok
+}
+
 func (ch *cors) isMatch(needle string, haystack []string) bool {
 	for _, v := range haystack {
 		if v == needle {
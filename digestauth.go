@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type digestAuthUserKey struct{}
+
+// DigestAuthSecrets returns the password of username, or false if the user
+// doesn't exist.
+type DigestAuthSecrets func(username string) (password string, ok bool)
+
+type digestAuthHandler struct {
+	h          http.Handler
+	secrets    DigestAuthSecrets
+	realm      string
+	algorithms []string
+	nonceTTL   time.Duration
+	onFailure  func(r *http.Request, username string)
+	now        func() time.Time
+
+	key    []byte
+	opaque string
+
+	mu        sync.Mutex
+	counts    map[string]uint64
+	lastSweep time.Time
+}
+
+// DigestAuthOption provides a functional approach to configure the
+// DigestAuthHandler middleware.
+type DigestAuthOption func(*digestAuthHandler)
+
+// DigestAuthHandler is HTTP middleware that requires requests to carry HTTP
+// Digest credentials (RFC 7616) for a user known to secrets. Other requests
+// are answered with a 401 "Unauthorized" and one WWW-Authenticate challenge
+// per supported algorithm, SHA-256 and MD5 by default.
+//
+// Only the "auth" quality of protection is supported. Nonces are stateless
+// and expire after 5 minutes by default, upon which clients are asked to
+// retry with a fresh nonce. Replay protection tracks the nonce count of every
+// nonce in use until it expires.
+//
+// The name of the authenticated user is available to the next handler via
+// DigestAuthUserFromContext.
+//
+// Example:
+//
+//	auth := handlers.DigestAuthHandler(handlers.DigestAuthCredentials(map[string]string{
+//		"camera": os.Getenv("CAMERA_PASSWORD"),
+//	}), handlers.DigestAuthRealm("Cameras"))
+//	r.Handle("/snapshot", auth(SnapshotHandler))
+func DigestAuthHandler(secrets DigestAuthSecrets, opts ...DigestAuthOption) func(http.Handler) http.Handler {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("handlers: reading random nonce key: " + err.Error())
+	}
+	opaque := make([]byte, 16)
+	if _, err := rand.Read(opaque); err != nil {
+		panic("handlers: reading random opaque value: " + err.Error())
+	}
+	return func(h http.Handler) http.Handler {
+		d := &digestAuthHandler{
+			h:          h,
+			secrets:    secrets,
+			realm:      defaultBasicAuthRealm,
+			algorithms: []string{"SHA-256", "MD5"},
+			nonceTTL:   5 * time.Minute,
+			now:        time.Now,
+			key:        key,
+			opaque:     hex.EncodeToString(opaque),
+			counts:     make(map[string]uint64),
+		}
+		for _, option := range opts {
+			option(d)
+		}
+		return d
+	}
+}
+
+// DigestAuthRealm is a functional option that sets the realm advertised in
+// the authentication challenge.
+func DigestAuthRealm(realm string) DigestAuthOption {
+	return func(d *digestAuthHandler) {
+		d.realm = realm
+	}
+}
+
+// DigestAuthAlgorithms is a functional option that sets the supported
+// algorithms in order of preference. Valid algorithms are "SHA-256", "MD5",
+// and their session variants "SHA-256-sess" and "MD5-sess". MD5 only exists
+// for compatibility with legacy clients.
+func DigestAuthAlgorithms(algorithms ...string) DigestAuthOption {
+	return func(d *digestAuthHandler) {
+		d.algorithms = algorithms
+	}
+}
+
+// DigestAuthNonceTTL is a functional option that sets how long nonces are
+// valid.
+func DigestAuthNonceTTL(ttl time.Duration) DigestAuthOption {
+	return func(d *digestAuthHandler) {
+		d.nonceTTL = ttl
+	}
+}
+
+// DigestAuthOnFailure is a functional option that registers fn to be called
+// whenever a request is rejected. username is empty if the request carried no
+// credentials.
+func DigestAuthOnFailure(fn func(r *http.Request, username string)) DigestAuthOption {
+	return func(d *digestAuthHandler) {
+		d.onFailure = fn
+	}
+}
+
+// DigestAuthCredentials returns DigestAuthSecrets for the given username to
+// password pairs.
+func DigestAuthCredentials(credentials map[string]string) DigestAuthSecrets {
+	return func(username string) (string, bool) {
+		password, ok := credentials[username]
+		return password, ok
+	}
+}
+
+// DigestAuthUserFromContext returns the name of the user authenticated by
+// DigestAuthHandler, or the empty string if there is none.
+func DigestAuthUserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(digestAuthUserKey{}).(string)
+	return user
+}
+
+func (d *digestAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseDigestAuth(r.Header.Get("Authorization"))
+	if !ok {
+		d.challenge(w, r, "", false)
+		return
+	}
+	username := params["username"]
+	stale, ok := d.verify(r, params)
+	if !ok {
+		d.challenge(w, r, username, stale)
+		return
+	}
+
+	d.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), digestAuthUserKey{}, username)))
+}
+
+// verify checks the credentials in params. stale reports whether they were
+// valid but for an expired nonce.
+func (d *digestAuthHandler) verify(r *http.Request, params map[string]string) (stale, ok bool) {
+	newHash := digestHash(params["algorithm"])
+	if newHash == nil || !containsString(d.algorithms, digestAlgorithm(params["algorithm"])) {
+		return false, false
+	}
+	if params["qop"] != "auth" || params["cnonce"] == "" || params["userhash"] == "true" ||
+		params["realm"] != d.realm || params["opaque"] != d.opaque || params["uri"] != r.RequestURI {
+		return false, false
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil || len(params["nc"]) != 8 {
+		return false, false
+	}
+
+	nonce := params["nonce"]
+	issued, valid := d.checkNonce(nonce)
+	if !valid {
+		return false, false
+	}
+	expired := d.now().Sub(issued) >= d.nonceTTL
+
+	password, known := d.secrets(params["username"])
+	h := func(parts ...string) string {
+		hh := newHash()
+		hh.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	ha1 := h(params["username"], d.realm, password)
+	if strings.HasSuffix(params["algorithm"], "-sess") {
+		ha1 = h(ha1, nonce, params["cnonce"])
+	}
+	want := h(ha1, nonce, params["nc"], params["cnonce"], params["qop"], h(r.Method, params["uri"]))
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(params["response"]))) != 1 || !known {
+		return false, false
+	}
+	if expired {
+		return true, false
+	}
+	return false, d.useNonce(nonce, nc)
+}
+
+func (d *digestAuthHandler) challenge(w http.ResponseWriter, r *http.Request, username string, stale bool) {
+	if d.onFailure != nil {
+		d.onFailure(r, username)
+	}
+	nonce := d.newNonce()
+	for _, algorithm := range d.algorithms {
+		c := `Digest realm=` + quoteAuthParam(d.realm) + `, qop="auth", algorithm=` + algorithm +
+			`, nonce="` + nonce + `", opaque="` + d.opaque + `"`
+		if stale {
+			c += `, stale=true`
+		}
+		w.Header().Add(wwwAuthenticateHeader, c)
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// newNonce returns a nonce made of its issue time, random bytes keeping
+// nonces issued at the same time apart, and a MAC of both.
+func (d *digestAuthHandler) newNonce() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(d.now().UnixNano()))
+	_, _ = rand.Read(b[8:])
+	return hex.EncodeToString(b[:]) + hex.EncodeToString(d.nonceMAC(b[:]))
+}
+
+// checkNonce returns the issue time of nonce and whether it was issued by d.
+func (d *digestAuthHandler) checkNonce(nonce string) (time.Time, bool) {
+	b, err := hex.DecodeString(nonce)
+	if err != nil || len(b) != 16+16 {
+		return time.Time{}, false
+	}
+	if !hmac.Equal(b[16:], d.nonceMAC(b[:16])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), true
+}
+
+func (d *digestAuthHandler) nonceMAC(b []byte) []byte {
+	mac := hmac.New(sha256.New, d.key)
+	mac.Write(b)
+	return mac.Sum(nil)[:16]
+}
+
+// useNonce records the use of nonce with count nc, reporting false if the
+// count was not greater than that of any previous use, i.e. on replay.
+func (d *digestAuthHandler) useNonce(nonce string, nc uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Sub(d.lastSweep) >= d.nonceTTL {
+		d.lastSweep = now
+		for n := range d.counts {
+			if issued, _ := d.checkNonce(n); now.Sub(issued) >= d.nonceTTL {
+				delete(d.counts, n)
+			}
+		}
+	}
+
+	if nc <= d.counts[nonce] {
+		return false
+	}
+	d.counts[nonce] = nc
+	return true
+}
+
+// digestAlgorithm returns the canonical name of algorithm, which defaults to
+// MD5.
+func digestAlgorithm(algorithm string) string {
+	if algorithm == "" {
+		return "MD5"
+	}
+	return algorithm
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(digestAlgorithm(algorithm), "-sess") {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// parseDigestAuth parses the parameters of Digest credentials.
+func parseDigestAuth(header string) (map[string]string, bool) {
+	const prefix = "Digest "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	s := header[len(prefix):]
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			break
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, false
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, false
+			}
+			value, s = b.String(), s[i+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[key] = value
+	}
+	return params, params["username"] != ""
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestResponse answers challenge the way a client would.
+func digestResponse(t *testing.T, challenge, username, password, method, uri, nc string) string {
+	params, _ := parseDigestAuth(strings.Replace(challenge, "Digest ", "Digest username=x, ", 1))
+	newHash := func() hash.Hash { return md5.New() }
+	if params["algorithm"] == "SHA-256" {
+		newHash = sha256.New
+	}
+	h := func(parts ...string) string {
+		hh := newHash()
+		hh.Write([]byte(strings.Join(parts, ":")))
+		return fmt.Sprintf("%x", hh.Sum(nil))
+	}
+	const cnonce = "0a4f113b"
+	response := h(h(username, params["realm"], password), params["nonce"], nc, cnonce, "auth", h(method, uri))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, qop=auth, nc=%s, cnonce="%s", response="%s", opaque="%s"`,
+		username, params["realm"], params["nonce"], uri, params["algorithm"], nc, cnonce, response, params["opaque"])
+}
+
+func TestDigestAuthHandler(t *testing.T) {
+	var user string
+	h := DigestAuthHandler(DigestAuthCredentials(map[string]string{"Mufasa": "Circle of Life"}),
+		DigestAuthRealm("http-auth@example.org"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = DigestAuthUserFromContext(r.Context())
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/dir/index.html"))
+	challenges := rr.Header().Values("WWW-Authenticate")
+	if rr.Code != http.StatusUnauthorized || len(challenges) != 2 ||
+		!strings.Contains(challenges[0], "algorithm=SHA-256") || !strings.Contains(challenges[1], "algorithm=MD5") {
+		t.Fatalf("bad challenge: %v %q", rr.Code, challenges)
+	}
+
+	for i := range challenges {
+		// Every algorithm gets a fresh nonce, as they share nonce counts.
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "/dir/index.html"))
+		challenge := rr.Header().Values("WWW-Authenticate")[i]
+
+		user = ""
+		r := newRequest(http.MethodGet, "/dir/index.html")
+		r.RequestURI = "/dir/index.html"
+		r.Header.Set("Authorization", digestResponse(t, challenge, "Mufasa", "Circle of Life", r.Method, r.RequestURI, "00000001"))
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK || user != "Mufasa" {
+			t.Fatalf("valid credentials rejected: %v %q", rr.Code, rr.Body.String())
+		}
+
+		// Replaying the same nonce count is rejected.
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("replayed request accepted: %v", rr.Code)
+		}
+
+		r.Header.Set("Authorization", digestResponse(t, challenge, "Mufasa", "wrong", r.Method, r.RequestURI, "00000002"))
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != http.StatusUnauthorized {
+			t.Fatalf("bad password accepted: %v", rr.Code)
+		}
+	}
+}
+
+func TestDigestAuthStaleNonce(t *testing.T) {
+	now := time.Now()
+	h := DigestAuthHandler(DigestAuthCredentials(map[string]string{"user": "pass"}),
+		DigestAuthAlgorithms("SHA-256"),
+	)(okHandler).(*digestAuthHandler)
+	h.now = func() time.Time { return now }
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	challenge := rr.Header().Get("WWW-Authenticate")
+
+	now = now.Add(h.nonceTTL)
+	r := newRequest(http.MethodGet, "/")
+	r.RequestURI = "/"
+	r.Header.Set("Authorization", digestResponse(t, challenge, "user", "pass", r.Method, r.RequestURI, "00000001"))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Header().Get("WWW-Authenticate"), "stale=true") {
+		t.Fatalf("bad response to a stale nonce: %v %q", rr.Code, rr.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestParseDigestAuth(t *testing.T) {
+	params, ok := parseDigestAuth(`Digest username="Mufasa", realm="a \"quoted\", realm",nc=00000001 , qop=auth`)
+	if !ok || params["username"] != "Mufasa" || params["realm"] != `a "quoted", realm` || params["nc"] != "00000001" || params["qop"] != "auth" {
+		t.Fatalf("bad params: %v", params)
+	}
+	for _, s := range []string{"", "Basic dXNlcjpwYXNz", `Digest username="unterminated`, `Digest realm="x"`} {
+		if _, ok := parseDigestAuth(s); ok {
+			t.Errorf("%q: expected failure", s)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// ErrDraining is returned by Drainer.Check once the server is draining.
+var ErrDraining = errors.New("handlers: server is draining")
+
+// Drainer coordinates a graceful shutdown with load balancers. Once Drain is
+// called, readiness checks answer with a 503 so that the server is taken out
+// of rotation, and responses carry a "Connection: close" header so that
+// clients stop reusing connections to it, while requests keep being served.
+// Create one with NewDrainer and wrap handlers with its Handler method.
+//
+// Example:
+//
+//	drainer := handlers.NewDrainer(handlers.DrainReadinessPaths("/readyz"))
+//	srv := &http.Server{Addr: ":8000", Handler: drainer.Handler(r)}
+//	go srv.ListenAndServe()
+//
+//	<-sigterm
+//	drainer.Drain()
+//	time.Sleep(10 * time.Second) // let load balancers notice
+//	srv.Shutdown(ctx)
+type Drainer struct {
+	draining  atomic.Bool
+	readiness []string
+
+	mu       sync.Mutex
+	inFlight int
+	idle     chan struct{}
+}
+
+// DrainerOption provides a functional approach to configure a Drainer.
+type DrainerOption func(*Drainer)
+
+// NewDrainer returns a Drainer which is not draining yet.
+func NewDrainer(opts ...DrainerOption) *Drainer {
+	d := &Drainer{}
+	for _, option := range opts {
+		option(d)
+	}
+	return d
+}
+
+// DrainReadinessPaths is a functional option that sets the paths of readiness
+// checks, which are answered with a 503 once draining instead of being
+// passed to the wrapped handler. See also ReadinessHandler and Check.
+func DrainReadinessPaths(paths ...string) DrainerOption {
+	return func(d *Drainer) {
+		d.readiness = paths
+	}
+}
+
+// Drain starts draining. It is safe to call more than once.
+func (d *Drainer) Drain() {
+	d.draining.Store(true)
+}
+
+// Draining reports whether Drain has been called.
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// InFlight returns the number of requests being served by handlers returned
+// by Handler.
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inFlight
+}
+
+// Wait blocks until no requests are in flight or ctx is done. Note that
+// http.Server.Shutdown already waits for active connections to become idle;
+// Wait is meant for servers which cannot use it, such as those hijacking
+// connections, and for tests.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	if d.inFlight == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check returns ErrDraining once draining. It is a HealthCheck, to be added
+// to a HealthHandler as a readiness check.
+func (d *Drainer) Check(ctx context.Context) error {
+	if d.Draining() {
+		return ErrDraining
+	}
+	return nil
+}
+
+// ReadinessHandler returns a handler answering with a 200 "OK", or with a 503
+// "Service Unavailable" once draining.
+func (d *Drainer) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.Draining() {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(http.StatusText(http.StatusOK)))
+	})
+}
+
+// Handler returns a handler passing requests to h while tracking them, which
+// answers readiness checks with a 503 once draining. Responses whose header is
+// written once draining, including those of requests already in flight when
+// Drain is called, carry a "Connection: close" header.
+func (d *Drainer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.Draining() && containsString(d.readiness, r.URL.Path) {
+			d.ReadinessHandler().ServeHTTP(w, r)
+			return
+		}
+
+		var written bool
+		closeIfDraining := func(code int) {
+			if written || code < http.StatusOK {
+				return
+			}
+			written = true
+			if d.Draining() {
+				w.Header().Set("Connection", "close")
+			}
+		}
+
+		d.mu.Lock()
+		d.inFlight++
+		d.mu.Unlock()
+		defer func() {
+			d.mu.Lock()
+			d.inFlight--
+			if d.inFlight == 0 && d.idle != nil {
+				close(d.idle)
+				d.idle = nil
+			}
+			d.mu.Unlock()
+		}()
+
+		h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					closeIfDraining(code)
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					closeIfDraining(http.StatusOK)
+					return next(b)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					closeIfDraining(http.StatusOK)
+					return next(src)
+				}
+			},
+			Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					closeIfDraining(http.StatusOK)
+					next()
+				}
+			},
+		}), r)
+		// the header of empty responses is written once h returns
+		closeIfDraining(http.StatusOK)
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainer(t *testing.T) {
+	d := NewDrainer(DrainReadinessPaths("/readyz"))
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	h := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-release
+		}
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/readyz"))
+	if rr.Code != http.StatusOK || rr.Header().Get("Connection") != "" {
+		t.Fatalf("bad response before draining: %v %v", rr.Code, rr.Header())
+	}
+
+	done := make(chan struct{})
+	slow := httptest.NewRecorder()
+	go func() {
+		h.ServeHTTP(slow, newRequest(http.MethodGet, "/slow"))
+		close(done)
+	}()
+	<-entered
+
+	d.Drain()
+	if err := d.Check(context.Background()); err != ErrDraining {
+		t.Fatalf("bad check result: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/readyz"))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("bad readiness status while draining: %v", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if rr.Code != http.StatusOK || rr.Header().Get("Connection") != "close" {
+		t.Fatalf("bad response while draining: %v %v", rr.Code, rr.Header())
+	}
+
+	if d.InFlight() != 1 {
+		t.Fatalf("bad number of requests in flight: %d", d.InFlight())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait returned with a request in flight: %v", err)
+	}
+
+	close(release)
+	if err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if d.InFlight() != 0 {
+		t.Fatalf("bad number of requests in flight: %d", d.InFlight())
+	}
+	// The request in flight when draining started responds once draining.
+	if slow.Header().Get("Connection") != "close" {
+		t.Fatalf("bad response in flight while draining: %v", slow.Header())
+	}
+
+	// Empty responses too.
+	rr = httptest.NewRecorder()
+	d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if rr.Header().Get("Connection") != "close" {
+		t.Fatalf("bad empty response while draining: %v", rr.Header())
+	}
+}
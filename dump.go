@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type debugDumpHandler struct {
+	h         http.Handler
+	out       io.Writer
+	mu        *sync.Mutex
+	filter    func(*http.Request) bool
+	rate      float64
+	responses bool
+	maxBody   int
+	redact    []string
+}
+
+// DebugDumpOption provides a functional approach to configure the
+// DebugDumpHandler middleware.
+type DebugDumpOption func(*debugDumpHandler)
+
+// DebugDumpHandler is HTTP middleware that dumps requests, and optionally
+// their responses, in wire format to out using net/http/httputil, for
+// debugging at the wire level.
+//
+// To be safe in production, dumps can be limited to some requests with
+// DebugDumpFilter and DebugDumpSampleRate, bodies are truncated to 4 KiB by
+// default, and the values of the Authorization, Proxy-Authorization, Cookie
+// and Set-Cookie headers are redacted. Request bodies are dumped as read by
+// the next handler, once it returns.
+//
+// Example:
+//
+//	dump := handlers.DebugDumpHandler(os.Stderr,
+//		handlers.DebugDumpFilter(func(r *http.Request) bool { return r.Header.Get("X-Debug") != "" }),
+//		handlers.DebugDumpResponses(),
+//	)
+//	http.ListenAndServe(":8000", dump(r))
+func DebugDumpHandler(out io.Writer, opts ...DebugDumpOption) func(http.Handler) http.Handler {
+	mu := &sync.Mutex{}
+	return func(h http.Handler) http.Handler {
+		d := &debugDumpHandler{
+			h:       h,
+			out:     out,
+			mu:      mu,
+			rate:    1,
+			maxBody: 4 << 10,
+			redact:  []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+		}
+		for _, option := range opts {
+			option(d)
+		}
+		return d
+	}
+}
+
+// DebugDumpFilter is a functional option that limits dumps to requests for
+// which fn returns true, e.g. based on their path or a header.
+func DebugDumpFilter(fn func(*http.Request) bool) DebugDumpOption {
+	return func(d *debugDumpHandler) {
+		d.filter = fn
+	}
+}
+
+// DebugDumpSampleRate is a functional option that dumps only the given
+// fraction, between 0 and 1, of the requests passing the filter.
+func DebugDumpSampleRate(rate float64) DebugDumpOption {
+	return func(d *debugDumpHandler) {
+		d.rate = rate
+	}
+}
+
+// DebugDumpResponses is a functional option that dumps responses along with
+// their requests.
+func DebugDumpResponses() DebugDumpOption {
+	return func(d *debugDumpHandler) {
+		d.responses = true
+	}
+}
+
+// DebugDumpMaxBody is a functional option that sets the number of body bytes
+// dumped for each request and response. Zero omits bodies.
+func DebugDumpMaxBody(n int) DebugDumpOption {
+	return func(d *debugDumpHandler) {
+		d.maxBody = n
+	}
+}
+
+// DebugDumpRedactHeaders is a functional option that sets the headers whose
+// values are redacted, replacing the default list.
+func DebugDumpRedactHeaders(names ...string) DebugDumpOption {
+	return func(d *debugDumpHandler) {
+		d.redact = names
+	}
+}
+
+func (d *debugDumpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (d.filter != nil && !d.filter(r)) || (d.rate < 1 && rand.Float64() >= d.rate) {
+		d.h.ServeHTTP(w, r)
+		return
+	}
+
+	reqBody := &dumpCapture{limit: d.maxBody}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+	dump := r.Clone(r.Context())
+
+	var code int
+	respBody := &dumpCapture{limit: d.maxBody}
+	var respHeader http.Header
+	if d.responses {
+		writeHeader := func(c int) {
+			if code == 0 {
+				code = c
+				respHeader = w.Header().Clone()
+			}
+		}
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(c int) {
+					if c >= http.StatusOK || c == http.StatusSwitchingProtocols {
+						writeHeader(c)
+					}
+					next(c)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					writeHeader(http.StatusOK)
+					n, err := next(b)
+					_, _ = respBody.Write(b[:n])
+					return n, err
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					writeHeader(http.StatusOK)
+					return next(io.TeeReader(src, respBody))
+				}
+			},
+			Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					writeHeader(http.StatusOK)
+					next()
+				}
+			},
+		})
+	}
+
+	defer func() {
+		var buf bytes.Buffer
+		d.dumpRequest(&buf, dump, reqBody)
+		if d.responses {
+			if code == 0 {
+				code, respHeader = http.StatusOK, w.Header().Clone()
+			}
+			d.dumpResponse(&buf, r, code, respHeader, respBody)
+		}
+		d.mu.Lock()
+		_, _ = d.out.Write(buf.Bytes())
+		d.mu.Unlock()
+	}()
+
+	d.h.ServeHTTP(w, r)
+}
+
+func (d *debugDumpHandler) dumpRequest(buf *bytes.Buffer, r *http.Request, body *dumpCapture) {
+	r.Header = d.redactHeader(r.Header)
+	r.TransferEncoding = nil
+	r.Body = io.NopCloser(bytes.NewReader(body.buf.Bytes()))
+	b, err := httputil.DumpRequest(r, true)
+	if err != nil {
+		fmt.Fprintf(buf, "dumping request: %v\n", err)
+		return
+	}
+	buf.Write(b)
+	body.writeTruncation(buf)
+	buf.WriteString("\n")
+}
+
+func (d *debugDumpHandler) dumpResponse(buf *bytes.Buffer, r *http.Request, code int, header http.Header, body *dumpCapture) {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        d.redactHeader(header),
+		Body:          io.NopCloser(bytes.NewReader(body.buf.Bytes())),
+		ContentLength: int64(body.buf.Len()),
+		Request:       r,
+	}
+	b, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		fmt.Fprintf(buf, "dumping response: %v\n", err)
+		return
+	}
+	buf.Write(b)
+	body.writeTruncation(buf)
+	buf.WriteString("\n")
+}
+
+func (d *debugDumpHandler) redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range d.redact {
+		name = http.CanonicalHeaderKey(name)
+		for i := range h[name] {
+			h[name][i] = "[REDACTED]"
+		}
+	}
+	return h
+}
+
+// dumpCapture keeps the first limit bytes written to it and counts the rest.
+type dumpCapture struct {
+	limit   int
+	buf     bytes.Buffer
+	dropped int64
+}
+
+func (c *dumpCapture) Write(b []byte) (int, error) {
+	n := len(b)
+	if room := c.limit - c.buf.Len(); room < len(b) {
+		if room < 0 {
+			room = 0
+		}
+		c.dropped += int64(len(b) - room)
+		b = b[:room]
+	}
+	c.buf.Write(b)
+	return n, nil
+}
+
+func (c *dumpCapture) writeTruncation(buf *bytes.Buffer) {
+	if c.dropped > 0 {
+		if !strings.HasSuffix(buf.String(), "\n") {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "[%d more bytes]\n", c.dropped)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugDumpHandler(t *testing.T) {
+	var out bytes.Buffer
+	h := DebugDumpHandler(&out, DebugDumpResponses(), DebugDumpMaxBody(5))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "hello world" {
+			t.Errorf("bad body passed on: %q", body)
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("response body"))
+	}))
+
+	r, _ := http.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader("hello world"))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Trace", "abc")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusCreated || rr.Body.String() != "response body" {
+		t.Fatalf("bad response: %v %q", rr.Code, rr.Body.String())
+	}
+	dump := out.String()
+	for _, want := range []string{
+		"POST /upload HTTP/1.1\r\n",
+		"Authorization: [REDACTED]\r\n",
+		"X-Trace: abc\r\n",
+		"\r\n\r\nhello\n[6 more bytes]\n",
+		"HTTP/1.1 201 Created\r\n",
+		"Set-Cookie: [REDACTED]\r\n",
+		"\r\n\r\nrespo\n[8 more bytes]\n",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump does not contain %q:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "secret") {
+		t.Errorf("dump contains a secret:\n%s", dump)
+	}
+}
+
+func TestDebugDumpFilter(t *testing.T) {
+	var out bytes.Buffer
+	h := DebugDumpHandler(&out, DebugDumpFilter(func(r *http.Request) bool {
+		return r.URL.Path == "/debug"
+	}))(okHandler)
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	if out.Len() != 0 {
+		t.Fatalf("filtered request dumped:\n%s", out.String())
+	}
+
+	h = DebugDumpHandler(&out, DebugDumpSampleRate(0))(okHandler)
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/debug"))
+	if out.Len() != 0 {
+		t.Fatalf("unsampled request dumped:\n%s", out.String())
+	}
+
+	h = DebugDumpHandler(&out)(okHandler)
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/debug"))
+	if !strings.HasPrefix(out.String(), "GET /debug HTTP/1.1\r\n") || strings.Contains(out.String(), "HTTP/1.1 200") {
+		t.Fatalf("bad dump:\n%s", out.String())
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type earlyHintsKey struct{}
+
+// earlyHintsState tracks whether interim responses may still be sent.
+type earlyHintsState struct {
+	w       http.ResponseWriter
+	enabled bool
+	final   bool
+}
+
+type earlyHintsHandler struct {
+	h      http.Handler
+	routes []earlyHintsRoute
+	http11 bool
+}
+
+type earlyHintsRoute struct {
+	prefix string
+	links  []string
+}
+
+// EarlyHintsOption provides a functional approach to configure the
+// EarlyHintsHandler middleware.
+type EarlyHintsOption func(*earlyHintsHandler)
+
+// EarlyHintsHandler is HTTP middleware sending Link headers, such as preload
+// hints, in a 103 "Early Hints" interim response (RFC 8297) before the final
+// response, so that clients can start fetching subresources while the
+// response is being generated. The links are included in the final response
+// too.
+//
+// Links are configured per path prefix with EarlyHintsLinks, or declared by
+// handlers with EarlyHints. Interim responses are only sent over HTTP/2 and
+// later by default, as some HTTP/1.1 clients mishandle them.
+//
+// Example:
+//
+//	hints := handlers.EarlyHintsHandler(
+//		handlers.EarlyHintsLinks("/", "</app.css>; rel=preload; as=style"),
+//	)
+//	http.ListenAndServeTLS(":443", "cert.pem", "key.pem", hints(r))
+func EarlyHintsHandler(opts ...EarlyHintsOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		e := &earlyHintsHandler{h: h}
+		for _, option := range opts {
+			option(e)
+		}
+		return e
+	}
+}
+
+// EarlyHintsLinks is a functional option that sends the given Link header
+// values as early hints for request paths beginning with prefix.
+func EarlyHintsLinks(prefix string, links ...string) EarlyHintsOption {
+	return func(e *earlyHintsHandler) {
+		e.routes = append(e.routes, earlyHintsRoute{prefix: prefix, links: links})
+	}
+}
+
+// EarlyHintsHTTP11 is a functional option that sets whether early hints are
+// sent to HTTP/1.1 clients.
+func EarlyHintsHTTP11(enabled bool) EarlyHintsOption {
+	return func(e *earlyHintsHandler) {
+		e.http11 = enabled
+	}
+}
+
+// EarlyHints adds the given Link header values to the response to r and
+// sends them in a 103 "Early Hints" interim response. It must be called from
+// the goroutine serving r, before the final response header is written. It
+// does nothing if r was not passed through EarlyHintsHandler, and only adds
+// the Link headers if the protocol of r doesn't support early hints.
+//
+// Example:
+//
+//	func PageHandler(w http.ResponseWriter, r *http.Request) {
+//		handlers.EarlyHints(r, "</page.js>; rel=preload; as=script")
+//		page := renderSlowly(r)
+//		...
+//	}
+func EarlyHints(r *http.Request, links ...string) {
+	state, _ := r.Context().Value(earlyHintsKey{}).(*earlyHintsState)
+	if state == nil || state.final || len(links) == 0 {
+		return
+	}
+	header := state.w.Header()
+	if state.enabled {
+		// Interim responses carry the whole header, so swap in the new links
+		// alone, as the previous ones were already sent.
+		saved := header.Clone()
+		clearHeader(header)
+		header["Link"] = append([]string(nil), links...)
+		state.w.WriteHeader(http.StatusEarlyHints)
+		clearHeader(header)
+		for k, v := range saved {
+			header[k] = v
+		}
+	}
+	for _, link := range links {
+		header.Add("Link", link)
+	}
+}
+
+func clearHeader(h http.Header) {
+	for k := range h {
+		delete(h, k)
+	}
+}
+
+func (e *earlyHintsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := &earlyHintsState{
+		w:       w,
+		enabled: r.ProtoAtLeast(2, 0) || (e.http11 && r.ProtoAtLeast(1, 1)),
+	}
+	final := func() {
+		state.final = true
+	}
+	r = r.WithContext(context.WithValue(r.Context(), earlyHintsKey{}, state))
+
+	var links []string
+	for _, route := range e.routes {
+		if strings.HasPrefix(r.URL.Path, route.prefix) {
+			links = append(links, route.links...)
+		}
+	}
+	EarlyHints(r, links...)
+
+	e.h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+					final()
+				}
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				final()
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				final()
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				final()
+				next()
+			}
+		},
+	}), r)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestEarlyHintsHandler(t *testing.T) {
+	h := EarlyHintsHandler(
+		EarlyHintsHTTP11(true),
+		EarlyHintsLinks("/", "</app.css>; rel=preload; as=style"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Page", "1")
+		EarlyHints(r, "</page.js>; rel=preload; as=script")
+		_, _ = w.Write([]byte(ok))
+		// Too late for hints.
+		EarlyHints(r, "</late.js>; rel=preload; as=script")
+	}))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	var interim [][]string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				interim = append(interim, header["Link"])
+				if header.Get("X-Page") != "" {
+					t.Errorf("early hints carry the response header: %v", header)
+				}
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, s.URL+"/page", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// Each interim response carries the new links only.
+	if len(interim) != 2 || len(interim[0]) != 1 || len(interim[1]) != 1 ||
+		interim[0][0] != "</app.css>; rel=preload; as=style" || interim[1][0] != "</page.js>; rel=preload; as=script" {
+		t.Fatalf("bad early hints: %q", interim)
+	}
+	if links := res.Header.Values("Link"); len(links) != 2 || links[1] != "</page.js>; rel=preload; as=script" {
+		t.Fatalf("bad final Link headers: %q", links)
+	}
+	if res.Header.Get("X-Page") != "1" {
+		t.Fatalf("bad final header: %v", res.Header)
+	}
+}
+
+func TestEarlyHintsHTTP11Disabled(t *testing.T) {
+	h := EarlyHintsHandler(EarlyHintsLinks("/", "</app.css>; rel=preload"))(okHandler)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if rr.Code != http.StatusOK || rr.Header().Get("Link") != "</app.css>; rel=preload" {
+		t.Fatalf("bad response: %v %v", rr.Code, rr.Header())
+	}
+}
+
+func TestEarlyHintsLoggedStatus(t *testing.T) {
+	var buf bytes.Buffer
+	h := LoggingHandler(&buf, EarlyHintsHandler(
+		EarlyHintsHTTP11(true),
+		EarlyHintsLinks("/", "</app.css>; rel=preload; as=style"),
+	)(okHandler))
+	s := httptest.NewServer(h)
+
+	res, err := http.Get(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	s.Close()
+
+	if res.StatusCode != http.StatusOK || !strings.Contains(buf.String(), `"GET / HTTP/1.1" 200 `) {
+		t.Fatalf("bad logged status: %v %q", res.StatusCode, buf.String())
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type errorLoggingHandler struct {
+	writer  io.Writer
+	handler http.Handler
+}
+
+// ErrorLoggingHandler returns a http.Handler that wraps h and logs the errors
+// occurring while serving requests to out, in the style of the Apache error
+// log, separately from the access log:
+//
+//	[Thu May 26 03:30:45.000000 1983] [error] [client 192.168.100.5] GET /: panic: boom
+//
+// It logs panics at the error level, before propagating them to the
+// outer handlers, write errors at the warn level, once per request, and
+// requests canceled by the client or timed out at the info and warn levels.
+// The log entry is followed by the ID assigned to the request by
+// RequestIDHandler, if any.
+//
+// Example:
+//
+//	h := handlers.LoggingHandler(accessLog, handlers.ErrorLoggingHandler(errorLog, r))
+func ErrorLoggingHandler(out io.Writer, h http.Handler) http.Handler {
+	return &errorLoggingHandler{writer: out, handler: h}
+}
+
+func (h *errorLoggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	writeFailed := false
+	w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+		Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				n, err := write(b)
+				if err != nil && !writeFailed {
+					writeFailed = true
+					h.log(req, "warn", "write error: "+err.Error())
+				}
+				return n, err
+			}
+		},
+	})
+
+	defer func() {
+		if err := recover(); err != nil {
+			// Aborting a request with http.ErrAbortHandler is deliberate.
+			if err != http.ErrAbortHandler {
+				h.log(req, "error", fmt.Sprintf("panic: %v", err))
+			}
+			panic(err)
+		}
+	}()
+
+	h.handler.ServeHTTP(w, req)
+
+	switch err := req.Context().Err(); {
+	case errors.Is(err, context.DeadlineExceeded):
+		h.log(req, "warn", "request timed out: "+err.Error())
+	case err != nil:
+		h.log(req, "info", "request canceled: "+err.Error())
+	}
+}
+
+// log writes an error log entry about req with the given level.
+func (h *errorLoggingHandler) log(req *http.Request, level, msg string) {
+	buf := make([]byte, 0, 128+len(msg))
+	buf = append(buf, '[')
+	buf = time.Now().AppendFormat(buf, "Mon Jan 02 15:04:05.000000 2006")
+	buf = append(buf, "] ["...)
+	buf = append(buf, level...)
+	buf = append(buf, "] [client "...)
+	buf = append(buf, req.RemoteAddr...)
+	buf = append(buf, "] "...)
+	buf = append(buf, req.Method...)
+	buf = append(buf, ' ')
+	buf = appendQuoted(buf, req.URL.RequestURI())
+	buf = append(buf, ": "...)
+	buf = appendQuoted(buf, msg)
+	if id := RequestIDFromContext(req.Context()); id != "" {
+		buf = append(buf, " [request_id "...)
+		buf = appendQuoted(buf, id)
+		buf = append(buf, ']')
+	}
+	buf = append(buf, '\n')
+	_, _ = h.writer.Write(buf)
+}
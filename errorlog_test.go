@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingWriter is a ResponseWriter whose writes fail.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestErrorLoggingHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h := ErrorLoggingHandler(&buf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/abort":
+			panic(http.ErrAbortHandler)
+		case "/write":
+			_, _ = io.WriteString(w, "a")
+			_, _ = io.WriteString(w, "b")
+		}
+	}))
+
+	serve := func(w http.ResponseWriter, req *http.Request) {
+		defer func() { _ = recover() }()
+		req.RemoteAddr = "192.168.100.5"
+		h.ServeHTTP(w, req)
+	}
+	serve(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/panic"))
+	serve(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/abort"))
+	serve(failingWriter{httptest.NewRecorder()}, newRequest(http.MethodGet, "http://example.com/write"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	serve(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/").WithContext(ctx))
+	serve(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/ok"))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	wants := []string{
+		"] [error] [client 192.168.100.5] GET /panic: panic: boom",
+		"] [warn] [client 192.168.100.5] GET /write: write error: broken pipe",
+		"] [info] [client 192.168.100.5] GET /: request canceled: context canceled",
+	}
+	if len(lines) != len(wants) {
+		t.Fatalf("got %d log lines, want %d:\n%s", len(lines), len(wants), buf.String())
+	}
+	for i, want := range wants {
+		if !strings.HasPrefix(lines[i], "[") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("got log %q, want suffix %q", lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+)
+
+const (
+	etagHeader          = "ETag"
+	ifNoneMatchHeader   = "If-None-Match"
+	defaultETagMaxBytes = 1 << 20
+)
+
+type etagHandler struct {
+	h            http.Handler
+	weak         bool
+	maxBytes     int
+	contentTypes []string
+}
+
+// ETagOption provides a functional approach to configure the ETagHandler
+// middleware.
+type ETagOption func(*etagHandler)
+
+// ETagHandler is HTTP middleware that adds an ETag header to successful
+// responses to GET requests and answers conditional requests carrying a
+// matching If-None-Match header with a 304 "Not Modified", without sending
+// the body again. The ETag is derived from a hash of the response body.
+//
+// To compute the hash, responses are buffered in memory. Responses larger than
+// the limit set by ETagMaxBytes (1 MiB by default), responses which are flushed
+// by the handler and responses whose Content-Type is not accepted by
+// ETagContentTypes are streamed to the client unchanged instead.
+//
+// If the next handler sets an ETag header itself, that value is used as is.
+func ETagHandler(opts ...ETagOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		e := &etagHandler{h: h, maxBytes: defaultETagMaxBytes}
+		for _, option := range opts {
+			option(e)
+		}
+		return e
+	}
+}
+
+// ETagWeak is a functional option that emits weak ETags (W/"...") rather than
+// strong ones. Weak ETags are appropriate when the handler produces
+// semantically equivalent but not byte-identical bodies, e.g. when combined
+// with CompressHandler.
+func ETagWeak() ETagOption {
+	return func(e *etagHandler) {
+		e.weak = true
+	}
+}
+
+// ETagMaxBytes is a functional option that limits the size of responses
+// buffered to compute an ETag.
+func ETagMaxBytes(n int) ETagOption {
+	return func(e *etagHandler) {
+		e.maxBytes = n
+	}
+}
+
+// ETagContentTypes is a functional option that restricts ETag generation to
+// responses whose Content-Type starts with one of the given prefixes, such as
+// "text/" or "application/json". All content types are accepted by default.
+func ETagContentTypes(prefixes ...string) ETagOption {
+	return func(e *etagHandler) {
+		e.contentTypes = prefixes
+	}
+}
+
+func (e *etagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		e.h.ServeHTTP(w, r)
+		return
+	}
+
+	ew := &etagWriter{w: w, e: e}
+	e.h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return ew.Write
+		},
+		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return ew.WriteHeader
+		},
+		Flush: func(httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return ew.Flush
+		},
+		ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return ew.ReadFrom
+		},
+	}), r)
+	ew.finish(r)
+}
+
+// etagWriter buffers a response until it is known whether an ETag can be
+// computed for it.
+type etagWriter struct {
+	w           http.ResponseWriter
+	e           *etagHandler
+	code        int
+	decided     bool
+	passthrough bool
+	buf         bytes.Buffer
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if code < http.StatusOK && code != http.StatusSwitchingProtocols {
+		// Interim responses precede the final one, so are sent as they come.
+		if ew.code == 0 {
+			ew.w.WriteHeader(code)
+		}
+		return
+	}
+	if ew.code != 0 {
+		return
+	}
+	ew.code = code
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+	if !ew.decided {
+		ew.decide(b)
+	}
+	if ew.passthrough {
+		return ew.w.Write(b)
+	}
+
+	if ew.buf.Len()+len(b) > ew.e.maxBytes {
+		ew.startPassthrough()
+		return ew.w.Write(b)
+	}
+	return ew.buf.Write(b)
+}
+
+func (ew *etagWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(writerOnly{ew}, r)
+}
+
+func (ew *etagWriter) Flush() {
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+	ew.decided = true
+	ew.startPassthrough()
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// decide determines whether the response is eligible for an ETag, given its
+// first chunk of body.
+func (ew *etagWriter) decide(b []byte) {
+	ew.decided = true
+
+	h := ew.w.Header()
+	if ew.code != http.StatusOK {
+		ew.startPassthrough()
+		return
+	}
+
+	if len(ew.e.contentTypes) > 0 {
+		ct := h.Get("Content-Type")
+		if ct == "" && len(b) > 0 {
+			ct = http.DetectContentType(b)
+			h.Set("Content-Type", ct)
+		}
+		if !hasAnyPrefix(ct, ew.e.contentTypes) {
+			ew.startPassthrough()
+		}
+	}
+}
+
+// startPassthrough writes the status and any buffered body to the client and
+// lets all further writes through unchanged.
+func (ew *etagWriter) startPassthrough() {
+	if ew.passthrough {
+		return
+	}
+	ew.passthrough = true
+
+	if ew.code != 0 {
+		ew.w.WriteHeader(ew.code)
+	}
+	if ew.buf.Len() > 0 {
+		_, _ = ew.w.Write(ew.buf.Bytes())
+		ew.buf.Reset()
+	}
+}
+
+// finish completes a response once the handler has returned.
+func (ew *etagWriter) finish(r *http.Request) {
+	if ew.passthrough {
+		return
+	}
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+	if !ew.decided {
+		ew.decide(nil)
+		if ew.passthrough {
+			return
+		}
+	}
+
+	h := ew.w.Header()
+	etag := h.Get(etagHeader)
+	if etag == "" {
+		sum := sha256.Sum256(ew.buf.Bytes())
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		if ew.e.weak {
+			etag = "W/" + etag
+		}
+		h.Set(etagHeader, etag)
+	}
+
+	if etagMatch(r.Header.Get(ifNoneMatchHeader), etag) {
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		ew.w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(ew.buf.Len()))
+	ew.w.WriteHeader(ew.code)
+	_, _ = ew.w.Write(ew.buf.Bytes())
+}
+
+// etagMatch reports whether the If-None-Match header value header matches
+// etag, using the weak comparison function of RFC 7232, section 2.3.2.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// writerOnly hides any io.ReaderFrom implementation of the wrapped writer, so
+// that io.Copy goes through its Write method.
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagHandler(t *testing.T) {
+	h := ETagHandler()(okHandler)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	etag := rr.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Fatalf("bad ETag: got %q", etag)
+	}
+	if rr.Body.String() != ok {
+		t.Fatalf("bad body: got %q want %q", rr.Body.String(), ok)
+	}
+	if got := rr.Header().Get("Content-Length"); got != "3" {
+		t.Fatalf("bad Content-Length: got %q want %q", got, "3")
+	}
+
+	// Conditional request with a matching tag.
+	r := newRequest(http.MethodGet, "/")
+	r.Header.Set("If-None-Match", `"other", W/`+etag)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+	if rr.Body.Len() != 0 {
+		t.Fatalf("unexpected body on 304: %q", rr.Body.String())
+	}
+
+	// Conditional request with a stale tag.
+	r = newRequest(http.MethodGet, "/")
+	r.Header.Set("If-None-Match", `"other"`)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestETagHandlerWeak(t *testing.T) {
+	rr := httptest.NewRecorder()
+	ETagHandler(ETagWeak())(okHandler).ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	if etag := rr.Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("bad ETag: got %q, want a weak tag", etag)
+	}
+}
+
+func TestETagHandlerSkips(t *testing.T) {
+	large := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 64)))
+		_, _ = w.Write([]byte(strings.Repeat("b", 64)))
+	})
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	image := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png"))
+	})
+	flushing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("b"))
+	})
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		code    int
+		body    string
+	}{
+		{"too large", large, http.MethodGet, http.StatusOK, strings.Repeat("a", 64) + strings.Repeat("b", 64)},
+		{"not ok", notFound, http.MethodGet, http.StatusNotFound, "not found\n"},
+		{"content type", image, http.MethodGet, http.StatusOK, "png"},
+		{"flushed", flushing, http.MethodGet, http.StatusOK, "ab"},
+		{"post", okHandler, http.MethodPost, http.StatusOK, ok},
+	}
+
+	for _, tt := range tests {
+		h := ETagHandler(ETagMaxBytes(100), ETagContentTypes("text/"))(tt.handler)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(tt.method, "/"))
+
+		if rr.Code != tt.code {
+			t.Errorf("%s: bad status: got %v want %v", tt.name, rr.Code, tt.code)
+		}
+		if rr.Body.String() != tt.body {
+			t.Errorf("%s: bad body: got %q want %q", tt.name, rr.Body.String(), tt.body)
+		}
+		if etag := rr.Header().Get("ETag"); etag != "" {
+			t.Errorf("%s: unexpected ETag %q", tt.name, etag)
+		}
+	}
+}
+
+func TestETagHandlerExistingTag(t *testing.T) {
+	h := ETagHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	r := newRequest(http.MethodGet, "/")
+	r.Header.Set("If-None-Match", `"v1"`)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+	if got := rr.Header().Get("ETag"); got != `"v1"` {
+		t.Fatalf("bad ETag: got %q want %q", got, `"v1"`)
+	}
+}
@@ -6,11 +6,18 @@ package handlers
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
 )
 
 // MethodHandler is an http.Handler that dispatches to a handler whose key in the
@@ -43,41 +50,304 @@ func (h MethodHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// responseLogger is wrapper of http.ResponseWriter that keeps track of its HTTP
-// status code and body size.
-type responseLogger struct {
-	w      http.ResponseWriter
-	status int
-	size   int
+// ResponseRecorder keeps track of the status code and body size of a
+// response, for middleware which logs or measures responses. Create one with
+// NewResponseRecorder, which returns the ResponseWriter to pass down the
+// chain.
+type ResponseRecorder struct {
+	w           http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+
+	// captureHeader makes the recorder keep a copy of the response header as
+	// it was sent.
+	captureHeader bool
+	header        http.Header
+
+	// trackHijack makes the recorder wrap hijacked connections in conn, to
+	// count the bytes transferred until they are closed.
+	trackHijack bool
+	conn        *loggedConn
+
+	// body captures the start of the response body, if set.
+	body *dumpCapture
+}
+
+// NewResponseRecorder returns a ResponseRecorder for w, and the
+// ResponseWriter recording the response written to it. The returned
+// ResponseWriter implements the same optional interfaces as w, among
+// http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom.
+//
+// Example:
+//
+//	func timing(h http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//			start := time.Now()
+//			rec, w := handlers.NewResponseRecorder(w)
+//			h.ServeHTTP(w, r)
+//			log.Printf("%s %d %d %s", r.URL, rec.Status(), rec.Size(), time.Since(start))
+//		})
+//	}
+func NewResponseRecorder(w http.ResponseWriter) (*ResponseRecorder, http.ResponseWriter) {
+	l := &ResponseRecorder{w: w, status: http.StatusOK}
+	return l, httpsnoop.Wrap(w, httpsnoop.Hooks{
+		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return l.write
+		},
+		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return l.writeHeader
+		},
+		Flush: func(flush httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				l.sendHeader()
+				flush()
+			}
+		},
+		ReadFrom: func(readFrom httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				l.sendHeader()
+				if l.body != nil {
+					src = io.TeeReader(src, l.body)
+				}
+				n, err := readFrom(src)
+				l.size += int(n)
+				return n, err
+			}
+		},
+		Hijack: func(httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return l.hijack
+		},
+	})
 }
 
-func (l *responseLogger) Write(b []byte) (int, error) {
+// sendHeader records that the response header is being sent.
+func (l *ResponseRecorder) sendHeader() {
+	if l.captureHeader && l.header == nil {
+		l.header = l.w.Header().Clone()
+	}
+	l.wroteHeader = true
+}
+
+func (l *ResponseRecorder) write(b []byte) (int, error) {
+	l.sendHeader()
 	size, err := l.w.Write(b)
 	l.size += size
+	if l.body != nil {
+		_, _ = l.body.Write(b[:size])
+	}
 	return size, err
 }
 
-func (l *responseLogger) WriteHeader(s int) {
+func (l *ResponseRecorder) writeHeader(s int) {
+	// Informational responses are followed by the final one.
+	if s >= 200 || s == http.StatusSwitchingProtocols {
+		l.sendHeader()
+		l.status = s
+	}
 	l.w.WriteHeader(s)
-	l.status = s
 }
 
-func (l *responseLogger) Status() int {
+// sentHeader returns the response header as it was sent or, if the response
+// hasn't been written, as it is now. It is only available when captureHeader
+// is set.
+func (l *ResponseRecorder) sentHeader() http.Header {
+	if l.header == nil && l.captureHeader {
+		return l.w.Header().Clone()
+	}
+	return l.header
+}
+
+// trailer returns the response trailers set by the handler, declared in the
+// Trailer header or set with the http.TrailerPrefix, or nil if there are none.
+// It must be called after the handler has returned.
+func (l *ResponseRecorder) trailer() http.Header {
+	var trailer http.Header
+	header := l.w.Header()
+	add := func(key string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		if trailer == nil {
+			trailer = make(http.Header)
+		}
+		trailer[key] = append(trailer[key], values...)
+	}
+	for _, v := range header.Values("Trailer") {
+		for _, key := range strings.Split(v, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			add(key, header[key])
+		}
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			add(http.CanonicalHeaderKey(strings.TrimPrefix(key, http.TrailerPrefix)), values)
+		}
+	}
+	return trailer
+}
+
+// Status returns the status code of the response, which is 200 until another
+// one is written, or 101 if the connection was hijacked before that.
+func (l *ResponseRecorder) Status() int {
 	return l.status
 }
 
-func (l *responseLogger) Size() int {
+// Size returns the number of response body bytes written.
+func (l *ResponseRecorder) Size() int {
 	return l.size
 }
 
-func (l *responseLogger) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+// Written reports whether the response header has been written, explicitly
+// or by writing the body.
+func (l *ResponseRecorder) Written() bool {
+	return l.wroteHeader
+}
+
+func (l *ResponseRecorder) hijack() (net.Conn, *bufio.ReadWriter, error) {
 	conn, rw, err := l.w.(http.Hijacker).Hijack()
-	if err == nil && l.status == 0 {
+	if err != nil {
+		return conn, rw, err
+	}
+	if !l.wroteHeader {
 		// The status will be StatusSwitchingProtocols if there was no error and
 		// WriteHeader has not been called yet
 		l.status = http.StatusSwitchingProtocols
 	}
-	return conn, rw, err
+	if l.trackHijack {
+		l.conn = newLoggedConn(conn, rw)
+		return l.conn, l.conn.rw, nil
+	}
+	return conn, rw, nil
+}
+
+// requestRecord collects values contributed by handlers further down the
+// chain, such as the request ID, for the benefit of outer handlers which log
+// the request once it has been served. Handlers down the chain may run on
+// other goroutines (see TimeoutHandler), hence the mutex.
+type requestRecord struct {
+	mu    sync.Mutex
+	reqID string
+	vhost string
+	route string
+	comp  *compressionStats
+	err   error
+	cache string
+}
+
+type requestRecordKey struct{}
+
+// withRequestRecord returns r with a requestRecord attached to its context,
+// re-using the record of an outer handler if there is one.
+func withRequestRecord(r *http.Request) (*http.Request, *requestRecord) {
+	if rec := requestRecordFromContext(r.Context()); rec != nil {
+		return r, rec
+	}
+	rec := &requestRecord{}
+	return r.WithContext(context.WithValue(r.Context(), requestRecordKey{}, rec)), rec
+}
+
+// requestRecordFromContext returns the requestRecord attached to ctx, if any.
+func requestRecordFromContext(ctx context.Context) *requestRecord {
+	rec, _ := ctx.Value(requestRecordKey{}).(*requestRecord)
+	return rec
+}
+
+func (rec *requestRecord) setRequestID(id string) {
+	rec.mu.Lock()
+	rec.reqID = id
+	rec.mu.Unlock()
+}
+
+// requestID returns the request ID recorded by a RequestIDHandler wrapped by
+// the caller or, failing that, the one found in the context of r, which is set
+// when the RequestIDHandler wraps the caller instead.
+func (rec *requestRecord) requestID(r *http.Request) string {
+	rec.mu.Lock()
+	id := rec.reqID
+	rec.mu.Unlock()
+
+	if id == "" {
+		id = RequestIDFromContext(r.Context())
+	}
+	return id
+}
+
+func (rec *requestRecord) setVirtualHost(pattern string) {
+	rec.mu.Lock()
+	rec.vhost = pattern
+	rec.mu.Unlock()
+}
+
+func (rec *requestRecord) setCompressionStats(stats *compressionStats) {
+	rec.mu.Lock()
+	rec.comp = stats
+	rec.mu.Unlock()
+}
+
+// compressionStats returns the stats of the compression applied to the
+// response by a CompressHandler, if any.
+func (rec *requestRecord) compressionStats() *compressionStats {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.comp
+}
+
+func (rec *requestRecord) setError(err error) {
+	rec.mu.Lock()
+	rec.err = err
+	rec.mu.Unlock()
+}
+
+func (rec *requestRecord) error() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+func (rec *requestRecord) setCacheStatus(status string) {
+	rec.mu.Lock()
+	rec.cache = status
+	rec.mu.Unlock()
+}
+
+func (rec *requestRecord) cacheStatus() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.cache
+}
+
+func (rec *requestRecord) setRoute(pattern string) {
+	rec.mu.Lock()
+	rec.route = pattern
+	rec.mu.Unlock()
+}
+
+func (rec *requestRecord) routePattern() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.route
+}
+
+// virtualHost returns the host pattern matched by a HostSwitch wrapped by the
+// caller or, failing that, the host of r without its port.
+func (rec *requestRecord) virtualHost(r *http.Request) string {
+	rec.mu.Lock()
+	vhost := rec.vhost
+	rec.mu.Unlock()
+
+	if vhost == "" {
+		vhost = hostWithoutPort(r.Host)
+	}
+	return vhost
+}
+
+// hostWithoutPort returns the host part of hostport, which may lack a port.
+func hostWithoutPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
 }
 
 // isContentType validates the Content-Type header matches the supplied
@@ -90,6 +360,61 @@ func isContentType(h http.Header, contentType string) bool {
 	return ct == contentType
 }
 
+// setRetryAfter sets the Retry-After header to d, rounded up to whole seconds.
+// Non-positive durations leave the header untouched.
+func setRetryAfter(h http.Header, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	secs := int64((d + time.Second - 1) / time.Second)
+	h.Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
+// AddVary adds value, a header name or a comma-separated list of them, to the
+// Vary header of h, skipping the names which are already listed, whatever
+// their case, so that middleware varying responses on the same request header
+// don't repeat it. Nothing is added to a "Vary: *" header, which already
+// covers every request header, and adding "*" replaces the header.
+func AddVary(h http.Header, value string) {
+	vary := varyHeaders(h)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if name == "*" {
+			h.Set("Vary", "*")
+			return
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		listed := false
+		for _, v := range vary {
+			if v == name || v == "*" {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			h.Add("Vary", name)
+			vary = append(vary, name)
+		}
+	}
+}
+
+// headerHasToken reports whether the comma-separated lists of the name header
+// of h contain token, whatever its case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, line := range h.Values(name) {
+		for _, v := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // ContentTypeHandler wraps and returns a http.Handler, validating the request
 // content type is compatible with the contentTypes list. It writes a HTTP 415
 // error if that fails.
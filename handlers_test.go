@@ -157,3 +157,67 @@ func TestHTTPMethodOverride(t *testing.T) {
 		}
 	}
 }
+
+func TestResponseRecorder(t *testing.T) {
+	rec, w := NewResponseRecorder(httptest.NewRecorder())
+	if rec.Written() || rec.Status() != http.StatusOK {
+		t.Fatalf("got written %t, status %d before writing", rec.Written(), rec.Status())
+	}
+	if _, ok := w.(http.Flusher); !ok {
+		t.Fatal("ResponseWriter lost http.Flusher interface")
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		t.Fatal("ResponseWriter shouldn't implement http.Hijacker")
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, _ = io.WriteString(w, ok)
+	_, _ = io.Copy(w, strings.NewReader(ok))
+	if !rec.Written() || rec.Status() != http.StatusCreated || rec.Size() != 2*len(ok) {
+		t.Fatalf("got written %t, status %d, size %d, want true, %d, %d", rec.Written(), rec.Status(), rec.Size(), http.StatusCreated, 2*len(ok))
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		vary  []string
+		value string
+		want  []string
+	}{
+		{nil, "Origin", []string{"Origin"}},
+		{[]string{"Origin"}, "origin", []string{"Origin"}},
+		{[]string{"Accept-Encoding, Origin"}, "Origin, Accept-Language", []string{"Accept-Encoding, Origin", "Accept-Language"}},
+		{[]string{"Origin"}, " cookie ,, cookie", []string{"Origin", "Cookie"}},
+		{[]string{"*"}, "Origin", []string{"*"}},
+		{[]string{"Origin"}, "*", []string{"*"}},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.vary != nil {
+			h["Vary"] = append([]string(nil), tt.vary...)
+		}
+		AddVary(h, tt.value)
+		if got := h.Values("Vary"); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("AddVary(%q, %q): got %q, want %q", tt.vary, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAddVaryMiddleware(t *testing.T) {
+	h := CORS(AllowedOrigins([]string{"http://a.example.com", "http://b.example.com"}))(
+		CompressHandler(CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			AddVary(w.Header(), "Origin")
+			_, _ = io.WriteString(w, "ok")
+		}))))
+
+	r := httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+	r.Header.Set("Origin", "http://a.example.com")
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	want := []string{"Origin", "Accept-Encoding"}
+	if got := w.Header().Values("Vary"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got Vary %q, want %q", got, want)
+	}
+}
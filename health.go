@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckTimeout = 5 * time.Second
+	healthStatusOK            = "ok"
+	healthStatusError         = "error"
+)
+
+// HealthCheck is a named component check registered with a HealthHandler. It
+// returns a non-nil error if the component is unhealthy, and should honour the
+// cancellation of ctx.
+type HealthCheck func(ctx context.Context) error
+
+type healthCheck struct {
+	name     string
+	fn       HealthCheck
+	timeout  time.Duration
+	liveness bool
+
+	mu       sync.Mutex
+	checked  time.Time
+	err      error
+	duration time.Duration
+}
+
+// HealthHandler exposes liveness and readiness endpoints backed by a set of
+// registered component checks, reporting the status of each component as
+// JSON. Create one with NewHealthHandler, register checks with AddCheck and
+// mount the handlers returned by Live and Ready.
+//
+// The liveness endpoint runs only the checks registered with
+// HealthCheckLiveness, which should be limited to conditions requiring a
+// restart; the readiness endpoint runs all checks. Checks run concurrently,
+// each with its own timeout.
+type HealthHandler struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	mu     sync.RWMutex
+	checks []*healthCheck
+}
+
+// HealthOption provides a functional approach to configure a HealthHandler.
+type HealthOption func(*HealthHandler)
+
+// HealthCheckOption provides a functional approach to configure a single
+// check registered with a HealthHandler.
+type HealthCheckOption func(*healthCheck)
+
+// HealthReport is the JSON document served by the endpoints of a
+// HealthHandler.
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+// HealthCheckResult is the outcome of a single check in a HealthReport.
+type HealthCheckResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// NewHealthHandler returns a HealthHandler without any checks.
+//
+// Example:
+//
+//	health := handlers.NewHealthHandler(handlers.HealthCacheTTL(2 * time.Second))
+//	health.AddCheck("database", db.PingContext)
+//	health.AddCheck("deadlock", detector.Check, handlers.HealthCheckLiveness())
+//
+//	http.Handle("/livez", health.Live())
+//	http.Handle("/readyz", health.Ready())
+func NewHealthHandler(opts ...HealthOption) *HealthHandler {
+	hh := &HealthHandler{timeout: defaultHealthCheckTimeout}
+	for _, option := range opts {
+		option(hh)
+	}
+	return hh
+}
+
+// HealthTimeout is a functional option that sets the default timeout of
+// checks. It defaults to five seconds.
+func HealthTimeout(d time.Duration) HealthOption {
+	return func(hh *HealthHandler) {
+		hh.timeout = d
+	}
+}
+
+// HealthCacheTTL is a functional option that caches the result of each check
+// for d, so that frequent probes don't overload the checked components.
+// Results are not cached by default, nor are those of the checks interrupted
+// by the caller, e.g. a probe disconnecting.
+func HealthCacheTTL(d time.Duration) HealthOption {
+	return func(hh *HealthHandler) {
+		hh.cacheTTL = d
+	}
+}
+
+// HealthCheckTimeout is a functional option that overrides the timeout of a
+// single check.
+func HealthCheckTimeout(d time.Duration) HealthCheckOption {
+	return func(c *healthCheck) {
+		c.timeout = d
+	}
+}
+
+// HealthCheckLiveness is a functional option that includes a check in the
+// liveness endpoint, as well as in the readiness endpoint.
+func HealthCheckLiveness() HealthCheckOption {
+	return func(c *healthCheck) {
+		c.liveness = true
+	}
+}
+
+// AddCheck registers a named check. Registering a check under an existing name
+// replaces it.
+func (hh *HealthHandler) AddCheck(name string, fn HealthCheck, opts ...HealthCheckOption) {
+	c := &healthCheck{name: name, fn: fn, timeout: hh.timeout}
+	for _, option := range opts {
+		option(c)
+	}
+
+	hh.mu.Lock()
+	defer hh.mu.Unlock()
+
+	for i, existing := range hh.checks {
+		if existing.name == name {
+			hh.checks[i] = c
+			return
+		}
+	}
+	hh.checks = append(hh.checks, c)
+}
+
+// Live returns the handler serving the liveness endpoint.
+func (hh *HealthHandler) Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hh.serve(w, r, true)
+	})
+}
+
+// Ready returns the handler serving the readiness endpoint.
+func (hh *HealthHandler) Ready() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hh.serve(w, r, false)
+	})
+}
+
+// Check runs the checks, or only the liveness checks if liveness is true, and
+// returns the resulting report.
+func (hh *HealthHandler) Check(ctx context.Context, liveness bool) HealthReport {
+	hh.mu.RLock()
+	var checks []*healthCheck
+	for _, c := range hh.checks {
+		if c.liveness || !liveness {
+			checks = append(checks, c)
+		}
+	}
+	hh.mu.RUnlock()
+
+	report := HealthReport{Status: healthStatusOK}
+	if len(checks) == 0 {
+		return report
+	}
+
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = c.run(ctx, hh.cacheTTL)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report.Checks = make(map[string]HealthCheckResult, len(checks))
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != healthStatusOK {
+			report.Status = healthStatusError
+		}
+	}
+	return report
+}
+
+func (hh *HealthHandler) serve(w http.ResponseWriter, r *http.Request, liveness bool) {
+	report := hh.Check(r.Context(), liveness)
+
+	code := http.StatusOK
+	if report.Status != healthStatusOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		_ = json.NewEncoder(w).Encode(report)
+	}
+}
+
+// run runs the check, or returns its cached result if it is younger than ttl.
+// The failures caused by ctx being done aren't cached, since they don't
+// reflect the health of the checked component.
+func (c *healthCheck) run(ctx context.Context, ttl time.Duration) HealthCheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl <= 0 || c.checked.IsZero() || time.Since(c.checked) >= ttl {
+		checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		start := time.Now()
+		err := runHealthCheck(checkCtx, c.fn)
+		cancel()
+
+		if err != nil && ctx.Err() != nil {
+			return healthCheckResult(start, time.Since(start), err)
+		}
+		c.checked = start
+		c.duration = time.Since(start)
+		c.err = err
+	}
+
+	return healthCheckResult(c.checked, c.duration, c.err)
+}
+
+func healthCheckResult(checked time.Time, d time.Duration, err error) HealthCheckResult {
+	res := HealthCheckResult{
+		Status:    healthStatusOK,
+		Duration:  d.String(),
+		CheckedAt: checked.UTC(),
+	}
+	if err != nil {
+		res.Status = healthStatusError
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// runHealthCheck runs fn, giving up once ctx is done even if fn doesn't honour
+// the cancellation.
+func runHealthCheck(ctx context.Context, fn HealthCheck) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- errors.New("health check panicked")
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	hh := NewHealthHandler()
+	hh.AddCheck("database", func(ctx context.Context) error { return nil })
+	hh.AddCheck("cache", func(ctx context.Context) error { return errors.New("connection refused") })
+	hh.AddCheck("process", func(ctx context.Context) error { return nil }, HealthCheckLiveness())
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		code    int
+		status  string
+		checks  map[string]string
+	}{
+		{"live", hh.Live(), http.StatusOK, "ok", map[string]string{"process": "ok"}},
+		{"ready", hh.Ready(), http.StatusServiceUnavailable, "error", map[string]string{
+			"database": "ok", "cache": "error", "process": "ok",
+		}},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+		if rr.Code != tt.code {
+			t.Fatalf("%s: bad status: got %v want %v", tt.name, rr.Code, tt.code)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Fatalf("%s: bad content type: got %q", tt.name, got)
+		}
+
+		var report HealthReport
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: bad JSON: %v", tt.name, err)
+		}
+		if report.Status != tt.status {
+			t.Fatalf("%s: bad report status: got %q want %q", tt.name, report.Status, tt.status)
+		}
+		if len(report.Checks) != len(tt.checks) {
+			t.Fatalf("%s: bad checks: got %v want %v", tt.name, report.Checks, tt.checks)
+		}
+		for name, status := range tt.checks {
+			if got := report.Checks[name].Status; got != status {
+				t.Fatalf("%s: bad status for %s: got %q want %q", tt.name, name, got, status)
+			}
+		}
+	}
+
+	if res := hh.Check(context.Background(), false).Checks["cache"]; res.Error != "connection refused" {
+		t.Fatalf("bad error: got %q want %q", res.Error, "connection refused")
+	}
+}
+
+func TestHealthHandlerTimeout(t *testing.T) {
+	hh := NewHealthHandler(HealthTimeout(time.Minute))
+	hh.AddCheck("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, HealthCheckTimeout(10*time.Millisecond))
+
+	start := time.Now()
+	report := hh.Check(context.Background(), false)
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("check did not time out")
+	}
+	if res := report.Checks["slow"]; res.Status != "error" || res.Error != context.DeadlineExceeded.Error() {
+		t.Fatalf("bad result: %+v", res)
+	}
+}
+
+func TestHealthHandlerCache(t *testing.T) {
+	calls := 0
+	hh := NewHealthHandler(HealthCacheTTL(time.Minute))
+	hh.AddCheck("counted", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		hh.Check(context.Background(), false)
+	}
+	if calls != 1 {
+		t.Fatalf("check ran %d times, want 1", calls)
+	}
+
+	// Replacing a check discards its cached result.
+	hh.AddCheck("counted", func(ctx context.Context) error { return errors.New("down") })
+	if report := hh.Check(context.Background(), false); report.Status != "error" {
+		t.Fatalf("bad status after replacing check: got %q", report.Status)
+	}
+}
+
+func TestHealthHandlerCacheCanceled(t *testing.T) {
+	hh := NewHealthHandler(HealthCacheTTL(time.Minute))
+	hh.AddCheck("context", func(ctx context.Context) error { return ctx.Err() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if report := hh.Check(ctx, false); report.Status != "error" {
+		t.Fatalf("bad status with a canceled context: got %q", report.Status)
+	}
+
+	// The failure caused by the caller isn't cached.
+	if report := hh.Check(context.Background(), false); report.Status != "ok" {
+		t.Fatalf("bad status after a canceled check: got %q", report.Status)
+	}
+}
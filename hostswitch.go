@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HostSwitch is an http.Handler dispatching requests to handlers by the host
+// they are addressed to, for serving several domains without a full router.
+//
+// Patterns are either host names, such as "example.com", or wildcards
+// matching all subdomains of a domain, such as "*.example.com", which does not
+// match example.com itself. Patterns without a port match any port, patterns
+// with a port only that port. Exact patterns take precedence over wildcards,
+// and longer wildcards over shorter ones. Requests matching no pattern are
+// passed to Default or, if it is nil, answered with a 404 "Not Found".
+//
+// The matched pattern is logged as the virtual host by the logging handlers
+// wrapping a HostSwitch; see VHostCombinedLogFormatter.
+//
+// Handlers must be registered before serving requests.
+//
+// Example:
+//
+//	hosts := &handlers.HostSwitch{Default: http.NotFoundHandler()}
+//	hosts.Handle("example.com", siteRouter)
+//	hosts.Handle("*.example.com", tenantRouter)
+//	http.ListenAndServe(":8000", handlers.CustomLoggingHandler(os.Stdout, hosts, handlers.VHostCombinedLogFormatter))
+type HostSwitch struct {
+	// Default handles requests matching no pattern.
+	Default http.Handler
+
+	exact     map[string]http.Handler
+	wildcards []hostSwitchWildcard
+}
+
+type hostSwitchWildcard struct {
+	pattern string
+	suffix  string
+	h       http.Handler
+}
+
+// Handle registers h for the host pattern.
+func (hs *HostSwitch) Handle(pattern string, h http.Handler) {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		hs.wildcards = append(hs.wildcards, hostSwitchWildcard{pattern: pattern, suffix: pattern[1:], h: h})
+		sort.SliceStable(hs.wildcards, func(i, j int) bool {
+			return len(hs.wildcards[i].suffix) > len(hs.wildcards[j].suffix)
+		})
+		return
+	}
+	if hs.exact == nil {
+		hs.exact = make(map[string]http.Handler)
+	}
+	hs.exact[pattern] = h
+}
+
+// HandleFunc registers the handler function fn for the host pattern.
+func (hs *HostSwitch) HandleFunc(pattern string, fn func(http.ResponseWriter, *http.Request)) {
+	hs.Handle(pattern, http.HandlerFunc(fn))
+}
+
+// Handler returns the handler for r and the pattern it was registered with,
+// or Default and "" if no pattern matches.
+func (hs *HostSwitch) Handler(r *http.Request) (http.Handler, string) {
+	hostport := strings.ToLower(strings.TrimSuffix(r.Host, "."))
+	host := strings.TrimSuffix(hostWithoutPort(hostport), ".")
+	if h, ok := hs.exact[hostport]; ok {
+		return h, hostport
+	}
+	if h, ok := hs.exact[host]; ok {
+		return h, host
+	}
+	for _, w := range hs.wildcards {
+		name := host
+		if strings.Contains(w.suffix, ":") {
+			name = hostport
+		}
+		if len(name) > len(w.suffix) && strings.HasSuffix(name, w.suffix) {
+			return w.h, w.pattern
+		}
+	}
+	return hs.Default, ""
+}
+
+func (hs *HostSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, pattern := hs.Handler(r)
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if rec := requestRecordFromContext(r.Context()); rec != nil && pattern != "" {
+		rec.setVirtualHost(pattern)
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func namedHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(name))
+	})
+}
+
+func TestHostSwitch(t *testing.T) {
+	hs := &HostSwitch{Default: namedHandler("default")}
+	hs.Handle("example.com", namedHandler("site"))
+	hs.Handle("example.com:8443", namedHandler("admin"))
+	hs.Handle("*.example.com", namedHandler("tenant"))
+	hs.Handle("*.eu.example.com", namedHandler("eu"))
+	hs.Handle("*.dev.test:8080", namedHandler("dev"))
+
+	tests := []struct {
+		host, want string
+	}{
+		{"example.com", "site"},
+		{"EXAMPLE.com:80", "site"},
+		{"example.com.", "site"},
+		{"example.com:8443", "admin"},
+		{"a.example.com", "tenant"},
+		{"a.b.example.com", "tenant"},
+		{"a.eu.example.com", "eu"},
+		{"eu.example.com", "tenant"},
+		{"badexample.com", "default"},
+		{"app.dev.test:8080", "dev"},
+		{"app.dev.test:9090", "default"},
+		{"[::1]:80", "default"},
+	}
+	for _, test := range tests {
+		r := newRequest(http.MethodGet, "/")
+		r.Host = test.host
+		rr := httptest.NewRecorder()
+		hs.ServeHTTP(rr, r)
+		if rr.Body.String() != test.want {
+			t.Errorf("%s: got %q want %q", test.host, rr.Body.String(), test.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	(&HostSwitch{}).ServeHTTP(rr, newRequest(http.MethodGet, "http://example.com/"))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("bad status without a default handler: %v", rr.Code)
+	}
+}
+
+func TestHostSwitchLogging(t *testing.T) {
+	hs := &HostSwitch{}
+	hs.Handle("*.example.com", okHandler)
+
+	var buf bytes.Buffer
+	h := CustomLoggingHandler(&buf, hs, VHostCombinedLogFormatter)
+	r := newRequest(http.MethodGet, "http://shop.example.com/")
+	r.RemoteAddr = "192.0.2.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !strings.HasPrefix(buf.String(), `*.example.com 192.0.2.1 - - [`) || !strings.HasSuffix(buf.String(), "\" 200 3 \"\" \"\"\n") {
+		t.Fatalf("bad log line: %q", buf.String())
+	}
+}
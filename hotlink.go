@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type hotlinkHandler struct {
+	h           http.Handler
+	paths       []string
+	referers    []string
+	allowEmpty  bool
+	placeholder http.Handler
+}
+
+// HotlinkOption provides a functional approach to configure the
+// HotlinkHandler middleware.
+type HotlinkOption func(*hotlinkHandler)
+
+// HotlinkHandler is HTTP middleware providing simple protection against
+// hotlinking: requests for protected paths are only served if their Referer
+// is on the same host as the request, or on one of the allowed hosts. Other
+// requests are answered with a 403 "Forbidden", or with a placeholder.
+//
+// Requests without a Referer are allowed by default, as browsers and privacy
+// tools commonly strip it.
+//
+// Example:
+//
+//	hotlink := handlers.HotlinkHandler(
+//		handlers.HotlinkPaths("/media/"),
+//		handlers.HotlinkAllowedReferers("*.example.com"),
+//	)
+//	http.ListenAndServe(":8000", hotlink(r))
+func HotlinkHandler(opts ...HotlinkOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		hl := &hotlinkHandler{h: h, allowEmpty: true}
+		for _, option := range opts {
+			option(hl)
+		}
+		return hl
+	}
+}
+
+// HotlinkPaths is a functional option that limits protection to request paths
+// beginning with one of prefixes. All paths are protected by default.
+func HotlinkPaths(prefixes ...string) HotlinkOption {
+	return func(hl *hotlinkHandler) {
+		hl.paths = prefixes
+	}
+}
+
+// HotlinkAllowedReferers is a functional option that sets the hosts, besides
+// the host of the request, allowed to refer to protected paths. A host of the
+// form "*.example.com" allows all subdomains of example.com.
+func HotlinkAllowedReferers(hosts ...string) HotlinkOption {
+	return func(hl *hotlinkHandler) {
+		hl.referers = make([]string, len(hosts))
+		for i, host := range hosts {
+			hl.referers[i] = strings.ToLower(host)
+		}
+	}
+}
+
+// HotlinkAllowEmpty is a functional option that sets whether requests without
+// a Referer are allowed.
+func HotlinkAllowEmpty(allow bool) HotlinkOption {
+	return func(hl *hotlinkHandler) {
+		hl.allowEmpty = allow
+	}
+}
+
+// HotlinkPlaceholder is a functional option that sets the handler serving
+// rejected requests, e.g. a placeholder image, instead of a 403.
+func HotlinkPlaceholder(h http.Handler) HotlinkOption {
+	return func(hl *hotlinkHandler) {
+		hl.placeholder = h
+	}
+}
+
+func (hl *hotlinkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (len(hl.paths) > 0 && !hasAnyPrefix(r.URL.Path, hl.paths)) || hl.allowed(r) {
+		hl.h.ServeHTTP(w, r)
+		return
+	}
+	if hl.placeholder != nil {
+		hl.placeholder.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+func (hl *hotlinkHandler) allowed(r *http.Request) bool {
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		return hl.allowEmpty
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == strings.ToLower(hostWithoutPort(r.Host)) {
+		return true
+	}
+	for _, allowed := range hl.referers {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
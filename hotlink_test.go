@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHotlinkHandler(t *testing.T) {
+	h := HotlinkHandler(
+		HotlinkPaths("/media/"),
+		HotlinkAllowedReferers("partner.org", "*.example.com"),
+	)(okHandler)
+
+	tests := []struct {
+		path, referer string
+		code          int
+	}{
+		{"/media/cat.jpg", "", http.StatusOK},
+		{"/media/cat.jpg", "https://www.mysite.net/page", http.StatusOK},
+		{"/media/cat.jpg", "https://partner.org/", http.StatusOK},
+		{"/media/cat.jpg", "https://cdn.example.com/x", http.StatusOK},
+		{"/media/cat.jpg", "https://example.com.evil.net/x", http.StatusForbidden},
+		{"/media/cat.jpg", "https://evil.net/", http.StatusForbidden},
+		{"/media/cat.jpg", "not a url", http.StatusForbidden},
+		{"/index.html", "https://evil.net/", http.StatusOK},
+	}
+	for i, test := range tests {
+		r := newRequest(http.MethodGet, "http://www.mysite.net:8080"+test.path)
+		if test.referer != "" {
+			r.Header.Set("Referer", test.referer)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != test.code {
+			t.Errorf("%d: bad status: got %v want %v", i, rr.Code, test.code)
+		}
+	}
+}
+
+func TestHotlinkPlaceholder(t *testing.T) {
+	h := HotlinkHandler(
+		HotlinkAllowEmpty(false),
+		HotlinkPlaceholder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("placeholder"))
+		})),
+	)(okHandler)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "http://example.com/a.png"))
+	if rr.Code != http.StatusOK || rr.Body.String() != "placeholder" {
+		t.Fatalf("bad response: %v %q", rr.Code, rr.Body.String())
+	}
+}
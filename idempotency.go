@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyStoreTimeout bounds the storing of responses, which outlives the
+// requests of clients which gave up waiting.
+const idempotencyStoreTimeout = 5 * time.Second
+
+// IdempotentResponse is a response stored by IdempotencyHandler.
+type IdempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Fingerprint identifies the request the response was given to, so that
+	// a key re-used for a different request can be detected.
+	Fingerprint string
+}
+
+// IdempotencyStore stores responses by idempotency key. Implementations
+// backed by a shared database or cache let replays work across server
+// instances.
+type IdempotencyStore interface {
+	// Get returns the response stored for key, or false if there is none.
+	Get(ctx context.Context, key string) (*IdempotentResponse, bool, error)
+	// Set stores resp for key for the given duration.
+	Set(ctx context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore keeping responses in
+// memory, for single instance servers and tests.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry), now: time.Now}
+}
+
+type memoryIdempotencyEntry struct {
+	resp    *IdempotentResponse
+	expires time.Time
+}
+
+type memoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func (s *memoryIdempotencyStore) Get(ctx context.Context, key string) (*IdempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || !s.now().Before(e.expires) {
+		return nil, false, nil
+	}
+	return e.resp, true, nil
+}
+
+func (s *memoryIdempotencyStore) Set(ctx context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= time.Minute {
+		s.lastSweep = now
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	s.entries[key] = memoryIdempotencyEntry{resp: resp, expires: now.Add(ttl)}
+	return nil
+}
+
+type idempotencyHandler struct {
+	h        http.Handler
+	store    IdempotencyStore
+	header   string
+	methods  []string
+	ttl      time.Duration
+	scope    func(*http.Request) string
+	required bool
+	maxBytes int64
+	maxBody  int64
+
+	mu      sync.Mutex
+	flights map[string]chan struct{}
+}
+
+// IdempotencyOption provides a functional approach to configure the
+// IdempotencyHandler middleware.
+type IdempotencyOption func(*idempotencyHandler)
+
+// IdempotencyHandler is HTTP middleware making POST and PATCH requests
+// carrying an Idempotency-Key header safe to retry. The response to the first
+// request with a given key is stored, by default in memory for 24 hours, and
+// replayed with an "Idempotent-Replayed: true" header to later requests with
+// the same key, without calling the wrapped handler again. Requests with the
+// same key arriving while the first one is being served wait for it.
+//
+// Responses with a status of 500 or above are not stored, so that requests
+// failing for transient reasons can be retried. Re-using a key for a
+// different request, i.e. with another method, path or body, is answered
+// with a 422 "Unprocessable Entity".
+//
+// Example:
+//
+//	idempotency := handlers.IdempotencyHandler(
+//		handlers.IdempotencyStorage(redisStore),
+//		handlers.IdempotencyScope(func(r *http.Request) string { return accountID(r) }),
+//	)
+//	r.Handle("/payments", idempotency(paymentsHandler)).Methods("POST")
+func IdempotencyHandler(opts ...IdempotencyOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		i := &idempotencyHandler{
+			h:        h,
+			header:   "Idempotency-Key",
+			methods:  []string{http.MethodPost, http.MethodPatch},
+			ttl:      24 * time.Hour,
+			maxBytes: 1 << 20,
+			maxBody:  1 << 20,
+			flights:  make(map[string]chan struct{}),
+		}
+		for _, option := range opts {
+			option(i)
+		}
+		if i.store == nil {
+			i.store = NewMemoryIdempotencyStore()
+		}
+		return i
+	}
+}
+
+// IdempotencyStorage is a functional option that sets the store of responses.
+func IdempotencyStorage(store IdempotencyStore) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.store = store
+	}
+}
+
+// IdempotencyTTL is a functional option that sets how long responses are
+// stored.
+func IdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.ttl = ttl
+	}
+}
+
+// IdempotencyHeader is a functional option that sets the name of the request
+// header carrying the idempotency key.
+func IdempotencyHeader(name string) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.header = name
+	}
+}
+
+// IdempotencyMethods is a functional option that sets the request methods
+// subject to idempotency keys.
+func IdempotencyMethods(methods ...string) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.methods = methods
+	}
+}
+
+// IdempotencyScope is a functional option that sets a function returning the
+// scope of idempotency keys, typically the authenticated user, so that keys
+// chosen by different clients never clash.
+func IdempotencyScope(fn func(*http.Request) string) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.scope = fn
+	}
+}
+
+// IdempotencyRequired is a functional option that answers requests lacking an
+// idempotency key with a 400 "Bad Request".
+func IdempotencyRequired() IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.required = true
+	}
+}
+
+// IdempotencyMaxBytes is a functional option that sets the size of the
+// largest response body stored, 1 MiB by default. Larger responses are not
+// stored.
+func IdempotencyMaxBytes(n int64) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.maxBytes = n
+	}
+}
+
+// IdempotencyMaxBodyBytes is a functional option that sets the size of the
+// largest request body accepted with an idempotency key, 1 MiB by default.
+// The body is read to fingerprint the request before calling the wrapped
+// handler, and larger ones are answered with a 413 "Request Entity Too Large".
+func IdempotencyMaxBodyBytes(n int64) IdempotencyOption {
+	return func(i *idempotencyHandler) {
+		i.maxBody = n
+	}
+}
+
+func (i *idempotencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idemKey := r.Header.Get(i.header)
+	if !containsString(i.methods, r.Method) || (idemKey == "" && !i.required) {
+		i.h.ServeHTTP(w, r)
+		return
+	}
+	if idemKey == "" || len(idemKey) > 255 {
+		http.Error(w, "Missing or invalid "+i.header+" header", http.StatusBadRequest)
+		return
+	}
+
+	key := idemKey
+	if i.scope != nil {
+		key = i.scope(r) + "\x00" + idemKey
+	}
+	fingerprint, err := i.fingerprint(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	for {
+		resp, ok, err := i.store.Get(r.Context(), key)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			i.replay(w, resp, fingerprint)
+			return
+		}
+
+		i.mu.Lock()
+		flight, busy := i.flights[key]
+		if !busy {
+			i.flights[key] = make(chan struct{})
+			i.mu.Unlock()
+			break
+		}
+		i.mu.Unlock()
+		select {
+		case <-flight:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	defer func() {
+		i.mu.Lock()
+		close(i.flights[key])
+		delete(i.flights, key)
+		i.mu.Unlock()
+	}()
+
+	cw := &cacheWriter{w: w, limit: i.maxBytes}
+	i.h.ServeHTTP(cw.wrap(), r)
+
+	code, header := cw.code, cw.header
+	if code == 0 {
+		code, header = http.StatusOK, w.Header().Clone()
+	}
+	if cw.overflow || code >= http.StatusInternalServerError {
+		return
+	}
+	// Clients which time out are the ones retrying, so the response must be
+	// stored even if they are gone.
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+	defer cancel()
+	_ = i.store.Set(ctx, key, &IdempotentResponse{
+		StatusCode:  code,
+		Header:      header,
+		Body:        cw.buf.Bytes(),
+		Fingerprint: fingerprint,
+	}, i.ttl)
+}
+
+func (i *idempotencyHandler) replay(w http.ResponseWriter, resp *IdempotentResponse, fingerprint string) {
+	if resp.Fingerprint != "" && resp.Fingerprint != fingerprint {
+		http.Error(w, i.header+" was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(resp.Body)
+}
+
+// fingerprint hashes the method, URL and body of r, restoring the body for
+// the wrapped handler. It fails with a *http.MaxBytesError if the body is
+// larger than maxBody.
+func (i *idempotencyHandler) fingerprint(r *http.Request) (string, error) {
+	hash := sha256.New()
+	_, _ = io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, i.maxBody+1))
+		r.Body.Close()
+		if err == nil && int64(len(body)) > i.maxBody {
+			err = &http.MaxBytesError{Limit: i.maxBody}
+		}
+		if err != nil {
+			return "", err
+		}
+		hash.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
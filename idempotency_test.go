@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(key, body string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	return r
+}
+
+func TestIdempotencyHandler(t *testing.T) {
+	var calls int32
+	h := IdempotencyHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("X-Call", strings.Repeat("x", int(n)))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("payment created"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k1", `{"amount":10}`))
+	if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("bad first response: %v %v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k1", `{"amount":10}`))
+	if rr.Code != http.StatusCreated || rr.Body.String() != "payment created" ||
+		rr.Header().Get("X-Call") != "x" || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("bad replay: %v %v %q", rr.Code, rr.Header(), rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k1", `{"amount":99}`))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("key re-use not detected: %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("", `{"amount":10}`))
+	if rr.Code != http.StatusCreated || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("request without key not served: %v", rr.Code)
+	}
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	h := IdempotencyHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+		}
+		<-release
+		_, _ = w.Write([]byte(ok))
+	})).(*idempotencyHandler)
+
+	var wg sync.WaitGroup
+	recs := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(recs[0], idempotentRequest("k", "body"))
+	}()
+	<-entered
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(recs[1], idempotentRequest("k", "body"))
+	}()
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("handler called %d times", got)
+	}
+	for i, rr := range recs {
+		if rr.Code != http.StatusOK || rr.Body.String() != ok {
+			t.Fatalf("%d: bad response: %v %q", i, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestIdempotencyOptions(t *testing.T) {
+	code := http.StatusInternalServerError
+	var calls int
+	h := IdempotencyHandler(
+		IdempotencyRequired(),
+		IdempotencyScope(func(r *http.Request) string { return r.Header.Get("X-User") }),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(code)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("", ""))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("missing key accepted: %v", rr.Code)
+	}
+
+	// Server errors are not stored.
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", ""))
+	code = http.StatusOK
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k", ""))
+	if calls != 2 {
+		t.Fatalf("bad number of calls: %d", calls)
+	}
+
+	// Keys are scoped.
+	r := idempotentRequest("k", "")
+	r.Header.Set("X-User", "bob")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if calls != 3 || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("key shared across scopes: %d calls", calls)
+	}
+}
+
+func TestIdempotencyMaxBodyBytes(t *testing.T) {
+	var calls int32
+	h := IdempotencyHandler(IdempotencyMaxBodyBytes(8))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k1", "12345678"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("body at the limit rejected: %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k2", "123456789"))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("body over the limit: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+}
+
+// contextStore fails when its context is done, like stores backed by a
+// database.
+type contextStore struct {
+	IdempotencyStore
+}
+
+func (s contextStore) Set(ctx context.Context, key string, resp *IdempotentResponse, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.IdempotencyStore.Set(ctx, key, resp, ttl)
+}
+
+func TestIdempotencyClientGone(t *testing.T) {
+	var calls int32
+	h := IdempotencyHandler(IdempotencyStorage(contextStore{NewMemoryIdempotencyStore()}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	// The client is gone by the time the response is stored.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), idempotentRequest("k1", `{"amount":10}`).WithContext(ctx))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, idempotentRequest("k1", `{"amount":10}`))
+	if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("bad retry: %v %v", rr.Code, rr.Header())
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := NewIPFilter(
+		AllowedIPs([]string{"10.0.0.0/8", "192.168.1.10", "2001:db8::/32"}),
+		DeniedIPs([]string{"10.0.13.0/24"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := f.Handler(okHandler)
+
+	tests := []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"10.0.13.7:1234", http.StatusForbidden},
+		{"192.168.1.10:80", http.StatusOK},
+		{"192.168.1.11:80", http.StatusForbidden},
+		{"[2001:db8:cafe::17]:4711", http.StatusOK},
+		{"[::ffff:10.1.2.3]:4711", http.StatusOK},
+		{"10.1.2.3", http.StatusOK}, // as set by ProxyHeaders
+		{"garbage", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := newRequest(http.MethodGet, "/")
+		r.RemoteAddr = tt.remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != tt.code {
+			t.Errorf("%s: bad status: got %v want %v", tt.remoteAddr, rr.Code, tt.code)
+		}
+	}
+}
+
+func TestIPFilterDenyOnly(t *testing.T) {
+	f, err := NewIPFilter(DeniedIPs([]string{"203.0.113.0/24"}), IPRejectedHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := f.Handler(okHandler)
+
+	r := newRequest(http.MethodGet, "/")
+	r.RemoteAddr = "198.51.100.1:1234"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Update the rules at runtime.
+	if err := f.SetDenied([]string{"198.51.100.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusTeapot {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusTeapot)
+	}
+}
+
+func TestIPFilterInvalidRules(t *testing.T) {
+	if _, err := NewIPFilter(AllowedIPs([]string{"10.0.0.0/33"})); err == nil {
+		t.Error("expected an error for an invalid range")
+	}
+	if _, err := NewIPFilter(DeniedIPs([]string{"localhost"})); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+
+	f, _ := NewIPFilter(AllowedIPs([]string{"10.0.0.0/8"}))
+	if err := f.SetAllowed([]string{"nope"}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+	r := newRequest(http.MethodGet, "/")
+	r.RemoteAddr = "10.0.0.1:1"
+	rr := httptest.NewRecorder()
+	f.Handler(okHandler).ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rules changed by failed update: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	// Register the hashes used by the supported algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// jwtCurves maps the ECDSA algorithms to the names of their curves.
+var jwtCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+// Errors reported by JWTHandler, possibly wrapped, to its error handler.
+var (
+	ErrJWTMissing          = errors.New("handlers: missing bearer token")
+	ErrJWTMalformed        = errors.New("handlers: malformed token")
+	ErrJWTUnverifiable     = errors.New("handlers: token cannot be verified")
+	ErrJWTSignatureInvalid = errors.New("handlers: invalid token signature")
+	ErrJWTExpired          = errors.New("handlers: token is expired")
+	ErrJWTNotValidYet      = errors.New("handlers: token is not valid yet")
+	ErrJWTInvalidIssuer    = errors.New("handlers: invalid token issuer")
+	ErrJWTInvalidAudience  = errors.New("handlers: invalid token audience")
+)
+
+type jwtClaimsKey struct{}
+
+// JWTHeader is the JOSE header of a JSON Web Token.
+type JWTHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// JWTClaims are the claims of a validated JSON Web Token (RFC 7519).
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	// Raw holds all claims of the token, including the registered ones above.
+	Raw map[string]interface{}
+}
+
+// JWTKeyfunc returns the key verifying a token with the given header, e.g.
+// by looking up its key ID in a JWKS. The key is passed on to the
+// JWTVerifier; the built-in one expects a []byte for HMAC algorithms and a
+// *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey otherwise.
+type JWTKeyfunc func(r *http.Request, header JWTHeader) (interface{}, error)
+
+// JWTVerifier verifies the signature of a token signed using algorithm alg.
+// Implement it to plug in a signing library of choice. It must refuse keys
+// which don't match the algorithm, so that e.g. an RSA public key is never
+// used as an HMAC secret.
+type JWTVerifier interface {
+	Verify(alg string, signingInput, signature []byte, key interface{}) error
+}
+
+type jwtHandler struct {
+	h            http.Handler
+	keyfunc      JWTKeyfunc
+	verifier     JWTVerifier
+	algorithms   []string
+	issuers      []string
+	audiences    []string
+	leeway       time.Duration
+	realm        string
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error)
+	now          func() time.Time
+}
+
+// JWTOption provides a functional approach to configure the JWTHandler
+// middleware.
+type JWTOption func(*jwtHandler)
+
+// JWTHandler is HTTP middleware that requires requests to carry a valid JSON
+// Web Token as a bearer token (RFC 6750). Signatures are checked with the key
+// returned by keyfunc, and the exp and nbf claims are always enforced when
+// present. Valid claims are available to the next handler via
+// JWTClaimsFromContext.
+//
+// Signatures are verified by a built-in verifier supporting the HS, RS, PS
+// and ES families of algorithms and EdDSA, using the standard library only.
+// The "none" algorithm is never accepted.
+//
+// Invalid requests are answered with a 401 "Unauthorized" and a Bearer
+// challenge, unless an error handler is set with JWTErrorHandler.
+//
+// Example:
+//
+//	auth := handlers.JWTHandler(func(r *http.Request, h handlers.JWTHeader) (interface{}, error) {
+//		return jwks.Key(h.KeyID)
+//	}, handlers.JWTIssuer("https://auth.example.com/"), handlers.JWTAudience("api"))
+//	http.ListenAndServe(":8000", auth(r))
+func JWTHandler(keyfunc JWTKeyfunc, opts ...JWTOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		j := &jwtHandler{
+			h:        h,
+			keyfunc:  keyfunc,
+			verifier: stdJWTVerifier{},
+			realm:    defaultBasicAuthRealm,
+			now:      time.Now,
+		}
+		for _, option := range opts {
+			option(j)
+		}
+		return j
+	}
+}
+
+// JWTAlgorithms is a functional option that limits the accepted signing
+// algorithms, e.g. to "RS256".
+func JWTAlgorithms(algorithms ...string) JWTOption {
+	return func(j *jwtHandler) {
+		j.algorithms = algorithms
+	}
+}
+
+// JWTIssuer is a functional option that requires the iss claim to be one of
+// issuers.
+func JWTIssuer(issuers ...string) JWTOption {
+	return func(j *jwtHandler) {
+		j.issuers = issuers
+	}
+}
+
+// JWTAudience is a functional option that requires the aud claim to contain
+// one of audiences.
+func JWTAudience(audiences ...string) JWTOption {
+	return func(j *jwtHandler) {
+		j.audiences = audiences
+	}
+}
+
+// JWTLeeway is a functional option that sets the clock skew tolerated when
+// checking the exp and nbf claims.
+func JWTLeeway(d time.Duration) JWTOption {
+	return func(j *jwtHandler) {
+		j.leeway = d
+	}
+}
+
+// JWTRealm is a functional option that sets the realm advertised in the
+// authentication challenge.
+func JWTRealm(realm string) JWTOption {
+	return func(j *jwtHandler) {
+		j.realm = realm
+	}
+}
+
+// JWTCustomVerifier is a functional option that replaces the built-in
+// signature verifier.
+func JWTCustomVerifier(v JWTVerifier) JWTOption {
+	return func(j *jwtHandler) {
+		j.verifier = v
+	}
+}
+
+// JWTErrorHandler is a functional option that sets the function responding
+// to requests with missing or invalid tokens.
+func JWTErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) JWTOption {
+	return func(j *jwtHandler) {
+		j.errorHandler = fn
+	}
+}
+
+// JWTClaimsFromContext returns the claims of the token validated by
+// JWTHandler, or nil if there are none.
+func JWTClaimsFromContext(ctx context.Context) *JWTClaims {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(*JWTClaims)
+	return claims
+}
+
+func (j *jwtHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := j.validate(r)
+	if err != nil {
+		if j.errorHandler != nil {
+			j.errorHandler(w, r, err)
+			return
+		}
+		c := `Bearer realm=` + quoteAuthParam(j.realm)
+		if !errors.Is(err, ErrJWTMissing) {
+			c += `, error="invalid_token"`
+		}
+		w.Header().Set(wwwAuthenticateHeader, c)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	j.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, claims)))
+}
+
+func (j *jwtHandler) validate(r *http.Request) (*JWTClaims, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return nil, ErrJWTMissing
+	}
+	token := strings.TrimSpace(auth[len("Bearer "):])
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	var header JWTHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	if header.Algorithm == "" || strings.EqualFold(header.Algorithm, "none") ||
+		(len(j.algorithms) > 0 && !containsString(j.algorithms, header.Algorithm)) {
+		return nil, fmt.Errorf("%w: algorithm %q not allowed", ErrJWTUnverifiable, header.Algorithm)
+	}
+	key, err := j.keyfunc(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrJWTUnverifiable, err)
+	}
+	signingInput := token[:len(parts[0])+1+len(parts[1])]
+	if err := j.verifier.Verify(header.Algorithm, []byte(signingInput), signature, key); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+	claims, err := parseJWTClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	return claims, j.checkClaims(claims)
+}
+
+func (j *jwtHandler) checkClaims(c *JWTClaims) error {
+	now := j.now()
+	if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt.Add(j.leeway)) {
+		return ErrJWTExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(j.leeway).Before(c.NotBefore) {
+		return ErrJWTNotValidYet
+	}
+	if len(j.issuers) > 0 && !containsString(j.issuers, c.Issuer) {
+		return ErrJWTInvalidIssuer
+	}
+	if len(j.audiences) > 0 {
+		for _, aud := range c.Audience {
+			if containsString(j.audiences, aud) {
+				return nil
+			}
+		}
+		return ErrJWTInvalidAudience
+	}
+	return nil
+}
+
+func decodeJWTSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrJWTMalformed, err)
+	}
+	return nil
+}
+
+func parseJWTClaims(raw map[string]interface{}) (*JWTClaims, error) {
+	c := &JWTClaims{Raw: raw}
+	for name, dst := range map[string]*string{"iss": &c.Issuer, "sub": &c.Subject, "jti": &c.ID} {
+		if v, ok := raw[name]; ok {
+			if *dst, ok = v.(string); !ok {
+				return nil, ErrJWTMalformed
+			}
+		}
+	}
+	for name, dst := range map[string]*time.Time{"exp": &c.ExpiresAt, "nbf": &c.NotBefore, "iat": &c.IssuedAt} {
+		if v, ok := raw[name]; ok {
+			f, ok := v.(float64)
+			if !ok {
+				return nil, ErrJWTMalformed
+			}
+			sec, frac := math.Modf(f)
+			*dst = time.Unix(int64(sec), int64(frac*1e9))
+		}
+	}
+
+	switch aud := raw["aud"].(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, ErrJWTMalformed
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		return nil, ErrJWTMalformed
+	}
+	return c, nil
+}
+
+// stdJWTVerifier verifies signatures using the standard library.
+type stdJWTVerifier struct{}
+
+func (stdJWTVerifier) Verify(alg string, signingInput, signature []byte, key interface{}) error {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrJWTUnverifiable
+		}
+		if !ed25519.Verify(pub, signingInput, signature) {
+			return ErrJWTSignatureInvalid
+		}
+		return nil
+	}
+
+	if len(alg) != 5 {
+		return ErrJWTUnverifiable
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return ErrJWTUnverifiable
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	var valid bool
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrJWTUnverifiable
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signingInput)
+		valid = hmac.Equal(signature, mac.Sum(nil))
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrJWTUnverifiable
+		}
+		if alg[0] == 'R' {
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+		} else {
+			valid = rsa.VerifyPSS(pub, hash, digest, signature, nil) == nil
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		// Each algorithm is bound to a curve, see RFC 7518, section 3.4.
+		if !ok || pub.Curve == nil || pub.Curve.Params().Name != jwtCurves[alg] {
+			return ErrJWTUnverifiable
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrJWTSignatureInvalid
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	default:
+		return ErrJWTUnverifiable
+	}
+	if !valid {
+		return ErrJWTSignatureInvalid
+	}
+	return nil
+}
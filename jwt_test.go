@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, header, claims map[string]interface{}, sign func([]byte) []byte) string {
+	seg := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	input := seg(header) + "." + seg(claims)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(input)))
+}
+
+func TestJWTHandler(t *testing.T) {
+	secret := []byte("secret")
+	hs256 := func(b []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(b)
+		return mac.Sum(nil)
+	}
+	now := time.Unix(1700000000, 0)
+
+	var gotErr error
+	var claims *JWTClaims
+	h := JWTHandler(func(r *http.Request, header JWTHeader) (interface{}, error) {
+		if header.KeyID != "k1" {
+			return nil, errors.New("unknown key")
+		}
+		return secret, nil
+	}, JWTIssuer("https://issuer.example"), JWTAudience("api"), JWTLeeway(time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = JWTClaimsFromContext(r.Context())
+	})).(*jwtHandler)
+	h.now = func() time.Time { return now }
+	h.errorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		gotErr = err
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	header := map[string]interface{}{"alg": "HS256", "kid": "k1"}
+	valid := map[string]interface{}{
+		"iss": "https://issuer.example", "sub": "alice", "aud": []string{"other", "api"},
+		"exp": now.Add(time.Hour).Unix(), "nbf": now.Add(30 * time.Second).Unix(), "role": "admin",
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := make(map[string]interface{})
+		for k, v := range valid {
+			c[k] = v
+		}
+		c[k] = v
+		return c
+	}
+
+	tests := []struct {
+		token string
+		err   error
+	}{
+		{signJWT(t, header, valid, hs256), nil},
+		{"", ErrJWTMissing},
+		{"not.a.jwt", ErrJWTMalformed},
+		{signJWT(t, map[string]interface{}{"alg": "none", "kid": "k1"}, valid, func([]byte) []byte { return nil }), ErrJWTUnverifiable},
+		{signJWT(t, map[string]interface{}{"alg": "HS256", "kid": "k2"}, valid, hs256), ErrJWTUnverifiable},
+		{signJWT(t, header, valid, func([]byte) []byte { return []byte("forged") }), ErrJWTSignatureInvalid},
+		{signJWT(t, header, with("exp", now.Add(-2*time.Minute).Unix()), hs256), ErrJWTExpired},
+		{signJWT(t, header, with("nbf", now.Add(2*time.Minute).Unix()), hs256), ErrJWTNotValidYet},
+		{signJWT(t, header, with("iss", "https://evil.example"), hs256), ErrJWTInvalidIssuer},
+		{signJWT(t, header, with("aud", "other"), hs256), ErrJWTInvalidAudience},
+	}
+	for i, test := range tests {
+		gotErr, claims = nil, nil
+		r := newRequest(http.MethodGet, "/")
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if !errors.Is(gotErr, test.err) || (test.err == nil) != (gotErr == nil) {
+			t.Fatalf("%d: bad error: got %v want %v", i, gotErr, test.err)
+		}
+		if test.err == nil && (claims == nil || claims.Subject != "alice" || claims.Raw["role"] != "admin" || !claims.ExpiresAt.Equal(now.Add(time.Hour))) {
+			t.Fatalf("%d: bad claims: %+v", i, claims)
+		}
+	}
+}
+
+func TestJWTHandlerES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := signJWT(t, map[string]interface{}{"alg": "ES256"}, map[string]interface{}{"sub": "bob"}, func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	})
+
+	h := JWTHandler(func(r *http.Request, header JWTHeader) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, JWTAlgorithms("ES256"))(okHandler)
+
+	r := newRequest(http.MethodGet, "/")
+	r.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status: %v", rr.Code)
+	}
+
+	// The public key must not be usable as an HMAC secret.
+	h = JWTHandler(func(r *http.Request, header JWTHeader) (interface{}, error) {
+		return &key.PublicKey, nil
+	})(okHandler)
+	forged := signJWT(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "bob"}, func(b []byte) []byte { return b })
+	r.Header.Set("Authorization", "Bearer "+forged)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != `Bearer realm="Restricted", error="invalid_token"` {
+		t.Fatalf("bad response: %v %v", rr.Code, rr.Header())
+	}
+}
+
+func TestJWTHandlerESCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A valid ECDSA signature over a SHA-256 digest, with a P-384 key.
+	token := signJWT(t, map[string]interface{}{"alg": "ES256"}, map[string]interface{}{"sub": "bob"}, func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 96)
+		r.FillBytes(sig[:48])
+		s.FillBytes(sig[48:])
+		return sig
+	})
+
+	var gotErr error
+	h := JWTHandler(func(r *http.Request, header JWTHeader) (interface{}, error) {
+		return &key.PublicKey, nil
+	}, JWTAlgorithms("ES256"), JWTErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		gotErr = err
+		w.WriteHeader(http.StatusUnauthorized)
+	}))(okHandler)
+
+	r := newRequest(http.MethodGet, "/")
+	r.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized || !errors.Is(gotErr, ErrJWTUnverifiable) {
+		t.Fatalf("bad response: %v %v", rr.Code, gotErr)
+	}
+}
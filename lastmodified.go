@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type lastModifiedKey struct{}
+
+// lastModifiedState lets CheckLastModified answer the request on behalf of
+// the handler.
+type lastModifiedState struct {
+	w        http.ResponseWriter
+	answered bool
+}
+
+// LastModifiedHandler is HTTP middleware enabling dynamic handlers to answer
+// conditional requests cheaply. Handlers declare when the requested resource
+// was last modified with CheckLastModified as soon as they know, and stop
+// if it reports that the request has been answered, before generating the
+// body.
+//
+// Example:
+//
+//	func ArticleHandler(w http.ResponseWriter, r *http.Request) {
+//		article := loadArticle(r)
+//		if handlers.CheckLastModified(r, article.Updated) {
+//			return
+//		}
+//		renderArticle(w, article)
+//	}
+//
+//	r.Handle("/articles/{id}", handlers.LastModifiedHandler(http.HandlerFunc(ArticleHandler)))
+func LastModifiedHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &lastModifiedState{w: w}
+		discard := func() bool { return state.answered }
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if !discard() {
+						next(code)
+					}
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					if discard() {
+						return len(b), nil
+					}
+					return next(b)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					if discard() {
+						return io.Copy(io.Discard, src)
+					}
+					return next(src)
+				}
+			},
+		})
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), lastModifiedKey{}, state)))
+	})
+}
+
+// CheckLastModified sets the Last-Modified response header to modtime and
+// evaluates the If-Modified-Since and If-Unmodified-Since preconditions of r
+// against it (RFC 9110, section 13). If they fail, it answers the request
+// with a 304 "Not Modified" or a 412 "Precondition Failed", respectively, and
+// returns true: the handler should then return without writing a response,
+// and anything it writes is discarded.
+//
+// CheckLastModified does nothing and returns false if modtime is zero or if r
+// was not passed through LastModifiedHandler.
+func CheckLastModified(r *http.Request, modtime time.Time) bool {
+	state, _ := r.Context().Value(lastModifiedKey{}).(*lastModifiedState)
+	if state == nil || state.answered || modtime.IsZero() || modtime.Equal(time.Unix(0, 0)) {
+		return false
+	}
+	w := state.w
+	// HTTP dates have a resolution of one second.
+	modtime = modtime.Truncate(time.Second)
+	w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+
+	if r.Header.Get("If-Match") == "" {
+		if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modtime.After(t) {
+			state.answered = true
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return true
+		}
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modtime.After(t) {
+		state.answered = true
+		h := w.Header()
+		delete(h, "Content-Type")
+		delete(h, "Content-Length")
+		delete(h, "Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
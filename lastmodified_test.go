@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLastModifiedHandler(t *testing.T) {
+	modtime := time.Date(2023, 5, 1, 12, 0, 0, 500, time.UTC)
+	var generated bool
+	h := LastModifiedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CheckLastModified(r, modtime) {
+			// Writes after the request was answered are discarded.
+			_, _ = w.Write([]byte("ignored"))
+			return
+		}
+		generated = true
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	before := modtime.Add(-time.Hour).Format(http.TimeFormat)
+	at := modtime.Format(http.TimeFormat)
+	tests := []struct {
+		method string
+		header map[string]string
+		code   int
+		gen    bool
+	}{
+		{http.MethodGet, nil, http.StatusOK, true},
+		{http.MethodGet, map[string]string{"If-Modified-Since": at}, http.StatusNotModified, false},
+		{http.MethodHead, map[string]string{"If-Modified-Since": at}, http.StatusNotModified, false},
+		{http.MethodGet, map[string]string{"If-Modified-Since": before}, http.StatusOK, true},
+		{http.MethodGet, map[string]string{"If-Modified-Since": at, "If-None-Match": `"x"`}, http.StatusOK, true},
+		{http.MethodPost, map[string]string{"If-Modified-Since": at}, http.StatusOK, true},
+		{http.MethodPut, map[string]string{"If-Unmodified-Since": before}, http.StatusPreconditionFailed, false},
+		{http.MethodPut, map[string]string{"If-Unmodified-Since": at}, http.StatusOK, true},
+		{http.MethodPut, map[string]string{"If-Unmodified-Since": before, "If-Match": `"x"`}, http.StatusOK, true},
+	}
+	for i, test := range tests {
+		generated = false
+		r := newRequest(test.method, "/")
+		for k, v := range test.header {
+			r.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if rr.Code != test.code || generated != test.gen {
+			t.Fatalf("%d: got %v (generated %v) want %v (generated %v)", i, rr.Code, generated, test.code, test.gen)
+		}
+		if got := rr.Header().Get("Last-Modified"); got != at {
+			t.Fatalf("%d: bad Last-Modified: %q", i, got)
+		}
+		if !test.gen && rr.Body.Len() != 0 {
+			t.Fatalf("%d: unexpected body: %q", i, rr.Body.String())
+		}
+	}
+}
+
+func TestCheckLastModifiedWithoutHandler(t *testing.T) {
+	r := newRequest(http.MethodGet, "/")
+	r.Header.Set("If-Modified-Since", time.Now().Format(http.TimeFormat))
+	if CheckLastModified(r, time.Now().Add(-time.Hour)) {
+		t.Fatal("request answered without LastModifiedHandler")
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultConcurrencyRetryAfter = time.Second
+
+type concurrencyLimiter struct {
+	h            http.Handler
+	max          int
+	queueSize    int
+	queueTimeout time.Duration
+	retryAfter   time.Duration
+	keyFunc      func(*http.Request) string
+
+	mu    sync.Mutex
+	slots map[string]*limiterSlot
+}
+
+// limiterSlot tracks the in-flight and queued requests sharing a key.
+type limiterSlot struct {
+	sem     chan struct{}
+	waiting int
+	refs    int
+}
+
+// ConcurrencyOption provides a functional approach to configure the
+// ConcurrencyLimit middleware.
+type ConcurrencyOption func(*concurrencyLimiter)
+
+// ConcurrencyLimit is HTTP middleware that caps the number of requests being
+// served concurrently by the next handler. Requests beyond the cap are either
+// queued (see ConcurrencyQueue) or rejected immediately with a 503 "Service
+// Unavailable" and a Retry-After header.
+//
+// By default the cap is global. Use ConcurrencyKey to apply it per key
+// instead, e.g. per client IP or per API token.
+//
+// Example:
+//
+//	r := mux.NewRouter()
+//	r.HandleFunc("/report", ExpensiveReportHandler)
+//
+//	limit := handlers.ConcurrencyLimit(16, handlers.ConcurrencyQueue(64, 5*time.Second))
+//	http.ListenAndServe(":8000", limit(r))
+func ConcurrencyLimit(max int, opts ...ConcurrencyOption) func(http.Handler) http.Handler {
+	if max < 1 {
+		max = 1
+	}
+
+	return func(h http.Handler) http.Handler {
+		l := &concurrencyLimiter{
+			h:          h,
+			max:        max,
+			retryAfter: defaultConcurrencyRetryAfter,
+			slots:      make(map[string]*limiterSlot),
+		}
+		for _, option := range opts {
+			option(l)
+		}
+		return l
+	}
+}
+
+// ConcurrencyKey is a functional option that applies the concurrency cap per
+// key as returned by fn, rather than globally.
+func ConcurrencyKey(fn func(*http.Request) string) ConcurrencyOption {
+	return func(l *concurrencyLimiter) {
+		l.keyFunc = fn
+	}
+}
+
+// ConcurrencyQueue is a functional option that lets up to size requests (per
+// key) wait for a free slot instead of being rejected straight away. A waiting
+// request is rejected once timeout elapses, or when its context is done. A
+// timeout of zero waits for as long as the request context allows.
+func ConcurrencyQueue(size int, timeout time.Duration) ConcurrencyOption {
+	return func(l *concurrencyLimiter) {
+		l.queueSize = size
+		l.queueTimeout = timeout
+	}
+}
+
+// ConcurrencyRetryAfter is a functional option that sets the delay advertised
+// to rejected clients via the Retry-After header. It defaults to one second.
+func ConcurrencyRetryAfter(d time.Duration) ConcurrencyOption {
+	return func(l *concurrencyLimiter) {
+		l.retryAfter = d
+	}
+}
+
+func (l *concurrencyLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key string
+	if l.keyFunc != nil {
+		key = l.keyFunc(r)
+	}
+
+	slot := l.acquire(key)
+	defer l.release(key, slot)
+
+	select {
+	case slot.sem <- struct{}{}:
+	default:
+		if !l.enqueue(slot) || !l.wait(r.Context(), slot) {
+			setRetryAfter(w.Header(), l.retryAfter)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	defer func() { <-slot.sem }()
+
+	l.h.ServeHTTP(w, r)
+}
+
+// acquire returns the slot for key, creating it if necessary.
+func (l *concurrencyLimiter) acquire(key string) *limiterSlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot, ok := l.slots[key]
+	if !ok {
+		slot = &limiterSlot{sem: make(chan struct{}, l.max)}
+		l.slots[key] = slot
+	}
+	slot.refs++
+	return slot
+}
+
+// release drops a reference to the slot for key, forgetting the slot once no
+// request refers to it anymore.
+func (l *concurrencyLimiter) release(key string, slot *limiterSlot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot.refs--
+	if slot.refs == 0 {
+		delete(l.slots, key)
+	}
+}
+
+// enqueue reserves a place in the wait queue of slot, reporting whether the
+// queue had room.
+func (l *concurrencyLimiter) enqueue(slot *limiterSlot) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if slot.waiting >= l.queueSize {
+		return false
+	}
+	slot.waiting++
+	return true
+}
+
+// wait blocks until a place in slot frees up, reporting whether one was
+// obtained before the queue timeout or the end of ctx.
+func (l *concurrencyLimiter) wait(ctx context.Context, slot *limiterSlot) bool {
+	defer func() {
+		l.mu.Lock()
+		slot.waiting--
+		l.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		t := time.NewTimer(l.queueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case slot.sem <- struct{}{}:
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingHandler returns a handler that signals on started when a request
+// enters it and blocks until release is closed.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+}
+
+func TestConcurrencyLimitRejects(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	h := ConcurrencyLimit(1, ConcurrencyRetryAfter(1500*time.Millisecond))(blockingHandler(started, release))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("bad Retry-After: got %q want %q", got, "2")
+	}
+
+	close(release)
+	wg.Wait()
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	h := ConcurrencyLimit(1, ConcurrencyQueue(1, time.Second))(blockingHandler(started, release))
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+			codes <- rr.Code
+		}()
+	}
+	<-started
+
+	// The second request is queued; release both.
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Fatalf("bad status: got %v want %v", code, http.StatusOK)
+		}
+	}
+}
+
+func TestConcurrencyLimitQueueTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	h := ConcurrencyLimit(1, ConcurrencyQueue(1, 10*time.Millisecond))(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	<-started
+	defer close(release)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestConcurrencyLimitPerKey(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	h := ConcurrencyLimit(1, ConcurrencyKey(func(r *http.Request) string {
+		return r.URL.Path
+	}))(blockingHandler(started, release))
+
+	go h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/a"))
+	<-started
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "/b"))
+		done <- rr.Code
+	}()
+	<-started
+	close(release)
+
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("bad status for independent key: got %v want %v", code, http.StatusOK)
+	}
+}
@@ -5,26 +5,104 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
-
-	"github.com/felixge/httpsnoop"
 )
 
 // Logging
 
 // LogFormatterParams is the structure any formatter will be handed when time to log comes.
 type LogFormatterParams struct {
+	// Request is the logged request. With LogForwardedFor, its RemoteAddr is
+	// the address of the client rather than that of the proxy.
 	Request    *http.Request
 	URL        url.URL
 	TimeStamp  time.Time
 	StatusCode int
-	Size       int
+	// Size is the number of response body bytes written through the logging
+	// handler, which are compressed if a CompressHandler is wrapped by the
+	// logging handler, and uncompressed if it wraps the logging handler.
+	Size int
+	// WireSize and UncompressedSize are the number of response body bytes
+	// after and before compression by a CompressHandler, whichever wraps the
+	// other. Both are equal to Size if the response isn't compressed.
+	WireSize         int
+	UncompressedSize int
+	// RequestID is the ID assigned to the request by RequestIDHandler, or
+	// taken from the sources set with LogRequestIDHeader and
+	// LogRequestIDContextKey, if any.
+	RequestID string
+	// VirtualHost is the host pattern matched by a HostSwitch, or the
+	// requested host without its port.
+	VirtualHost string
+	// TraceID and SpanID are the hex encoded IDs of the trace and of the
+	// calling span propagated with the W3C traceparent header or the B3
+	// headers, if any.
+	TraceID string
+	SpanID  string
+	// RequestHeaders are the request headers selected with LogRequestHeaders,
+	// LogRedactHeaders and LogHashHeaders, if any.
+	RequestHeaders http.Header
+	// Duration is the time taken to serve the request or, for hijacked
+	// connections, the time until the connection was closed.
+	Duration time.Duration
+	// QueueLatency is the time the request spent queued in front of the
+	// server, from the time at which an upstream proxy received it according
+	// to the X-Request-Start or X-Queue-Start header until the logging handler
+	// received it, or 0 if unknown.
+	QueueLatency time.Duration
+	// RequestBody and ResponseBody are the first bytes of the request body
+	// read by the handler and of the response body, captured with
+	// LogCaptureBodies for failed or slow requests only.
+	RequestBody  []byte
+	ResponseBody []byte
+	// Hijacked reports whether the handler hijacked the connection, e.g. for
+	// a WebSocket. Hijacked connections are logged once they are closed, with
+	// BytesRead and BytesWritten set to the number of bytes transferred in
+	// each direction after the connection was hijacked, and Size including
+	// BytesWritten.
+	Hijacked     bool
+	BytesRead    int64
+	BytesWritten int64
+	// RoutePattern is the route template matched by the request, such as
+	// "/users/{id}", as recorded with SetRoutePattern or returned by the
+	// function set with LogRoutePattern, if any.
+	RoutePattern string
+	// Error is the error attached to the request with SetLogError, if any.
+	Error error
+	// ContentType is the Content-Type of the response, if any.
+	ContentType string
+	// CacheStatus is the cache status of the response, such as "HIT" or
+	// "MISS", as set with SetCacheStatus by a caching layer such as
+	// ResponseCache, if any.
+	CacheStatus string
+	// Protocol is the protocol of the request as an ALPN protocol ID, such as
+	// "http/1.1", "h2" or "h3", negotiated with TLS or else derived from the
+	// protocol version, with "h2c" for HTTP/2 over cleartext.
+	Protocol string
+	// Trailer holds the response trailers, if any.
+	Trailer http.Header
+	// ResponseHeader is the response header as it was sent, e.g. to log its
+	// Content-Type or Cache-Control.
+	ResponseHeader http.Header
 }
 
 // LogFormatter gives the signature of the formatter function passed to CustomLoggingHandler.
@@ -34,15 +112,590 @@ type LogFormatter func(writer io.Writer, params LogFormatterParams)
 // friends
 
 type loggingHandler struct {
-	writer    io.Writer
-	handler   http.Handler
-	formatter LogFormatter
+	writer          io.Writer
+	handler         http.Handler
+	formatter       LogFormatter
+	requestIDHeader string
+	requestIDKey    interface{}
+	trustedProxies  []netip.Prefix
+	headers         map[string]logHeaderMode
+	redactParams    []string
+	route           func(*http.Request) string
+	classWriters    [6]io.Writer
+	skip            func(*http.Request) bool
+	clock           func() time.Time
+	sampleRate      float64
+	captureLimit    int
+	captureSlow     time.Duration
+	basicAuthUser   bool
+	userKey         interface{}
+}
+
+// logHeaderMode is the way a logged request header is written.
+type logHeaderMode int
+
+const (
+	logHeaderPlain logHeaderMode = iota
+	logHeaderRedact
+	logHeaderHash
+)
+
+// LoggingOption provides a functional approach to configure the logging
+// handlers created with Logging and LoggingHandlerWithOptions.
+type LoggingOption func(*loggingHandler)
+
+func newLoggingHandler(out io.Writer, h http.Handler, f LogFormatter, opts []LoggingOption) http.Handler {
+	l := &loggingHandler{writer: out, handler: h, formatter: f, clock: time.Now, sampleRate: 1}
+	for _, option := range opts {
+		option(l)
+	}
+	return l
+}
+
+// LogRequestIDHeader is a functional option that takes the request ID of
+// LogFormatterParams from the given request header, e.g. a correlation ID
+// set by a proxy, when it is present. The ID assigned by RequestIDHandler is
+// used otherwise.
+func LogRequestIDHeader(name string) LoggingOption {
+	return func(l *loggingHandler) {
+		l.requestIDHeader = name
+	}
+}
+
+// LogRequestIDContextKey is a functional option that takes the request ID of
+// LogFormatterParams from the value for key in the request context, which
+// must be a string or a fmt.Stringer, when it is present. Since handlers can't
+// change the context of their callers, the value must be set by a handler
+// wrapping the logging handler. The ID assigned by RequestIDHandler is used
+// otherwise.
+func LogRequestIDContextKey(key interface{}) LoggingOption {
+	return func(l *loggingHandler) {
+		l.requestIDKey = key
+	}
+}
+
+// LogForwardedFor is a functional option that logs the address of the client
+// found in the X-Forwarded-For or, failing that, the Forwarded headers instead
+// of the address of the proxy in RemoteAddr. Unlike wrapping the logging
+// handler in ProxyHeaders, the headers are only used if the request comes
+// from one of the trustedProxies, and the client is the address before the
+// last trusted proxy in the chain, so that clients can't spoof their address
+// by sending the headers themselves.
+//
+// trustedProxies are IP ranges in CIDR notation, or single IP addresses.
+// LogForwardedFor panics if any of them fails to parse.
+//
+// Example:
+//
+//	handlers.Logging(os.Stdout, handlers.LogForwardedFor([]string{"10.0.0.0/8"}))(r)
+func LogForwardedFor(trustedProxies []string) LoggingOption {
+	prefixes, err := parsePrefixes(trustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	return func(l *loggingHandler) {
+		l.trustedProxies = prefixes
+	}
+}
+
+// LogRequestHeaders is a functional option that adds the given request
+// headers, e.g. X-Api-Client or Accept-Language, to the RequestHeaders of
+// LogFormatterParams, which are written by JSONLogFormatter.
+func LogRequestHeaders(names ...string) LoggingOption {
+	return logHeaders(names, logHeaderPlain)
+}
+
+// LogRedactHeaders is a functional option that adds the given request headers
+// to the RequestHeaders of LogFormatterParams with their values replaced by
+// "[REDACTED]", so that logs show whether they were sent but not their
+// values.
+func LogRedactHeaders(names ...string) LoggingOption {
+	return logHeaders(names, logHeaderRedact)
+}
+
+// LogHashHeaders is a functional option that adds the given request headers,
+// e.g. Authorization or Cookie, to the RequestHeaders of LogFormatterParams
+// with their values replaced by "sha256:" followed by the first 16 hex digits
+// of their SHA-256 hash, so that requests with the same credentials can be
+// correlated without logging them. Unsalted hashes of guessable values can be
+// reversed by brute force, prefer LogRedactHeaders for these.
+func LogHashHeaders(names ...string) LoggingOption {
+	return logHeaders(names, logHeaderHash)
+}
+
+func logHeaders(names []string, mode logHeaderMode) LoggingOption {
+	return func(l *loggingHandler) {
+		if l.headers == nil {
+			l.headers = make(map[string]logHeaderMode, len(names))
+		}
+		for _, name := range names {
+			l.headers[http.CanonicalHeaderKey(name)] = mode
+		}
+	}
+}
+
+// LogRedactQueryParams is a functional option that replaces the values of the
+// given query parameters, e.g. token, password or api_key, with "[REDACTED]"
+// in the logged request line and URL, so that secrets passed in URLs don't end
+// up in access logs. Parameter names are matched case-insensitively.
+func LogRedactQueryParams(names ...string) LoggingOption {
+	return func(l *loggingHandler) {
+		l.redactParams = append(l.redactParams, names...)
+	}
+}
+
+// redactQuery returns the raw query q with the values of the parameters
+// configured for h redacted.
+func (h *loggingHandler) redactQuery(q string) string {
+	if q == "" {
+		return q
+	}
+	parts := strings.Split(q, "&")
+	redacted := false
+	for i, part := range parts {
+		key, _, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		for _, name := range h.redactParams {
+			if strings.EqualFold(key, name) {
+				parts[i] = part[:strings.IndexByte(part, '=')+1] + "[REDACTED]"
+				redacted = true
+				break
+			}
+		}
+	}
+	if !redacted {
+		return q
+	}
+	return strings.Join(parts, "&")
+}
+
+// requestHeaders returns the request headers of req to be logged, as
+// configured for h.
+func (h *loggingHandler) requestHeaders(req *http.Request) http.Header {
+	if len(h.headers) == 0 {
+		return nil
+	}
+	header := make(http.Header, len(h.headers))
+	for name, mode := range h.headers {
+		values := req.Header[name]
+		if len(values) == 0 {
+			continue
+		}
+		logged := make([]string, len(values))
+		for i, v := range values {
+			switch mode {
+			case logHeaderRedact:
+				logged[i] = "[REDACTED]"
+			case logHeaderHash:
+				sum := sha256.Sum256([]byte(v))
+				logged[i] = "sha256:" + hex.EncodeToString(sum[:8])
+			default:
+				logged[i] = v
+			}
+		}
+		header[name] = logged
+	}
+	return header
+}
+
+// trusted reports whether ip is the address of a trusted proxy.
+func (h *loggingHandler) trusted(ip netip.Addr) bool {
+	for _, p := range h.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client which sent req through the
+// trusted proxies, or "" if req doesn't come from a trusted proxy.
+func (h *loggingHandler) clientAddr(req *http.Request) string {
+	ip, ok := remoteIP(req)
+	if !ok || !h.trusted(ip) {
+		return ""
+	}
+
+	hops := forwardedForHops(req.Header)
+	client := ip
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			// Obfuscated or malformed identifiers can't be trusted to be the
+			// client, so stop at the last known address.
+			break
+		}
+		client = hop
+		if !h.trusted(hop) {
+			break
+		}
+	}
+	if client == ip {
+		return ""
+	}
+	return client.String()
+}
+
+// forwardedForHops returns the addresses listed in the X-Forwarded-For
+// headers, or in the for parameters of the Forwarded headers if there are
+// none, from the client to the last proxy.
+func forwardedForHops(header http.Header) []string {
+	var hops []string
+	for _, v := range header.Values(xForwardedFor) {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+	for _, v := range header.Values(forwarded) {
+		for _, match := range forRegex.FindAllStringSubmatch(v, -1) {
+			hops = append(hops, match[1])
+		}
+	}
+	return hops
+}
+
+// parseForwardedAddr parses an address of the X-Forwarded-For or Forwarded
+// headers, which may be quoted, bracketed, or carry a port.
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// LogStatusClassWriter is a functional option that writes the log entries of
+// responses in the given status class, 1 to 5 for 1xx to 5xx, to w instead of
+// the writer of the logging handler. For instance, this splits the access and
+// error logs the way Apache does:
+//
+//	h := handlers.Logging(accessLog,
+//		handlers.LogFormat(handlers.CombinedLogFormatter),
+//		handlers.LogStatusClassWriter(4, errorLog),
+//		handlers.LogStatusClassWriter(5, errorLog),
+//	)(r)
+//
+// It has no effect on the outputs of MultiLoggingHandler, which have writers
+// of their own.
+func LogStatusClassWriter(class int, w io.Writer) LoggingOption {
+	return func(l *loggingHandler) {
+		if class >= 1 && class <= 5 {
+			l.classWriters[class] = w
+		}
+	}
+}
+
+// writerFor returns the writer of the log entry of a response with the given
+// status, as configured for h.
+func (h *loggingHandler) writerFor(status int) io.Writer {
+	if class := status / 100; class >= 1 && class <= 5 && h.classWriters[class] != nil {
+		return h.classWriters[class]
+	}
+	return h.writer
+}
+
+// LogSkip is a functional option that sets a predicate for requests which
+// are not logged, e.g. health checks.
+func LogSkip(fn func(*http.Request) bool) LoggingOption {
+	return func(l *loggingHandler) {
+		l.skip = fn
+	}
+}
+
+// LogClock is a functional option that sets the function returning the
+// current time, which is time.Now by default, e.g. to log timestamps in UTC
+// or to control them in tests.
+func LogClock(now func() time.Time) LoggingOption {
+	return func(l *loggingHandler) {
+		if now != nil {
+			l.clock = now
+		}
+	}
+}
+
+// LogSampleRate is a functional option that only logs the given fraction of
+// the requests, chosen at random, to reduce the volume of logs of busy
+// servers. Responses with a 5xx status are always logged. Rates outside of
+// (0, 1) log every request.
+func LogSampleRate(rate float64) LoggingOption {
+	return func(l *loggingHandler) {
+		if rate <= 0 || rate >= 1 {
+			rate = 1
+		}
+		l.sampleRate = rate
+	}
+}
+
+// sampled reports whether the request with a response of the given status is
+// logged, as configured for h.
+func (h *loggingHandler) sampled(status int) bool {
+	return h.sampleRate >= 1 || status >= 500 || rand.Float64() < h.sampleRate
+}
+
+// LogCaptureBodies is a functional option that captures the first limit bytes
+// of the request and response bodies, and passes them to the formatter as the
+// RequestBody and ResponseBody of LogFormatterParams when the response has a
+// 5xx status or, if slow is positive, when the request took at least slow to
+// serve. This helps debugging failures without logging bodies all the time.
+// JSONLogFormatter writes the captured bodies.
+//
+// The bodies may contain credentials and personal data, which end up in the
+// logs.
+func LogCaptureBodies(limit int, slow time.Duration) LoggingOption {
+	return func(l *loggingHandler) {
+		l.captureLimit = limit
+		l.captureSlow = slow
+	}
+}
+
+// verbose reports whether the bodies captured for a request with the given
+// status and duration are logged, as configured for h.
+func (h *loggingHandler) verbose(status int, d time.Duration) bool {
+	return status >= 500 || (h.captureSlow > 0 && d >= h.captureSlow)
+}
+
+// LogRoutePattern is a functional option that sets the function returning the
+// route template matched by the request, such as "/users/{id}", for the
+// RoutePattern of LogFormatterParams. It is called once the request has been
+// served, with the request as seen by the logging handler, so it only works if
+// the router makes the route available there. Otherwise, have the router
+// record the route with SetRoutePattern.
+func LogRoutePattern(fn func(*http.Request) string) LoggingOption {
+	return func(l *loggingHandler) {
+		l.route = fn
+	}
+}
+
+// SetRoutePattern records pattern as the route template matched by r, such as
+// "/users/{id}", for the logging handlers wrapping the handler serving r,
+// which pass it to formatters as the RoutePattern of LogFormatterParams.
+// Logging route templates rather than paths keeps the cardinality of log
+// aggregations low when paths contain IDs. It does nothing if r isn't served
+// through a logging handler.
+//
+// Example, with gorilla/mux:
+//
+//	r := mux.NewRouter()
+//	r.Use(func(next http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+//			if tmpl, err := mux.CurrentRoute(req).GetPathTemplate(); err == nil {
+//				handlers.SetRoutePattern(req, tmpl)
+//			}
+//			next.ServeHTTP(w, req)
+//		})
+//	})
+//	http.ListenAndServe(":8000", handlers.LoggingHandler(os.Stdout, r))
+func SetRoutePattern(r *http.Request, pattern string) {
+	if rec := requestRecordFromContext(r.Context()); rec != nil {
+		rec.setRoute(pattern)
+	}
+}
+
+// requestProtocol returns the ALPN protocol ID of the protocol of req.
+func requestProtocol(req *http.Request) string {
+	if req.TLS != nil && req.TLS.NegotiatedProtocol != "" {
+		return req.TLS.NegotiatedProtocol
+	}
+	switch {
+	case req.ProtoMajor == 3:
+		return "h3"
+	case req.ProtoMajor == 2 && req.TLS == nil:
+		return "h2c"
+	case req.ProtoMajor == 2:
+		return "h2"
+	case req.ProtoMajor == 1 && req.ProtoMinor == 0:
+		return "http/1.0"
+	case req.ProtoMajor == 1:
+		return "http/1.1"
+	default:
+		return strings.ToLower(req.Proto)
+	}
+}
+
+// queueLatency returns the time elapsed between the time found in the
+// X-Request-Start or X-Queue-Start header and start, or 0 if there is none.
+// Proxies set these headers to their time of receipt, like nginx with
+// "t=${msec}" in seconds, or Heroku in milliseconds since the epoch. Both
+// formats and the units of seconds to nanoseconds are told apart by
+// magnitude.
+func queueLatency(header http.Header, start time.Time) time.Duration {
+	v := header.Get("X-Request-Start")
+	if v == "" {
+		v = header.Get("X-Queue-Start")
+	}
+	v = strings.TrimPrefix(strings.TrimSpace(v), "t=")
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+
+	var received time.Time
+	switch {
+	case f > 1e17:
+		received = time.Unix(0, int64(f))
+	case f > 1e14:
+		received = time.UnixMicro(int64(f))
+	case f > 1e11:
+		received = time.UnixMicro(int64(f * 1e3))
+	default:
+		received = time.UnixMicro(int64(f * 1e6))
+	}
+	if latency := start.Sub(received); latency > 0 {
+		return latency
+	}
+	// The clocks of the proxy and the server are skewed.
+	return 0
+}
+
+// SetLogError attaches err to the request whose context is ctx, for the
+// logging handlers serving it, which pass it to formatters as the Error of
+// LogFormatterParams, so that the cause of a failure appears on the same line
+// as the request in access logs. It does nothing if the request isn't served
+// through a logging handler.
+//
+// Example:
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		if err := db.Ping(r.Context()); err != nil {
+//			handlers.SetLogError(r.Context(), err)
+//			http.Error(w, "database unavailable", http.StatusInternalServerError)
+//			return
+//		}
+//		...
+//	}
+func SetLogError(ctx context.Context, err error) {
+	if rec := requestRecordFromContext(ctx); rec != nil {
+		rec.setError(err)
+	}
+}
+
+// SetCacheStatus sets the cache status of the response to the request whose
+// context is ctx, such as "HIT", "MISS" or "STALE", for the logging handlers
+// serving it, which pass it to formatters as the CacheStatus of
+// LogFormatterParams. ResponseCache sets it for the requests it handles. It
+// does nothing if the request isn't served through a logging handler.
+func SetCacheStatus(ctx context.Context, status string) {
+	if rec := requestRecordFromContext(ctx); rec != nil {
+		rec.setCacheStatus(status)
+	}
+}
+
+// routePattern returns the route template matched by req, as configured for
+// h.
+func (h *loggingHandler) routePattern(req *http.Request, rec *requestRecord) string {
+	if h.route != nil {
+		if route := h.route(req); route != "" {
+			return route
+		}
+	}
+	return rec.routePattern()
+}
+
+// LogBasicAuthUser is a functional option that logs the user name of the
+// Authorization header of requests using HTTP basic authentication, never the
+// password, as the authenticated user, the %u field of the Common Log Format.
+// Like Apache, it logs the user name even if authentication failed, which the
+// status of the response tells.
+func LogBasicAuthUser() LoggingOption {
+	return func(l *loggingHandler) {
+		l.basicAuthUser = true
+	}
+}
+
+// LogUserContextKey is a functional option that logs the value for key in
+// the request context, which must be a string or a fmt.Stringer, as the
+// authenticated user, the %u field of the Common Log Format. Since handlers
+// can't change the context of their callers, the value must be set by a
+// handler wrapping the logging handler. It takes precedence over
+// LogBasicAuthUser.
+func LogUserContextKey(key interface{}) LoggingOption {
+	return func(l *loggingHandler) {
+		l.userKey = key
+	}
+}
+
+// user returns the authenticated user of req, as configured for h, or nil if
+// unknown.
+func (h *loggingHandler) user(req *http.Request) *url.Userinfo {
+	if h.userKey != nil {
+		switch user := req.Context().Value(h.userKey).(type) {
+		case string:
+			if user != "" {
+				return url.User(user)
+			}
+		case fmt.Stringer:
+			return url.User(user.String())
+		}
+	}
+	if h.basicAuthUser {
+		if user, _, ok := req.BasicAuth(); ok && user != "" {
+			return url.User(user)
+		}
+	}
+	return nil
+}
+
+// requestID returns the request ID of req, as configured for h.
+func (h *loggingHandler) requestID(req *http.Request, rec *requestRecord) string {
+	if h.requestIDHeader != "" {
+		if id := req.Header.Get(h.requestIDHeader); id != "" {
+			return id
+		}
+	}
+	if h.requestIDKey != nil {
+		switch id := req.Context().Value(h.requestIDKey).(type) {
+		case string:
+			if id != "" {
+				return id
+			}
+		case fmt.Stringer:
+			return id.String()
+		}
+	}
+	return rec.requestID(req)
 }
 
-func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	t := time.Now()
+func (h *loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.skip != nil && h.skip(req) {
+		h.handler.ServeHTTP(w, req)
+		return
+	}
+
+	t := h.clock()
 	logger, w := makeLogger(w)
+	logger.captureHeader = true
+	logger.trackHijack = true
 	url := *req.URL
+	req, rec := withRequestRecord(req)
+
+	var reqBody *dumpCapture
+	if h.captureLimit > 0 {
+		logger.body = &dumpCapture{limit: h.captureLimit}
+		if req.Body != nil && req.Body != http.NoBody {
+			reqBody = &dumpCapture{limit: h.captureLimit}
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(req.Body, reqBody), req.Body}
+		}
+	}
 
 	h.handler.ServeHTTP(w, req)
 	if req.MultipartForm != nil {
@@ -55,28 +708,159 @@ func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if url.User != req.URL.User {
 		url.User = req.URL.User
 	}
+	if url.User == nil {
+		url.User = h.user(req)
+	}
 
+	logged := req
+	if len(h.trustedProxies) > 0 {
+		if addr := h.clientAddr(req); addr != "" {
+			r := *req
+			r.RemoteAddr = addr
+			logged = &r
+		}
+	}
+	if len(h.redactParams) > 0 {
+		url.RawQuery = h.redactQuery(url.RawQuery)
+		if path, query, ok := strings.Cut(logged.RequestURI, "?"); ok {
+			if redacted := h.redactQuery(query); redacted != query {
+				r := *logged
+				r.RequestURI = path + "?" + redacted
+				logged = &r
+			}
+		}
+	}
+
+	size := logger.Size()
+	wireSize, uncompressedSize := size, size
+	if stats := rec.compressionStats(); stats != nil {
+		stats.finish()
+		wireSize, uncompressedSize = int(stats.wire.Load()), int(stats.uncompressed.Load())
+	}
+
+	traceID, spanID := requestTraceIDs(req.Header)
 	params := LogFormatterParams{
-		Request:    req,
-		URL:        url,
-		TimeStamp:  t,
-		StatusCode: logger.Status(),
-		Size:       logger.Size(),
+		Request:          logged,
+		URL:              url,
+		TimeStamp:        t,
+		StatusCode:       logger.Status(),
+		Size:             size,
+		WireSize:         wireSize,
+		UncompressedSize: uncompressedSize,
+		RequestID:        h.requestID(req, rec),
+		VirtualHost:      rec.virtualHost(req),
+		TraceID:          traceID,
+		SpanID:           spanID,
+		RequestHeaders:   h.requestHeaders(req),
+		RoutePattern:     h.routePattern(req, rec),
+		Error:            rec.error(),
+		CacheStatus:      rec.cacheStatus(),
+		ResponseHeader:   logger.sentHeader(),
+		Duration:         h.clock().Sub(t),
+		QueueLatency:     queueLatency(req.Header, t),
+		Protocol:         requestProtocol(req),
+		Trailer:          logger.trailer(),
+	}
+	params.ContentType = params.ResponseHeader.Get("Content-Type")
+	if logger.body != nil && h.verbose(params.StatusCode, params.Duration) {
+		params.ResponseBody = logger.body.buf.Bytes()
+		if reqBody != nil {
+			params.RequestBody = reqBody.buf.Bytes()
+		}
 	}
 
-	h.formatter(h.writer, params)
+	if conn := logger.conn; conn != nil {
+		// Log hijacked connections once they are closed, with the bytes
+		// transferred in each direction.
+		conn.onClose(func(read, written int64) {
+			params.Hijacked = true
+			params.Duration = h.clock().Sub(t)
+			params.BytesRead = read
+			params.BytesWritten = written
+			params.Size = size + int(written)
+			params.WireSize, params.UncompressedSize = params.Size, params.Size
+			if h.sampled(params.StatusCode) {
+				h.formatter(h.writerFor(params.StatusCode), params)
+			}
+		})
+		return
+	}
+	if h.sampled(params.StatusCode) {
+		h.formatter(h.writerFor(params.StatusCode), params)
+	}
 }
 
-func makeLogger(w http.ResponseWriter) (*responseLogger, http.ResponseWriter) {
-	logger := &responseLogger{w: w, status: http.StatusOK}
-	return logger, httpsnoop.Wrap(w, httpsnoop.Hooks{
-		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
-			return logger.Write
-		},
-		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
-			return logger.WriteHeader
-		},
-	})
+func makeLogger(w http.ResponseWriter) (*ResponseRecorder, http.ResponseWriter) {
+	return NewResponseRecorder(w)
+}
+
+// loggedConn is a hijacked connection counting the bytes transferred, which
+// calls a function once it is closed and the handler which hijacked it has
+// returned.
+type loggedConn struct {
+	net.Conn
+	rw            *bufio.ReadWriter
+	read, written atomic.Int64
+
+	mu     sync.Mutex
+	closed bool
+	log    func(read, written int64)
+}
+
+// newLoggedConn wraps conn and rw as returned by http.Hijacker. The bytes
+// buffered by rw when the connection was hijacked are counted as read.
+func newLoggedConn(conn net.Conn, rw *bufio.ReadWriter) *loggedConn {
+	c := &loggedConn{Conn: conn}
+	var r io.Reader = c
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		r = io.MultiReader(bytes.NewReader(append([]byte(nil), buffered...)), c)
+		c.read.Add(int64(n))
+	}
+	c.rw = bufio.NewReadWriter(bufio.NewReader(r), bufio.NewWriter(c))
+	return c
+}
+
+func (c *loggedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *loggedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *loggedConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.Conn.Close()
+	}
+	c.closed = true
+	log := c.log
+	c.mu.Unlock()
+
+	err := c.Conn.Close()
+	if log != nil {
+		log(c.read.Load(), c.written.Load())
+	}
+	return err
+}
+
+// onClose arranges for log to be called once c is closed, or right away if it
+// already is.
+func (c *loggedConn) onClose(log func(read, written int64)) {
+	c.mu.Lock()
+	if !c.closed {
+		c.log = log
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	log(c.read.Load(), c.written.Load())
 }
 
 const lowerhex = "0123456789abcdef"
@@ -206,12 +990,271 @@ func writeLog(writer io.Writer, params LogFormatterParams) {
 // status and size are used to provide the response HTTP status and size.
 func writeCombinedLog(writer io.Writer, params LogFormatterParams) {
 	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendRefererAndUserAgent(buf, params.Request)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// CommonLogFormatter is the LogFormatter of LoggingHandler, writing log
+// entries in Apache Common Log Format.
+func CommonLogFormatter(writer io.Writer, params LogFormatterParams) {
+	writeLog(writer, params)
+}
+
+// CombinedLogFormatter is the LogFormatter of CombinedLoggingHandler, writing
+// log entries in Apache Combined Log Format.
+func CombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	writeCombinedLog(writer, params)
+}
+
+// VHostCombinedLogFormatter is a LogFormatter writing log entries in Apache
+// Combined Log Format prefixed with the virtual host, like the vhost_combined
+// format of Apache, for servers dispatching on hosts with HostSwitch.
+func VHostCombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	vhost := params.VirtualHost
+	if vhost == "" {
+		vhost = "-"
+	}
+	buf := append([]byte(vhost), ' ')
+	buf = append(buf, buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)...)
+	buf = appendRefererAndUserAgent(buf, params.Request)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// RequestIDLogFormatter is a LogFormatter writing log entries in Apache Common
+// Log Format followed by the quoted request ID, or "-" if there is none, so
+// that access log entries can be joined with application logs.
+func RequestIDLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendQuotedField(buf, params.RequestID)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// RequestIDCombinedLogFormatter is a LogFormatter writing log entries in
+// Apache Combined Log Format followed by the quoted request ID, or "-" if
+// there is none.
+func RequestIDCombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendRefererAndUserAgent(buf, params.Request)
+	buf = appendQuotedField(buf, params.RequestID)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// TraceLogFormatter is a LogFormatter writing log entries in Apache Common Log
+// Format followed by the trace and span IDs, or "-" if there are none, so
+// that access log entries can be correlated with distributed traces.
+func TraceLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendTraceIDs(buf, params.TraceID, params.SpanID)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// TraceCombinedLogFormatter is a LogFormatter writing log entries in Apache
+// Combined Log Format followed by the trace and span IDs, or "-" if there are
+// none.
+func TraceCombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendRefererAndUserAgent(buf, params.Request)
+	buf = appendTraceIDs(buf, params.TraceID, params.SpanID)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// jsonLogEntry is a log entry written by JSONLogFormatter.
+type jsonLogEntry struct {
+	Time             string      `json:"time"`
+	RemoteAddr       string      `json:"remote_addr"`
+	User             string      `json:"user,omitempty"`
+	Method           string      `json:"method"`
+	URI              string      `json:"uri"`
+	Proto            string      `json:"proto"`
+	Protocol         string      `json:"protocol,omitempty"`
+	Status           int         `json:"status"`
+	Size             int         `json:"size"`
+	WireSize         int         `json:"wire_size"`
+	UncompressedSize int         `json:"uncompressed_size"`
+	Referer          string      `json:"referer,omitempty"`
+	UserAgent        string      `json:"user_agent,omitempty"`
+	RequestID        string      `json:"request_id,omitempty"`
+	VirtualHost      string      `json:"vhost,omitempty"`
+	RoutePattern     string      `json:"route,omitempty"`
+	Duration         float64     `json:"duration"`
+	QueueLatency     float64     `json:"queue_latency,omitempty"`
+	Hijacked         bool        `json:"hijacked,omitempty"`
+	BytesRead        int64       `json:"bytes_read,omitempty"`
+	BytesWritten     int64       `json:"bytes_written,omitempty"`
+	Error            string      `json:"error,omitempty"`
+	ContentType      string      `json:"content_type,omitempty"`
+	CacheStatus      string      `json:"cache_status,omitempty"`
+	RequestBody      string      `json:"request_body,omitempty"`
+	ResponseBody     string      `json:"response_body,omitempty"`
+	TraceID          string      `json:"trace_id,omitempty"`
+	SpanID           string      `json:"span_id,omitempty"`
+	RequestHeaders   http.Header `json:"request_headers,omitempty"`
+	Trailer          http.Header `json:"trailers,omitempty"`
+}
+
+// JSONLogFormatter is a LogFormatter writing log entries as JSON objects, one
+// per line, for log pipelines which parse structured logs. Besides the fields
+// of the Apache Combined Log Format, entries include the negotiated protocol
+// and the duration of the request in seconds, and when they are set, the
+// request ID, virtual host, route pattern, trace IDs, queue latency, logged
+// request headers, content type, cache status and trailers of the response,
+// the error attached with SetLogError and the bodies captured with
+// LogCaptureBodies.
+func JSONLogFormatter(writer io.Writer, params LogFormatterParams) {
+	req := params.Request
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	uri := req.RequestURI
+	if req.ProtoMajor == 2 && req.Method == "CONNECT" {
+		uri = req.Host
+	}
+	if uri == "" {
+		uri = params.URL.RequestURI()
+	}
+
+	entry := jsonLogEntry{
+		Time:             params.TimeStamp.Format(time.RFC3339Nano),
+		RemoteAddr:       host,
+		Method:           req.Method,
+		URI:              uri,
+		Proto:            req.Proto,
+		Status:           params.StatusCode,
+		Size:             params.Size,
+		WireSize:         params.WireSize,
+		UncompressedSize: params.UncompressedSize,
+		Referer:          req.Referer(),
+		UserAgent:        req.UserAgent(),
+		RequestID:        params.RequestID,
+		VirtualHost:      params.VirtualHost,
+		RoutePattern:     params.RoutePattern,
+		Duration:         params.Duration.Seconds(),
+		QueueLatency:     params.QueueLatency.Seconds(),
+		Hijacked:         params.Hijacked,
+		BytesRead:        params.BytesRead,
+		BytesWritten:     params.BytesWritten,
+		ContentType:      params.ContentType,
+		CacheStatus:      params.CacheStatus,
+		RequestBody:      string(params.RequestBody),
+		ResponseBody:     string(params.ResponseBody),
+		TraceID:          params.TraceID,
+		SpanID:           params.SpanID,
+		RequestHeaders:   params.RequestHeaders,
+		Protocol:         params.Protocol,
+		Trailer:          params.Trailer,
+	}
+	if params.URL.User != nil {
+		entry.User = params.URL.User.Username()
+	}
+	if params.Error != nil {
+		entry.Error = params.Error.Error()
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// CacheCombinedLogFormatter is a LogFormatter writing log entries in Apache
+// Combined Log Format followed by the quoted Content-Type and the cache status
+// of the response, or "-" if they are unknown, in the style of the logs of
+// CDNs and their origin servers.
+func CacheCombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendRefererAndUserAgent(buf, params.Request)
+	buf = appendQuotedField(buf, params.ContentType)
+	buf = appendQuotedField(buf, params.CacheStatus)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// QueueCombinedLogFormatter is a LogFormatter writing log entries in Apache
+// Combined Log Format followed by the queue latency of the request in
+// milliseconds, or "-" if it is unknown, for monitoring request queueing in
+// front of the server.
+func QueueCombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendRefererAndUserAgent(buf, params.Request)
+	if params.QueueLatency > 0 {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, params.QueueLatency.Milliseconds(), 10)
+	} else {
+		buf = append(buf, " -"...)
+	}
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// NginxCombinedLogFormatter is a LogFormatter writing log entries in the
+// combined format of nginx followed by $request_time, the duration of the
+// request in seconds with a millisecond resolution, which log analyzers such
+// as GoAccess recognize out of the box:
+//
+//	log_format main '$remote_addr - $remote_user [$time_local] "$request" '
+//	                '$status $body_bytes_sent "$http_referer" '
+//	                '"$http_user_agent" $request_time';
+//
+// Unlike Apache, nginx logs a missing referer or user agent as "-".
+func NginxCombinedLogFormatter(writer io.Writer, params LogFormatterParams) {
+	buf := buildCommonLogLine(params.Request, params.URL, params.TimeStamp, params.StatusCode, params.Size)
+	buf = appendNginxField(buf, params.Request.Referer())
+	buf = appendNginxField(buf, params.Request.UserAgent())
+	buf = append(buf, ' ')
+	buf = strconv.AppendFloat(buf, params.Duration.Seconds(), 'f', 3, 64)
+	buf = append(buf, '\n')
+	_, _ = writer.Write(buf)
+}
+
+// appendNginxField appends a space and the quoted value s, or "-" if it is
+// empty, like nginx logs variables.
+func appendNginxField(buf []byte, s string) []byte {
+	if s == "" {
+		s = "-"
+	}
+	buf = append(buf, ` "`...)
+	buf = appendQuoted(buf, s)
+	return append(buf, '"')
+}
+
+// appendRefererAndUserAgent appends the fields which the Apache Combined Log
+// Format adds to the Common Log Format.
+func appendRefererAndUserAgent(buf []byte, req *http.Request) []byte {
 	buf = append(buf, ` "`...)
-	buf = appendQuoted(buf, params.Request.Referer())
+	buf = appendQuoted(buf, req.Referer())
 	buf = append(buf, `" "`...)
-	buf = appendQuoted(buf, params.Request.UserAgent())
-	buf = append(buf, '"', '\n')
-	_, _ = writer.Write(buf)
+	buf = appendQuoted(buf, req.UserAgent())
+	return append(buf, '"')
+}
+
+// appendQuotedField appends a space and the quoted value s, or "-" if it is
+// empty.
+func appendQuotedField(buf []byte, s string) []byte {
+	if s == "" {
+		return append(buf, " -"...)
+	}
+	buf = append(buf, ` "`...)
+	buf = appendQuoted(buf, s)
+	return append(buf, '"')
+}
+
+func appendTraceIDs(buf []byte, traceID, spanID string) []byte {
+	if traceID == "" {
+		return append(buf, " - -"...)
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, traceID...)
+	buf = append(buf, ' ')
+	return append(buf, spanID...)
 }
 
 // CombinedLoggingHandler return a http.Handler that wraps h and logs requests to out in
@@ -221,7 +1264,7 @@ func writeCombinedLog(writer io.Writer, params LogFormatterParams) {
 //
 // LoggingHandler always sets the ident field of the log to -.
 func CombinedLoggingHandler(out io.Writer, h http.Handler) http.Handler {
-	return loggingHandler{out, h, writeCombinedLog}
+	return newLoggingHandler(out, h, writeCombinedLog, nil)
 }
 
 // LoggingHandler return a http.Handler that wraps h and logs requests to out in
@@ -240,11 +1283,144 @@ func CombinedLoggingHandler(out io.Writer, h http.Handler) http.Handler {
 //	loggedRouter := handlers.LoggingHandler(os.Stdout, r)
 //	http.ListenAndServe(":1123", loggedRouter)
 func LoggingHandler(out io.Writer, h http.Handler) http.Handler {
-	return loggingHandler{out, h, writeLog}
+	return newLoggingHandler(out, h, writeLog, nil)
 }
 
 // CustomLoggingHandler provides a way to supply a custom log formatter
 // while taking advantage of the mechanisms in this package.
 func CustomLoggingHandler(out io.Writer, h http.Handler, f LogFormatter) http.Handler {
-	return loggingHandler{out, h, f}
+	return newLoggingHandler(out, h, f, nil)
+}
+
+// Logging is HTTP middleware logging requests to out, in Apache Common Log
+// Format unless another format is set with LogFormat. It is the same as
+// LoggingHandler, in the style of the other middleware of this package, for
+// use in chains of middleware.
+//
+// Example:
+//
+//	r := mux.NewRouter()
+//	r.Use(handlers.Logging(os.Stdout, handlers.LogFormat(handlers.CombinedLogFormatter)))
+func Logging(out io.Writer, opts ...LoggingOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return newLoggingHandler(out, h, writeLog, opts)
+	}
+}
+
+// LogFormat is a functional option that sets the formatter writing the log
+// entries.
+func LogFormat(f LogFormatter) LoggingOption {
+	return func(l *loggingHandler) {
+		if f != nil {
+			l.formatter = f
+		}
+	}
+}
+
+// LoggingOptions gathers the settings of a logging handler created with
+// LoggingHandlerWithOptions.
+type LoggingOptions struct {
+	// Writer is where log entries are written. It defaults to os.Stdout.
+	Writer io.Writer
+	// Formatter writes the log entries. It defaults to the Apache Common Log
+	// Format.
+	Formatter LogFormatter
+	// Skip is a predicate for requests which are not logged, see LogSkip.
+	Skip func(*http.Request) bool
+	// Clock returns the current time, see LogClock. It defaults to time.Now.
+	Clock func() time.Time
+	// RedactHeaders are request headers logged with redacted values, see
+	// LogRedactHeaders.
+	RedactHeaders []string
+	// RedactQueryParams are query parameters logged with redacted values, see
+	// LogRedactQueryParams.
+	RedactQueryParams []string
+	// SampleRate is the fraction of requests logged, see LogSampleRate. The
+	// zero value logs every request.
+	SampleRate float64
+	// Options are applied after the settings above, for the settings which
+	// have no field.
+	Options []LoggingOption
+}
+
+// LoggingHandlerWithOptions returns a http.Handler that wraps h and logs
+// requests as configured by opts, gathering all the settings of the logging
+// handlers in one call.
+//
+// Example:
+//
+//	h := handlers.LoggingHandlerWithOptions(r, handlers.LoggingOptions{
+//		Writer:            logFile,
+//		Formatter:         handlers.JSONLogFormatter,
+//		Skip:              func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+//		RedactQueryParams: []string{"token"},
+//		SampleRate:        0.1,
+//	})
+func LoggingHandlerWithOptions(h http.Handler, opts LoggingOptions) http.Handler {
+	out := opts.Writer
+	if out == nil {
+		out = os.Stdout
+	}
+	f := opts.Formatter
+	if f == nil {
+		f = writeLog
+	}
+	options := []LoggingOption{LogSkip(opts.Skip), LogClock(opts.Clock), LogSampleRate(opts.SampleRate)}
+	if len(opts.RedactHeaders) > 0 {
+		options = append(options, LogRedactHeaders(opts.RedactHeaders...))
+	}
+	if len(opts.RedactQueryParams) > 0 {
+		options = append(options, LogRedactQueryParams(opts.RedactQueryParams...))
+	}
+	options = append(options, opts.Options...)
+	return newLoggingHandler(out, h, f, options)
+}
+
+// EventLoggingHandler returns a http.Handler that wraps h and calls fn with
+// the LogFormatterParams of every completed request instead of writing a log
+// entry, to feed request records to metrics systems, message queues or
+// custom stores without formatting and parsing them. fn is called on the
+// goroutine serving the request, after the response has been written, and
+// must not retain the Request of the params after returning.
+//
+// Example:
+//
+//	h := handlers.EventLoggingHandler(r, func(p handlers.LogFormatterParams) {
+//		requests.WithLabelValues(p.RoutePattern, strconv.Itoa(p.StatusCode)).Inc()
+//	})
+func EventLoggingHandler(h http.Handler, fn func(LogFormatterParams), opts ...LoggingOption) http.Handler {
+	return newLoggingHandler(io.Discard, h, func(_ io.Writer, params LogFormatterParams) {
+		fn(params)
+	}, opts)
+}
+
+// LogOutput is a destination of MultiLoggingHandler: log entries are written
+// to Writer by Formatter, or in Apache Common Log Format if it is nil.
+type LogOutput struct {
+	Writer    io.Writer
+	Formatter LogFormatter
+}
+
+// MultiLoggingHandler returns a http.Handler that wraps h and logs requests
+// to each of outputs in its own format. The request is only wrapped once, and
+// the LogFormatterParams passed to the formatters are built once per request.
+//
+// Example:
+//
+//	h := handlers.MultiLoggingHandler(r, []handlers.LogOutput{
+//		{Writer: os.Stdout, Formatter: handlers.CombinedLogFormatter},
+//		{Writer: logFile, Formatter: handlers.JSONLogFormatter},
+//	})
+func MultiLoggingHandler(h http.Handler, outputs []LogOutput, opts ...LoggingOption) http.Handler {
+	outputs = append([]LogOutput(nil), outputs...)
+	for i := range outputs {
+		if outputs[i].Formatter == nil {
+			outputs[i].Formatter = writeLog
+		}
+	}
+	return newLoggingHandler(io.Discard, h, func(_ io.Writer, params LogFormatterParams) {
+		for _, out := range outputs {
+			out.Formatter(out.Writer, params)
+		}
+	}, opts)
 }
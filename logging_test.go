@@ -6,17 +6,25 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -401,3 +409,691 @@ func constructEncodedRequest() *http.Request {
 	req.URL, _ = url.Parse("http://example.com/test?abc=hello%20world&a=b%3F")
 	return req
 }
+
+type correlationIDKey struct{}
+
+func TestLogRequestIDSources(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		opts   []LoggingOption
+		header string
+		ctxID  string
+		want   string
+	}{
+		{"none", nil, "abc", "def", " -\n"},
+		{"header", []LoggingOption{LogRequestIDHeader("X-Correlation-ID")}, "abc", "def", ` "abc"` + "\n"},
+		{"context", []LoggingOption{LogRequestIDContextKey(correlationIDKey{})}, "abc", "def", ` "def"` + "\n"},
+		{"header missing", []LoggingOption{LogRequestIDHeader("X-Correlation-ID"), LogRequestIDContextKey(correlationIDKey{})}, "", "def", ` "def"` + "\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		h := Logging(&buf, append([]LoggingOption{LogFormat(RequestIDLogFormatter)}, test.opts...)...)(handler)
+
+		req := newRequest(http.MethodGet, "http://example.com/")
+		if test.header != "" {
+			req.Header.Set("X-Correlation-ID", test.header)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), correlationIDKey{}, test.ctxID))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := buf.String(); !strings.HasSuffix(got, test.want) {
+			t.Errorf("%s: got log %q, want suffix %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLogFormatterRequestIDCombinedLog(t *testing.T) {
+	var buf bytes.Buffer
+	req := constructTypicalRequestOk()
+	RequestIDCombinedLogFormatter(&buf, LogFormatterParams{
+		Request:    req,
+		URL:        *req.URL,
+		TimeStamp:  time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC),
+		StatusCode: http.StatusOK,
+		Size:       100,
+		RequestID:  "req-1",
+	})
+	want := "192.168.100.5 - - [26/May/1983:03:30:45 +0000] \"GET / HTTP/1.1\" 200 100 \"http://example.com\" " +
+		"\"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_2) " +
+		"AppleWebKit/537.33 (KHTML, like Gecko) Chrome/27.0.1430.0 Safari/537.33\" \"req-1\"\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("wrong log, got %q want %q", got, want)
+	}
+}
+
+func TestLogForwardedFor(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		want       string
+	}{
+		{"untrusted peer", "192.0.2.1:1234", "X-Forwarded-For", "203.0.113.7", "192.0.2.1 "},
+		{"trusted peer", "10.0.0.1:1234", "X-Forwarded-For", "203.0.113.7", "203.0.113.7 "},
+		{"spoofed chain", "10.0.0.1:1234", "X-Forwarded-For", "1.2.3.4, 203.0.113.7, 10.0.0.2", "203.0.113.7 "},
+		{"all trusted", "10.0.0.1:1234", "X-Forwarded-For", "10.0.0.3, 10.0.0.2", "10.0.0.3 "},
+		{"malformed hop", "10.0.0.1:1234", "X-Forwarded-For", "203.0.113.7, evil\n, 10.0.0.2", "10.0.0.2 "},
+		{"forwarded", "10.0.0.1:1234", "Forwarded", `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`, "2001:db8::1 "},
+		{"no header", "10.0.0.1:1234", "", "", "10.0.0.1 "},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		h := Logging(&buf, LogForwardedFor([]string{"10.0.0.0/8"}))(handler)
+
+		req := newRequest(http.MethodGet, "http://example.com/")
+		req.RemoteAddr = test.remoteAddr
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := buf.String(); !strings.HasPrefix(got, test.want) {
+			t.Errorf("%s: got log %q, want prefix %q", test.name, got, test.want)
+		}
+		if req.RemoteAddr != test.remoteAddr {
+			t.Errorf("%s: RemoteAddr of the request changed to %q", test.name, req.RemoteAddr)
+		}
+	}
+}
+
+func TestLogFormatterTraceLog(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var buf bytes.Buffer
+	h := CustomLoggingHandler(&buf, handler, TraceLogFormatter)
+
+	req := newRequest(http.MethodGet, "http://example.com/")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2", len(lines))
+	}
+	if !strings.HasSuffix(lines[0], " - -") {
+		t.Errorf("got log %q without trace, want suffix %q", lines[0], " - -")
+	}
+	if want := " 4bf92f3577b34da6a3ce929d0e0e4736 00f067aa0ba902b7"; !strings.HasSuffix(lines[1], want) {
+		t.Errorf("got log %q with trace, want suffix %q", lines[1], want)
+	}
+}
+
+func TestLogRequestHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var buf bytes.Buffer
+	h := Logging(&buf,
+		LogFormat(JSONLogFormatter),
+		LogRequestHeaders("X-Api-Client", "accept-language"),
+		LogRedactHeaders("Cookie"),
+		LogHashHeaders("Authorization"),
+	)(handler)
+
+	req := newRequest(http.MethodGet, "http://example.com/")
+	req.Header.Set("X-Api-Client", "cli/1.2")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Other", "ignored")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry struct {
+		Status         int                 `json:"status"`
+		RequestHeaders map[string][]string `json:"request_headers"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON log %q: %v", buf.String(), err)
+	}
+	sum := sha256.Sum256([]byte("Bearer secret"))
+	want := map[string][]string{
+		"X-Api-Client":  {"cli/1.2"},
+		"Cookie":        {"[REDACTED]"},
+		"Authorization": {"sha256:" + hex.EncodeToString(sum[:8])},
+	}
+	if !reflect.DeepEqual(entry.RequestHeaders, want) {
+		t.Errorf("got request headers %v, want %v", entry.RequestHeaders, want)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("log %q leaks a credential", buf.String())
+	}
+}
+
+func TestLogFormatterJSONLog(t *testing.T) {
+	var buf bytes.Buffer
+	req := constructTypicalRequestOk()
+	JSONLogFormatter(&buf, LogFormatterParams{
+		Request:          req,
+		URL:              *req.URL,
+		TimeStamp:        time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC),
+		StatusCode:       http.StatusOK,
+		Size:             100,
+		WireSize:         100,
+		UncompressedSize: 100,
+		RequestID:        "abc",
+		Protocol:         "http/1.1",
+	})
+
+	want := `{"time":"1983-05-26T03:30:45Z","remote_addr":"192.168.100.5","method":"GET","uri":"/","proto":"HTTP/1.1","protocol":"http/1.1",` +
+		`"status":200,"size":100,"wire_size":100,"uncompressed_size":100,"referer":"http://example.com","user_agent":"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_2) ` +
+		`AppleWebKit/537.33 (KHTML, like Gecko) Chrome/27.0.1430.0 Safari/537.33","request_id":"abc","duration":0}` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLogRedactQueryParams(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"/login?user=kim&password=hunter2", `"GET /login?user=kim&password=[REDACTED] HTTP/1.1"`},
+		{"/api?API_KEY=abc&token=&x", `"GET /api?API_KEY=[REDACTED]&token=[REDACTED]&x HTTP/1.1"`},
+		{"/search?q=password", `"GET /search?q=password HTTP/1.1"`},
+		{"/plain", `"GET /plain HTTP/1.1"`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		h := Logging(&buf, LogRedactQueryParams("token", "password", "api_key"))(handler)
+
+		req := newRequest(http.MethodGet, "http://example.com"+test.uri)
+		req.RequestURI = test.uri
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := buf.String(); !strings.Contains(got, test.want) {
+			t.Errorf("%s: got log %q, want %q", test.uri, got, test.want)
+		}
+		if req.RequestURI != test.uri {
+			t.Errorf("%s: RequestURI of the request changed to %q", test.uri, req.RequestURI)
+		}
+	}
+
+	// Without RequestURI, the request line is built from the URL.
+	var buf bytes.Buffer
+	h := Logging(&buf, LogRedactQueryParams("token"))(handler)
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/?token=abc"))
+	if got, want := buf.String(), `"GET /?token=[REDACTED] HTTP/1.1"`; !strings.Contains(got, want) {
+		t.Errorf("got log %q, want %q", got, want)
+	}
+}
+
+func TestLogResponseHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"written", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusTeapot)
+			w.Header().Set("Content-Type", "text/html")
+		}, "text/plain"},
+		{"implicit", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("hi"))
+			w.Header().Set("Content-Type", "text/html")
+		}, "text/plain"},
+		{"early hints", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</style.css>; rel=preload")
+			w.WriteHeader(http.StatusEarlyHints)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+		}, "text/plain"},
+		{"not written", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+		}, "text/plain"},
+	}
+	for _, test := range tests {
+		var got http.Header
+		h := CustomLoggingHandler(io.Discard, test.handler, func(_ io.Writer, params LogFormatterParams) {
+			got = params.ResponseHeader
+		})
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/"))
+
+		if ct := got.Get("Content-Type"); ct != test.want {
+			t.Errorf("%s: got Content-Type %q, want %q", test.name, ct, test.want)
+		}
+	}
+}
+
+func TestLogRoutePattern(t *testing.T) {
+	var route string
+	formatter := func(_ io.Writer, params LogFormatterParams) {
+		route = params.RoutePattern
+	}
+
+	// A router wrapped by the logging handler records its route.
+	router := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoutePattern(r, "/users/{id}")
+	})
+	CustomLoggingHandler(io.Discard, router, formatter).ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/users/42"))
+	if route != "/users/{id}" {
+		t.Errorf("got route %q from SetRoutePattern, want %q", route, "/users/{id}")
+	}
+
+	// The route is looked up on the request seen by the logging handler.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := Logging(io.Discard, LogFormat(formatter), LogRoutePattern(func(r *http.Request) string {
+		return "/items/{id}"
+	}))(handler)
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/items/42"))
+	if route != "/items/{id}" {
+		t.Errorf("got route %q from LogRoutePattern, want %q", route, "/items/{id}")
+	}
+
+	// SetRoutePattern does nothing outside of logging handlers.
+	SetRoutePattern(newRequest(http.MethodGet, "http://example.com/"), "/")
+}
+
+func TestLogCompressedSizes(t *testing.T) {
+	body := strings.Repeat("compressible ", 1000)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	})
+
+	tests := []struct {
+		name  string
+		chain func(formatter LogFormatter) http.Handler
+	}{
+		{"logging outside", func(f LogFormatter) http.Handler {
+			return CustomLoggingHandler(io.Discard, CompressHandler(handler), f)
+		}},
+		{"logging inside", func(f LogFormatter) http.Handler {
+			return CompressHandler(CustomLoggingHandler(io.Discard, handler, f))
+		}},
+	}
+	for _, test := range tests {
+		var params LogFormatterParams
+		h := test.chain(func(_ io.Writer, p LogFormatterParams) { params = p })
+
+		w := httptest.NewRecorder()
+		req := newRequest(http.MethodGet, "http://example.com/")
+		req.Header.Set("Accept-Encoding", "gzip")
+		h.ServeHTTP(w, req)
+
+		if params.UncompressedSize != len(body) {
+			t.Errorf("%s: got uncompressed size %d, want %d", test.name, params.UncompressedSize, len(body))
+		}
+		if params.WireSize != w.Body.Len() {
+			t.Errorf("%s: got wire size %d, want %d", test.name, params.WireSize, w.Body.Len())
+		}
+		if params.WireSize >= params.UncompressedSize {
+			t.Errorf("%s: wire size %d isn't smaller than uncompressed size %d", test.name, params.WireSize, params.UncompressedSize)
+		}
+	}
+}
+
+func TestLogHijackedConnection(t *testing.T) {
+	logged := make(chan LogFormatterParams, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+			_ = rw.Flush()
+			line, _ := rw.ReadString('\n')
+			_, _ = rw.WriteString("echo: " + line)
+			_ = rw.Flush()
+		}()
+	})
+	s := httptest.NewServer(CustomLoggingHandler(io.Discard, handler, func(_ io.Writer, params LogFormatterParams) {
+		logged <- params
+	}))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The ping is sent along with the request, so it is buffered by the
+	// server when the connection is hijacked.
+	_, _ = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\nping\n")
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	var params LogFormatterParams
+	select {
+	case params = <-logged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hijacked connection wasn't logged")
+	}
+	if !params.Hijacked || params.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("got hijacked %t, status %d, want true, %d", params.Hijacked, params.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if want := int64(len("ping\n")); params.BytesRead != want {
+		t.Errorf("got %d bytes read, want %d", params.BytesRead, want)
+	}
+	if want := int64(len("HTTP/1.1 101 Switching Protocols\r\n\r\necho: ping\n")); params.BytesWritten != want || params.Size != int(want) {
+		t.Errorf("got %d bytes written, size %d, want %d", params.BytesWritten, params.Size, want)
+	}
+}
+
+func TestMultiLoggingHandler(t *testing.T) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTeapot)
+	})
+	var common, combined, jsonLog bytes.Buffer
+	h := MultiLoggingHandler(handler, []LogOutput{
+		{Writer: &common},
+		{Writer: &combined, Formatter: CombinedLogFormatter},
+		{Writer: &jsonLog, Formatter: JSONLogFormatter},
+	})
+	req := constructTypicalRequestOk()
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+	if got := common.String(); !strings.HasSuffix(got, `HTTP/1.1" 418 0`+"\n") {
+		t.Errorf("got common log %q", got)
+	}
+	if got := combined.String(); !strings.Contains(got, `418 0 "http://example.com" "Mozilla/5.0`) {
+		t.Errorf("got combined log %q", got)
+	}
+	if got := jsonLog.String(); !strings.Contains(got, `"status":418`) {
+		t.Errorf("got JSON log %q", got)
+	}
+}
+
+func TestLogStatusClassWriter(t *testing.T) {
+	var access, errorLog bytes.Buffer
+	status := http.StatusOK
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+	h := Logging(&access,
+		LogStatusClassWriter(4, &errorLog),
+		LogStatusClassWriter(5, &errorLog),
+	)(handler)
+
+	for _, status = range []int{http.StatusOK, http.StatusFound, http.StatusNotFound, http.StatusBadGateway} {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/"))
+	}
+
+	if got := access.String(); strings.Count(got, "\n") != 2 || !strings.Contains(got, `" 200 0`) || !strings.Contains(got, `" 302 0`) {
+		t.Errorf("got access log %q, want the 200 and 302 entries", got)
+	}
+	if got := errorLog.String(); strings.Count(got, "\n") != 2 || !strings.Contains(got, `" 404 0`) || !strings.Contains(got, `" 502 0`) {
+		t.Errorf("got error log %q, want the 404 and 502 entries", got)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	var common, combined bytes.Buffer
+	Logging(&common)(handler).ServeHTTP(httptest.NewRecorder(), constructTypicalRequestOk())
+	Logging(&combined, LogFormat(CombinedLogFormatter))(handler).ServeHTTP(httptest.NewRecorder(), constructTypicalRequestOk())
+
+	if got := common.String(); !strings.HasSuffix(got, `" 202 0`+"\n") {
+		t.Errorf("got log %q, want an entry in Common Log Format", got)
+	}
+	if got := combined.String(); !strings.Contains(got, `" 202 0 "http://example.com" "Mozilla/5.0`) {
+		t.Errorf("got log %q, want an entry in Combined Log Format", got)
+	}
+}
+
+func TestLoggingHandlerWithOptions(t *testing.T) {
+	status := http.StatusOK
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+	var buf bytes.Buffer
+	now := time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC)
+	h := LoggingHandlerWithOptions(handler, LoggingOptions{
+		Writer:            &buf,
+		Skip:              func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+		Clock:             func() time.Time { return now },
+		RedactQueryParams: []string{"token"},
+	})
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/healthz"))
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/?token=abc"))
+	want := `[26/May/1983:03:30:45 +0000] "GET /?token=[REDACTED] HTTP/1.1" 200 0` + "\n"
+	if got := buf.String(); !strings.HasSuffix(got, want) || strings.Count(got, "\n") != 1 {
+		t.Errorf("got log %q, want a single entry ending in %q", got, want)
+	}
+
+	// With a tiny sample rate, only server errors are logged.
+	buf.Reset()
+	h = LoggingHandlerWithOptions(handler, LoggingOptions{Writer: &buf, SampleRate: 1e-12})
+	for _, status = range []int{http.StatusOK, http.StatusNotFound, http.StatusServiceUnavailable} {
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/"))
+	}
+	if got := buf.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, `" 503 0`) {
+		t.Errorf("got log %q, want the 503 entry only", got)
+	}
+}
+
+func TestSetLogError(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetLogError(r.Context(), errors.New("database unavailable"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	var buf bytes.Buffer
+	CustomLoggingHandler(&buf, handler, JSONLogFormatter).ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/"))
+
+	if got := buf.String(); !strings.Contains(got, `"status":500`) || !strings.Contains(got, `"error":"database unavailable"`) {
+		t.Errorf("got log %q, want the error", got)
+	}
+
+	// SetLogError does nothing outside of logging handlers.
+	SetLogError(context.Background(), errors.New("ignored"))
+}
+
+func TestLogFormatterCacheCombinedLog(t *testing.T) {
+	cache := NewResponseCache()
+	handler := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "hello")
+	}))
+	var buf bytes.Buffer
+	h := CustomLoggingHandler(&buf, handler, CacheCombinedLogFormatter)
+
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), constructTypicalRequestOk())
+	}
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "http://example.com/"))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	wants := []string{` "text/plain" "MISS"`, ` "text/plain" "HIT"`, ` "text/plain" "BYPASS"`}
+	if len(lines) != len(wants) {
+		t.Fatalf("got %d log lines, want %d", len(lines), len(wants))
+	}
+	for i, want := range wants {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("got log %q, want suffix %q", lines[i], want)
+		}
+	}
+}
+
+func TestQueueLatency(t *testing.T) {
+	start := time.Date(2023, 0o5, 26, 3, 30, 45, 0, time.UTC)
+	received := start.Add(-1500 * time.Millisecond)
+
+	tests := []struct {
+		header, value string
+		want          time.Duration
+	}{
+		{"X-Request-Start", "t=" + strconv.FormatFloat(float64(received.UnixMilli())/1e3, 'f', 3, 64), 1500 * time.Millisecond},
+		{"X-Request-Start", strconv.FormatInt(received.UnixMilli(), 10), 1500 * time.Millisecond},
+		{"X-Queue-Start", "t=" + strconv.FormatInt(received.UnixMicro(), 10), 1500 * time.Millisecond},
+		{"X-Request-Start", strconv.FormatInt(received.UnixNano(), 10), 1500 * time.Millisecond},
+		{"X-Request-Start", strconv.FormatInt(start.Add(time.Second).UnixMilli(), 10), 0},
+		{"X-Request-Start", "garbage", 0},
+		{"X-Other", "1", 0},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set(test.header, test.value)
+		if got := queueLatency(header, start); got != test.want {
+			t.Errorf("%s: %s: got %v, want %v", test.header, test.value, got, test.want)
+		}
+	}
+}
+
+func TestLogFormatterQueueCombinedLog(t *testing.T) {
+	var buf bytes.Buffer
+	req := constructTypicalRequestOk()
+	QueueCombinedLogFormatter(&buf, LogFormatterParams{
+		Request:      req,
+		URL:          *req.URL,
+		TimeStamp:    time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC),
+		StatusCode:   http.StatusOK,
+		Size:         100,
+		QueueLatency: 42 * time.Millisecond,
+	})
+	if got := buf.String(); !strings.HasSuffix(got, `Safari/537.33" 42`+"\n") {
+		t.Errorf("got log %q, want the queue latency in milliseconds", got)
+	}
+}
+
+func TestLogCaptureBodies(t *testing.T) {
+	status := http.StatusInternalServerError
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, "something went wrong")
+	})
+	var got LogFormatterParams
+	formatter := func(_ io.Writer, params LogFormatterParams) { got = params }
+
+	// Each call of the clock advances it by a second.
+	now := time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC)
+	clock := func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	tests := []struct {
+		name         string
+		status       int
+		slow         time.Duration
+		wantReq      string
+		wantResponse string
+	}{
+		{"server error", http.StatusInternalServerError, 0, `{"name":`, "somethin"},
+		{"success", http.StatusOK, 0, "", ""},
+		{"slow success", http.StatusOK, time.Second, `{"name":`, "somethin"},
+		{"fast success", http.StatusOK, time.Minute, "", ""},
+	}
+	for _, test := range tests {
+		status = test.status
+		h := Logging(io.Discard, LogFormat(formatter), LogClock(clock), LogCaptureBodies(8, test.slow))(handler)
+		req := newRequest(http.MethodPost, "http://example.com/")
+		req.Body = io.NopCloser(strings.NewReader(`{"name":"kim"}`))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if string(got.RequestBody) != test.wantReq || string(got.ResponseBody) != test.wantResponse {
+			t.Errorf("%s: got bodies %q, %q, want %q, %q", test.name, got.RequestBody, got.ResponseBody, test.wantReq, test.wantResponse)
+		}
+	}
+}
+
+func TestLogProtocolAndTrailer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		_, _ = io.WriteString(w, "hello")
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Elapsed", "1ms")
+	})
+	var got LogFormatterParams
+	h := CustomLoggingHandler(io.Discard, handler, func(_ io.Writer, params LogFormatterParams) { got = params })
+
+	req := newRequest(http.MethodGet, "http://example.com/")
+	req.ProtoMajor, req.ProtoMinor = 2, 0
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Protocol != "h2c" {
+		t.Errorf("got protocol %q, want %q", got.Protocol, "h2c")
+	}
+	want := http.Header{"X-Checksum": {"abc"}, "X-Elapsed": {"1ms"}}
+	if !reflect.DeepEqual(got.Trailer, want) {
+		t.Errorf("got trailer %v, want %v", got.Trailer, want)
+	}
+}
+
+func TestLogFormatterNginxCombinedLog(t *testing.T) {
+	var buf bytes.Buffer
+	req := constructTypicalRequestOk()
+	req.Header.Del("Referer")
+	NginxCombinedLogFormatter(&buf, LogFormatterParams{
+		Request:    req,
+		URL:        *req.URL,
+		TimeStamp:  time.Date(1983, 0o5, 26, 3, 30, 45, 0, time.UTC),
+		StatusCode: http.StatusOK,
+		Size:       100,
+		Duration:   1234567 * time.Microsecond,
+	})
+
+	want := `192.168.100.5 - - [26/May/1983:03:30:45 +0000] "GET / HTTP/1.1" 200 100 "-" "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_2) ` +
+		`AppleWebKit/537.33 (KHTML, like Gecko) Chrome/27.0.1430.0 Safari/537.33" 1.235` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+type userKey struct{}
+
+func TestLogAuthenticatedUser(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name string
+		opts []LoggingOption
+		want string
+	}{
+		{"none", nil, " - - ["},
+		{"basic auth", []LoggingOption{LogBasicAuthUser()}, " - kim ["},
+		{"context", []LoggingOption{LogBasicAuthUser(), LogUserContextKey(userKey{})}, " - alex ["},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		h := Logging(&buf, test.opts...)(handler)
+
+		req := newRequest(http.MethodGet, "http://example.com/")
+		req.SetBasicAuth("kim", "hunter2")
+		req = req.WithContext(context.WithValue(req.Context(), userKey{}, "alex"))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := buf.String(); !strings.HasPrefix(got, test.want) || strings.Contains(got, "hunter2") {
+			t.Errorf("%s: got log %q, want prefix %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEventLoggingHandler(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetRoutePattern(r, "/users/{id}")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	var events []LogFormatterParams
+	h := EventLoggingHandler(handler, func(params LogFormatterParams) {
+		events = append(events, params)
+	}, LogSkip(func(r *http.Request) bool { return r.URL.Path == "/healthz" }))
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/users/42"))
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://example.com/healthz"))
+
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if e := events[0]; e.StatusCode != http.StatusNoContent || e.RoutePattern != "/users/{id}" {
+		t.Errorf("got status %d, route %q, want %d, %q", e.StatusCode, e.RoutePattern, http.StatusNoContent, "/users/{id}")
+	}
+}
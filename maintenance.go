@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Maintenance is a switch putting handlers into maintenance mode, during which
+// requests are answered with a 503 "Service Unavailable" and a Retry-After
+// header instead of being served. Create one with NewMaintenance and wrap
+// handlers with its Handler method.
+//
+// Maintenance mode is toggled with Enable and Disable, which are safe to call
+// concurrently with requests being served, or by a function supplied with
+// MaintenanceFunc, e.g. one checking for a flag file or a feature flag.
+type Maintenance struct {
+	enabled    atomic.Bool
+	check      func() bool
+	retryAfter time.Duration
+	response   http.Handler
+	exempt     []string
+	exemptFunc func(*http.Request) bool
+}
+
+// MaintenanceOption provides a functional approach to configure Maintenance.
+type MaintenanceOption func(*Maintenance)
+
+// MaintenanceInfo is the data passed to the template set with
+// MaintenanceTemplate.
+type MaintenanceInfo struct {
+	Request    *http.Request
+	RetryAfter time.Duration
+}
+
+// NewMaintenance returns a new, disabled Maintenance switch.
+//
+// Example:
+//
+//	maintenance := handlers.NewMaintenance(
+//		handlers.MaintenanceRetryAfter(10*time.Minute),
+//		handlers.MaintenanceExempt("/admin/", "/healthz"),
+//	)
+//	http.Handle("/", maintenance.Handler(r))
+//
+//	// Later, e.g. from an admin endpoint or a signal handler:
+//	maintenance.Enable()
+func NewMaintenance(opts ...MaintenanceOption) *Maintenance {
+	m := &Maintenance{}
+	for _, option := range opts {
+		option(m)
+	}
+	if m.response == nil {
+		m.response = http.HandlerFunc(defaultMaintenanceResponse)
+	}
+	return m
+}
+
+// MaintenanceFunc is a functional option that puts handlers into maintenance
+// mode whenever fn returns true, in addition to Enable.
+func MaintenanceFunc(fn func() bool) MaintenanceOption {
+	return func(m *Maintenance) {
+		m.check = fn
+	}
+}
+
+// MaintenanceRetryAfter is a functional option that sets the delay advertised
+// to clients via the Retry-After header. No Retry-After header is sent by
+// default.
+func MaintenanceRetryAfter(d time.Duration) MaintenanceOption {
+	return func(m *Maintenance) {
+		m.retryAfter = d
+	}
+}
+
+// MaintenanceResponse is a functional option that sets the handler writing
+// the maintenance response. It is called after the Retry-After header is set,
+// and is expected to respond with a 503 status.
+func MaintenanceResponse(h http.Handler) MaintenanceOption {
+	return func(m *Maintenance) {
+		m.response = h
+	}
+}
+
+// MaintenanceBody is a functional option that responds to requests during
+// maintenance with a 503 status and the given body.
+func MaintenanceBody(contentType string, body []byte) MaintenanceOption {
+	return MaintenanceResponse(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write(body)
+	}))
+}
+
+// MaintenanceTemplate is a functional option that responds to requests during
+// maintenance with a 503 status and the HTML page rendered by t, which is
+// executed with a MaintenanceInfo.
+func MaintenanceTemplate(t *template.Template) MaintenanceOption {
+	return func(m *Maintenance) {
+		m.response = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, MaintenanceInfo{Request: r, RetryAfter: m.retryAfter}); err != nil {
+				defaultMaintenanceResponse(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = buf.WriteTo(w)
+		})
+	}
+}
+
+// MaintenanceExempt is a functional option that keeps serving requests whose
+// path starts with any of the given prefixes during maintenance, e.g. admin
+// pages and health checks.
+func MaintenanceExempt(prefixes ...string) MaintenanceOption {
+	return func(m *Maintenance) {
+		m.exempt = append(m.exempt, prefixes...)
+	}
+}
+
+// MaintenanceExemptFunc is a functional option that keeps serving requests
+// for which fn returns true during maintenance.
+func MaintenanceExemptFunc(fn func(*http.Request) bool) MaintenanceOption {
+	return func(m *Maintenance) {
+		m.exemptFunc = fn
+	}
+}
+
+// Enable puts handlers into maintenance mode.
+func (m *Maintenance) Enable() {
+	m.enabled.Store(true)
+}
+
+// Disable takes handlers out of maintenance mode, unless the function set
+// with MaintenanceFunc keeps them in it.
+func (m *Maintenance) Disable() {
+	m.enabled.Store(false)
+}
+
+// Enabled reports whether handlers are in maintenance mode.
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load() || (m.check != nil && m.check())
+}
+
+// Handler returns a handler passing requests to h, unless in maintenance mode.
+func (m *Maintenance) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Enabled() || m.exempted(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		setRetryAfter(w.Header(), m.retryAfter)
+		m.response.ServeHTTP(w, r)
+	})
+}
+
+func (m *Maintenance) exempted(r *http.Request) bool {
+	for _, prefix := range m.exempt {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return m.exemptFunc != nil && m.exemptFunc(r)
+}
+
+func defaultMaintenanceResponse(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
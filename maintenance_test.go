@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaintenance(t *testing.T) {
+	m := NewMaintenance(
+		MaintenanceRetryAfter(2*time.Minute),
+		MaintenanceExempt("/admin/"),
+		MaintenanceExemptFunc(func(r *http.Request) bool {
+			return r.Header.Get("X-Bypass") != ""
+		}),
+	)
+	h := m.Handler(okHandler)
+
+	serve := func(path string, bypass bool) *httptest.ResponseRecorder {
+		r := newRequest(http.MethodGet, path)
+		if bypass {
+			r.Header.Set("X-Bypass", "1")
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	if rr := serve("/", false); rr.Code != http.StatusOK {
+		t.Fatalf("bad status while disabled: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	m.Enable()
+	if !m.Enabled() {
+		t.Fatal("expected maintenance mode to be enabled")
+	}
+	rr := serve("/", false)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("bad status while enabled: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "120" {
+		t.Fatalf("bad Retry-After: got %q want %q", got, "120")
+	}
+	if rr := serve("/admin/users", false); rr.Code != http.StatusOK {
+		t.Fatalf("bad status for exempt path: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve("/", true); rr.Code != http.StatusOK {
+		t.Fatalf("bad status for exempt request: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	m.Disable()
+	if rr := serve("/", false); rr.Code != http.StatusOK {
+		t.Fatalf("bad status after disabling: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestMaintenanceFuncAndBody(t *testing.T) {
+	on := false
+	tmpl := template.Must(template.New("").Parse(`<p>Back in {{.RetryAfter}} for {{.Request.URL.Path}}</p>`))
+
+	tests := []struct {
+		name string
+		opt  MaintenanceOption
+		ct   string
+		body string
+	}{
+		{"body", MaintenanceBody("application/json", []byte(`{"maintenance":true}`)), "application/json", `{"maintenance":true}`},
+		{"template", MaintenanceTemplate(tmpl), "text/html; charset=utf-8", "<p>Back in 1m0s for /page</p>"},
+	}
+
+	for _, tt := range tests {
+		m := NewMaintenance(MaintenanceFunc(func() bool { return on }), MaintenanceRetryAfter(time.Minute), tt.opt)
+		h := m.Handler(okHandler)
+
+		on = false
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "/page"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: bad status: got %v want %v", tt.name, rr.Code, http.StatusOK)
+		}
+
+		on = true
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "/page"))
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s: bad status: got %v want %v", tt.name, rr.Code, http.StatusServiceUnavailable)
+		}
+		if got := rr.Header().Get("Content-Type"); got != tt.ct {
+			t.Fatalf("%s: bad content type: got %q want %q", tt.name, got, tt.ct)
+		}
+		if got := rr.Body.String(); got != tt.body {
+			t.Fatalf("%s: bad body: got %q want %q", tt.name, got, tt.body)
+		}
+	}
+}
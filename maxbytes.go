@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type maxBytesHandler struct {
+	h                  http.Handler
+	limit              int64
+	limitFunc          func(*http.Request) int64
+	checkContentLength bool
+	response           http.Handler
+	onReject           func(r *http.Request, limit int64)
+}
+
+// MaxBytesOption provides a functional approach to configure the
+// MaxBytesHandler middleware.
+type MaxBytesOption func(*maxBytesHandler)
+
+// MaxBytesHandler is HTTP middleware that limits the size of request bodies
+// to n bytes, like http.MaxBytesHandler, but answers requests exceeding the
+// limit with a proper 413 "Request Entity Too Large".
+//
+// Reads past the limit fail with an *http.MaxBytesError, as with
+// http.MaxBytesReader. If the next handler then responds without having
+// written anything yet, e.g. with a 400 or 500 error of its own, its response
+// is replaced with the 413 response (see MaxBytesResponse).
+//
+// Example:
+//
+//	limit := handlers.MaxBytesHandler(1<<20, handlers.MaxBytesFunc(func(r *http.Request) int64 {
+//		if strings.HasPrefix(r.URL.Path, "/upload") {
+//			return 100 << 20
+//		}
+//		return 1 << 20
+//	}))
+//	http.ListenAndServe(":8000", limit(r))
+func MaxBytesHandler(n int64, opts ...MaxBytesOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		m := &maxBytesHandler{
+			h:        h,
+			limit:    n,
+			response: http.HandlerFunc(defaultMaxBytesResponse),
+		}
+		for _, option := range opts {
+			option(m)
+		}
+		return m
+	}
+}
+
+// MaxBytesFunc is a functional option that computes the limit for each
+// request, overriding the default passed to MaxBytesHandler. A negative limit
+// disables limiting for the request.
+func MaxBytesFunc(fn func(*http.Request) int64) MaxBytesOption {
+	return func(m *maxBytesHandler) {
+		m.limitFunc = fn
+	}
+}
+
+// MaxBytesCheckContentLength is a functional option that rejects requests
+// whose Content-Length header exceeds the limit up front, without calling the
+// next handler.
+func MaxBytesCheckContentLength() MaxBytesOption {
+	return func(m *maxBytesHandler) {
+		m.checkContentLength = true
+	}
+}
+
+// MaxBytesResponse is a functional option that sets the handler writing the
+// response to requests exceeding the limit. It is expected to respond with a
+// 413 status.
+func MaxBytesResponse(h http.Handler) MaxBytesOption {
+	return func(m *maxBytesHandler) {
+		m.response = h
+	}
+}
+
+// MaxBytesOnReject is a functional option that registers fn to be called
+// whenever a request exceeds its limit, e.g. to record a metric.
+func MaxBytesOnReject(fn func(r *http.Request, limit int64)) MaxBytesOption {
+	return func(m *maxBytesHandler) {
+		m.onReject = fn
+	}
+}
+
+func defaultMaxBytesResponse(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+func (m *maxBytesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := m.limit
+	if m.limitFunc != nil {
+		limit = m.limitFunc(r)
+	}
+	if limit < 0 || r.Body == nil || r.Body == http.NoBody {
+		m.h.ServeHTTP(w, r)
+		return
+	}
+
+	if m.checkContentLength && r.ContentLength > limit {
+		m.reject(r, limit)
+		m.response.ServeHTTP(w, r)
+		return
+	}
+
+	var exceeded, wrote, replaced bool
+	replace := func() bool {
+		if wrote {
+			return replaced
+		}
+		wrote = true
+		if exceeded {
+			replaced = true
+			m.response.ServeHTTP(w, r)
+		}
+		return replaced
+	}
+
+	r.Body = &maxBytesBody{
+		ReadCloser: http.MaxBytesReader(w, r.Body, limit),
+		exceeded: func() {
+			if !exceeded {
+				exceeded = true
+				m.reject(r, limit)
+			}
+		},
+	}
+
+	m.h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				if !replace() {
+					next(code)
+				}
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				if replace() {
+					return len(b), nil
+				}
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				if replace() {
+					return io.Copy(io.Discard, src)
+				}
+				return next(src)
+			}
+		},
+	}), r)
+
+	replace()
+}
+
+func (m *maxBytesHandler) reject(r *http.Request, limit int64) {
+	if m.onReject != nil {
+		m.onReject(r, limit)
+	}
+}
+
+// maxBytesBody reports reads exceeding the limit of the wrapped
+// http.MaxBytesReader.
+type maxBytesBody struct {
+	io.ReadCloser
+	exceeded func()
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		b.exceeded()
+	}
+	return n, err
+}
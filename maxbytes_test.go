@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func bodyRequest(body string) *http.Request {
+	r, err := http.NewRequest(http.MethodPost, "/upload", strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func TestMaxBytesHandler(t *testing.T) {
+	var rejected []int64
+	var readErr error
+	h := MaxBytesHandler(4, MaxBytesOnReject(func(r *http.Request, limit int64) {
+		rejected = append(rejected, limit)
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+		if readErr != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, bodyRequest("abc"))
+	if rr.Code != http.StatusOK || rr.Body.String() != ok {
+		t.Fatalf("bad response: got %v %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, bodyRequest("abcdefgh"))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if got, want := rr.Body.String(), "Request Entity Too Large\n"; got != want {
+		t.Fatalf("bad body: got %q want %q", got, want)
+	}
+	var mbe *http.MaxBytesError
+	if !errors.As(readErr, &mbe) {
+		t.Fatalf("bad read error: got %v", readErr)
+	}
+	if len(rejected) != 1 || rejected[0] != 4 {
+		t.Fatalf("bad rejection callbacks: %v", rejected)
+	}
+}
+
+func TestMaxBytesHandlerOptions(t *testing.T) {
+	called := false
+	h := MaxBytesHandler(4,
+		MaxBytesCheckContentLength(),
+		MaxBytesFunc(func(r *http.Request) int64 {
+			if r.URL.Query().Get("big") != "" {
+				return -1
+			}
+			return 4
+		}),
+		MaxBytesResponse(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(`{"error":"too large"}`))
+		})),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, bodyRequest("abcdefgh"))
+	if called {
+		t.Fatal("next handler called despite Content-Length check")
+	}
+	if rr.Code != http.StatusRequestEntityTooLarge || rr.Body.String() != `{"error":"too large"}` {
+		t.Fatalf("bad response: got %v %q", rr.Code, rr.Body.String())
+	}
+
+	r := bodyRequest("abcdefgh")
+	r.URL.RawQuery = "big=1"
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if !called || rr.Code != http.StatusOK {
+		t.Fatalf("unlimited request rejected: status %v", rr.Code)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+)
+
+// RequestMetrics describes a completed request for a MetricsRecorder.
+type RequestMetrics struct {
+	// Method is the request method.
+	Method string
+	// Route is the route template matched by the request, such as
+	// "/users/{id}", or "" if unknown. See MetricsRoute.
+	Route string
+	// Code is the response status code.
+	Code int
+	// Duration is the time taken to serve the request.
+	Duration time.Duration
+	// RequestSize is the size of the request body according to its
+	// Content-Length header, or 0 if unknown.
+	RequestSize int64
+	// ResponseSize is the number of response body bytes written.
+	ResponseSize int64
+}
+
+// MetricsRecorder records request metrics. It is a minimal interface which
+// is easily implemented on top of a Prometheus registry, e.g. with a counter
+// and histograms labeled by method, code and route plus an in-flight gauge,
+// keeping this package free of the dependency.
+type MetricsRecorder interface {
+	// AddInFlight adds delta to the number of requests being served.
+	AddInFlight(delta int)
+	// ObserveRequest records a completed request.
+	ObserveRequest(m RequestMetrics)
+}
+
+// MetricsRecorderFunc is a callback adapter for MetricsRecorder, for callers
+// that do not track the number of requests in flight.
+type MetricsRecorderFunc func(m RequestMetrics)
+
+// AddInFlight does nothing.
+func (f MetricsRecorderFunc) AddInFlight(delta int) {}
+
+// ObserveRequest calls f(m).
+func (f MetricsRecorderFunc) ObserveRequest(m RequestMetrics) {
+	f(m)
+}
+
+type metricsHandler struct {
+	h        http.Handler
+	recorder MetricsRecorder
+	route    func(*http.Request) string
+}
+
+// MetricsOption provides a functional approach to configure the
+// MetricsHandler middleware.
+type MetricsOption func(*metricsHandler)
+
+// MetricsHandler is HTTP middleware that records the number of requests in
+// flight and, for every completed request, its method, status, route,
+// duration and sizes using recorder.
+//
+// Example:
+//
+//	type promRecorder struct {
+//		inFlight prometheus.Gauge
+//		duration *prometheus.HistogramVec
+//	}
+//
+//	func (p promRecorder) AddInFlight(delta int) { p.inFlight.Add(float64(delta)) }
+//
+//	func (p promRecorder) ObserveRequest(m handlers.RequestMetrics) {
+//		p.duration.WithLabelValues(m.Method, strconv.Itoa(m.Code), m.Route).Observe(m.Duration.Seconds())
+//	}
+//
+//	http.ListenAndServe(":8000", handlers.MetricsHandler(rec)(r))
+func MetricsHandler(recorder MetricsRecorder, opts ...MetricsOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		m := &metricsHandler{h: h, recorder: recorder}
+		for _, option := range opts {
+			option(m)
+		}
+		return m
+	}
+}
+
+// MetricsRoute is a functional option that sets the function returning the
+// route template matched by the request, keeping the cardinality of route
+// labels low. With gorilla/mux, install the middleware with Router.Use so that
+// mux.CurrentRoute is available.
+func MetricsRoute(fn func(*http.Request) string) MetricsOption {
+	return func(m *metricsHandler) {
+		m.route = fn
+	}
+}
+
+func (m *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rm := RequestMetrics{Method: r.Method}
+	if m.route != nil {
+		rm.Route = m.route(r)
+	}
+	if r.ContentLength > 0 {
+		rm.RequestSize = r.ContentLength
+	}
+
+	m.recorder.AddInFlight(1)
+	logger, w := makeLogger(w)
+	completed := false
+	defer func() {
+		m.recorder.AddInFlight(-1)
+		rm.Code = logger.Status()
+		if !completed {
+			// The panic is expected to be turned into an internal server
+			// error further up the chain.
+			rm.Code = http.StatusInternalServerError
+		}
+		rm.Duration = time.Since(start)
+		rm.ResponseSize = int64(logger.Size())
+		m.recorder.ObserveRequest(rm)
+	}()
+
+	m.h.ServeHTTP(w, r)
+	completed = true
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testMetricsRecorder struct {
+	inFlight, maxInFlight int
+	requests              []RequestMetrics
+}
+
+func (t *testMetricsRecorder) AddInFlight(delta int) {
+	t.inFlight += delta
+	if t.inFlight > t.maxInFlight {
+		t.maxInFlight = t.inFlight
+	}
+}
+
+func (t *testMetricsRecorder) ObserveRequest(m RequestMetrics) {
+	t.requests = append(t.requests, m)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	rec := &testMetricsRecorder{}
+	h := MetricsHandler(rec, MetricsRoute(func(r *http.Request) string {
+		return "/items/{id}"
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec.inFlight != 1 {
+			t.Errorf("bad in-flight count: %d", rec.inFlight)
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	r, _ := http.NewRequest(http.MethodPut, "/items/7", strings.NewReader("body"))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if rec.inFlight != 0 || rec.maxInFlight != 1 || len(rec.requests) != 1 {
+		t.Fatalf("bad recorder state: %+v", rec)
+	}
+	m := rec.requests[0]
+	if m.Method != http.MethodPut || m.Route != "/items/{id}" || m.Code != http.StatusNotFound ||
+		m.RequestSize != 4 || m.ResponseSize != int64(len(ok)) || m.Duration <= 0 {
+		t.Fatalf("bad metrics: %+v", m)
+	}
+}
+
+func TestMetricsHandlerPanic(t *testing.T) {
+	var got []RequestMetrics
+	h := MetricsHandler(MetricsRecorderFunc(func(m RequestMetrics) {
+		got = append(got, m)
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	}()
+
+	if len(got) != 1 || got[0].Code != http.StatusInternalServerError {
+		t.Fatalf("bad metrics: %+v", got)
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultNetLogQueueSize   = 1024
+	defaultNetLogDialTimeout = 5 * time.Second
+	defaultNetLogMinBackoff  = 100 * time.Millisecond
+	defaultNetLogMaxBackoff  = 30 * time.Second
+)
+
+// ErrNetLogWriterClosed is returned by the Write method of a NetLogWriter
+// which has been closed.
+var ErrNetLogWriterClosed = errors.New("handlers: network log writer is closed")
+
+// NetLogWriter is an io.Writer shipping log lines to a remote collector over
+// TCP, UDP or TLS, e.g. a syslog or Logstash endpoint. Used as the writer of
+// the logging handlers, it never blocks requests: lines are queued in a
+// bounded buffer, from which a background goroutine sends them, and dropped
+// when the buffer is full, e.g. while the collector is down. The connection is
+// established lazily and re-established with exponential backoff whenever it
+// fails.
+//
+// Close must be called to send the queued lines and stop the background
+// goroutine.
+type NetLogWriter struct {
+	network, addr string
+	tlsConfig     *tls.Config
+	dialTimeout   time.Duration
+	writeTimeout  time.Duration
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	size          int
+
+	lines   chan []byte
+	quit    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex // guards closed against concurrent sends
+	closed bool
+}
+
+// NetLogOption provides a functional approach to configure a NetLogWriter.
+type NetLogOption func(*NetLogWriter)
+
+// NewNetLogWriter returns a NetLogWriter sending log lines to addr on the
+// named network, "tcp", "udp" or "unix" for instance, and starts its
+// background goroutine. Each line is sent with a single write, hence as a
+// single datagram over UDP.
+//
+// Example:
+//
+//	out := handlers.NewNetLogWriter("tcp", "logs.example.com:5170", handlers.NetLogTLS(nil))
+//	defer out.Close()
+//	http.ListenAndServe(":8000", handlers.CustomLoggingHandler(out, r, handlers.JSONLogFormatter))
+func NewNetLogWriter(network, addr string, opts ...NetLogOption) *NetLogWriter {
+	n := &NetLogWriter{
+		network:     network,
+		addr:        addr,
+		dialTimeout: defaultNetLogDialTimeout,
+		minBackoff:  defaultNetLogMinBackoff,
+		maxBackoff:  defaultNetLogMaxBackoff,
+		size:        defaultNetLogQueueSize,
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, option := range opts {
+		option(n)
+	}
+	n.lines = make(chan []byte, n.size)
+	go n.run()
+	return n
+}
+
+// NetLogTLS is a functional option that connects to the collector over TLS
+// with the given configuration, which may be nil to use the defaults.
+func NetLogTLS(config *tls.Config) NetLogOption {
+	return func(n *NetLogWriter) {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		n.tlsConfig = config
+	}
+}
+
+// NetLogQueueSize is a functional option that sets the number of log lines
+// which can be queued. It defaults to 1024.
+func NetLogQueueSize(size int) NetLogOption {
+	return func(n *NetLogWriter) {
+		if size > 0 {
+			n.size = size
+		}
+	}
+}
+
+// NetLogDialTimeout is a functional option that sets the timeout for
+// connecting to the collector. It defaults to 5 seconds.
+func NetLogDialTimeout(d time.Duration) NetLogOption {
+	return func(n *NetLogWriter) {
+		n.dialTimeout = d
+	}
+}
+
+// NetLogWriteTimeout is a functional option that sets the timeout for sending
+// a log line, after which the connection is considered broken. There is none
+// by default.
+func NetLogWriteTimeout(d time.Duration) NetLogOption {
+	return func(n *NetLogWriter) {
+		n.writeTimeout = d
+	}
+}
+
+// NetLogReconnectBackoff is a functional option that sets the delays between
+// attempts to connect to the collector, which start at first and double after
+// each failure up to limit. They default to 100 milliseconds and 30 seconds.
+func NetLogReconnectBackoff(first, limit time.Duration) NetLogOption {
+	return func(n *NetLogWriter) {
+		if first > 0 && limit >= first {
+			n.minBackoff, n.maxBackoff = first, limit
+		}
+	}
+}
+
+// Write queues a copy of p to be sent to the collector, or drops it if the
+// queue is full. It never reports network errors.
+func (n *NetLogWriter) Write(p []byte) (int, error) {
+	line := append([]byte(nil), p...)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return 0, ErrNetLogWriterClosed
+	}
+
+	select {
+	case n.lines <- line:
+	default:
+		n.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Close sends the queued lines if the collector is reachable, drops them
+// otherwise, and stops the background goroutine.
+func (n *NetLogWriter) Close() error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.lines)
+		close(n.quit)
+	}
+	n.mu.Unlock()
+
+	<-n.done
+	return nil
+}
+
+// Dropped returns the number of log lines dropped because the queue was full
+// or the collector was unreachable when the writer was closed.
+func (n *NetLogWriter) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+func (n *NetLogWriter) run() {
+	defer close(n.done)
+
+	var conn *netLogConn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+
+	backoff := n.minBackoff
+	for line := range n.lines {
+		for {
+			if conn != nil && conn.isBroken() {
+				_ = conn.Close()
+				conn = nil
+			}
+
+			fresh := false
+			if conn == nil {
+				c, err := n.dial()
+				if err != nil {
+					if !n.wait(backoff) {
+						n.drop(1)
+						return
+					}
+					backoff *= 2
+					if backoff > n.maxBackoff {
+						backoff = n.maxBackoff
+					}
+					continue
+				}
+				conn, fresh, backoff = c, true, n.minBackoff
+			}
+
+			if n.writeTimeout > 0 {
+				_ = conn.SetWriteDeadline(time.Now().Add(n.writeTimeout))
+			}
+			if _, err := conn.Write(line); err != nil {
+				_ = conn.Close()
+				conn = nil
+				if fresh {
+					// The line itself may be the problem, e.g. a datagram
+					// which is too large, so don't retry it forever.
+					n.dropped.Add(1)
+					break
+				}
+				continue
+			}
+			break
+		}
+	}
+}
+
+// netLogConn is a connection to a collector. Collectors don't send anything
+// back, so the connection is read in the background to notice when it is
+// closed by the collector, which writes wouldn't report before data is lost.
+type netLogConn struct {
+	net.Conn
+	broken chan struct{}
+}
+
+func newNetLogConn(conn net.Conn, stream bool) *netLogConn {
+	c := &netLogConn{Conn: conn, broken: make(chan struct{})}
+	if !stream {
+		return c
+	}
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		close(c.broken)
+	}()
+	return c
+}
+
+func (c *netLogConn) isBroken() bool {
+	select {
+	case <-c.broken:
+		return true
+	default:
+		return false
+	}
+}
+
+func (n *NetLogWriter) dial() (*netLogConn, error) {
+	dialer := &net.Dialer{Timeout: n.dialTimeout}
+	var conn net.Conn
+	var err error
+	if n.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, n.network, n.addr, n.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(n.network, n.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stream := !strings.HasPrefix(n.network, "udp") && !strings.HasPrefix(n.network, "ip") && n.network != "unixgram"
+	return newNetLogConn(conn, stream), nil
+}
+
+// wait waits for d, and reports whether it did so without the writer being
+// closed.
+func (n *NetLogWriter) wait(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-n.quit:
+		return false
+	}
+}
+
+// drop drops the given number of lines and those left in the queue, once the
+// writer is closed.
+func (n *NetLogWriter) drop(lines uint64) {
+	for range n.lines {
+		lines++
+	}
+	n.dropped.Add(lines)
+}
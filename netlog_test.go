@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetLogWriter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Read a single line per connection, forcing reconnections.
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			received <- line
+			conn.Close()
+		}
+	}()
+
+	out := NewNetLogWriter("tcp", ln.Addr().String(), NetLogReconnectBackoff(time.Millisecond, 10*time.Millisecond))
+	for _, line := range []string{"one\n", "two\n"} {
+		if _, err := out.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if got != line {
+				t.Errorf("got line %q, want %q", got, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("line %q wasn't received", line)
+		}
+		// Let the writer notice that the connection is closed.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := out.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := out.Write([]byte("late\n")); !errors.Is(err, ErrNetLogWriterClosed) {
+		t.Fatalf("Write after Close: got %v, want %v", err, ErrNetLogWriterClosed)
+	}
+}
+
+func TestNetLogWriterUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	out := NewNetLogWriter("tcp", addr, NetLogQueueSize(2), NetLogReconnectBackoff(time.Hour, time.Hour))
+	for i := 0; i < 10; i++ {
+		if _, err := out.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closed := make(chan error)
+	go func() { closed <- out.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked while the collector is unreachable")
+	}
+	if got := out.Dropped(); got != 10 {
+		t.Fatalf("got %d dropped lines, want 10", got)
+	}
+}
+
+func TestNetLogWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	out := NewNetLogWriter("udp", pc.LocalAddr().String())
+	defer out.Close()
+	_, _ = out.Write([]byte("datagram\n"))
+
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "datagram\n" {
+		t.Fatalf("got datagram %q, want %q", got, "datagram\n")
+	}
+}
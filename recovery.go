@@ -34,6 +34,9 @@ func parseRecoveryOptions(h http.Handler, opts ...RecoveryOption) http.Handler {
 // logs the panic, writes http.StatusInternalServerError, and
 // continues to the next handler.
 //
+// The log entry is prefixed with the ID assigned to the request by
+// RequestIDHandler, if any.
+//
 // Example:
 //
 //	r := mux.NewRouter()
@@ -70,10 +73,15 @@ func PrintRecoveryStack(shouldPrint bool) RecoveryOption {
 }
 
 func (h recoveryHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req, rec := withRequestRecord(req)
 	defer func() {
 		if err := recover(); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			h.log(err)
+			if id := rec.requestID(req); id != "" {
+				h.log("request_id="+id, err)
+			} else {
+				h.log(err)
+			}
 		}
 	}()
 
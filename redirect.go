@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Redirect is a rule of a RedirectMap.
+type Redirect struct {
+	// From is a regular expression matched against the whole request path
+	// or, if it doesn't begin with a slash, against the host of the request
+	// followed by its path, e.g. `old\.example\.com/(.*)`. Hosts are matched
+	// lowercased and without port.
+	From string
+	// To is the target URL, in which $1 or ${name} are replaced by the
+	// corresponding capture group of From.
+	To string
+	// Code is the redirect status: 301, 302, 303, 307 or 308. It defaults to
+	// 301 "Moved Permanently".
+	Code int
+	// KeepQuery appends the query of the request to the target URL if it has
+	// none of its own.
+	KeepQuery bool
+}
+
+type compiledRedirect struct {
+	Redirect
+	re       *regexp.Regexp
+	withHost bool
+}
+
+// RedirectMap is HTTP middleware redirecting requests according to a table of
+// rules, replacing the many tiny redirect handlers services tend to
+// accumulate. Rules are tried in order and the first match wins. Requests
+// matching no rule are passed to the wrapped handler.
+//
+// The rules can be replaced at runtime with Load, which is safe to call
+// concurrently with requests being served.
+//
+// Example:
+//
+//	redirects, err := handlers.NewRedirectMap([]handlers.Redirect{
+//		{From: `/blog/(\d+)/.*`, To: "/posts/$1"},
+//		{From: `/docs(/.*)?`, To: "https://docs.example.com$1", Code: http.StatusFound},
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe(":8000", redirects.Handler(r))
+type RedirectMap struct {
+	rules atomic.Value // []compiledRedirect
+}
+
+// NewRedirectMap returns a RedirectMap using rules, or an error if a rule is
+// invalid.
+func NewRedirectMap(rules []Redirect) (*RedirectMap, error) {
+	m := &RedirectMap{}
+	if err := m.Load(rules); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load replaces the rules of m. If a rule is invalid, an error is returned
+// and m is left unchanged.
+func (m *RedirectMap) Load(rules []Redirect) error {
+	compiled := make([]compiledRedirect, len(rules))
+	for i, rule := range rules {
+		switch rule.Code {
+		case 0:
+			rule.Code = http.StatusMovedPermanently
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return fmt.Errorf("handlers: redirect %q: invalid status code %d", rule.From, rule.Code)
+		}
+		re, err := regexp.Compile("^(?:" + rule.From + ")$")
+		if err != nil {
+			return fmt.Errorf("handlers: redirect %q: %w", rule.From, err)
+		}
+		compiled[i] = compiledRedirect{Redirect: rule, re: re, withHost: !strings.HasPrefix(rule.From, "/")}
+	}
+	m.rules.Store(compiled)
+	return nil
+}
+
+// ParseRedirects parses rules from a table with one rule per line, made of
+// the From and To fields of a Redirect followed by an optional status code,
+// separated by whitespace. Blank lines and lines beginning with # are
+// ignored.
+//
+//	# old blog URLs
+//	/blog/(\d+)/.*    /posts/$1
+//	/pricing          https://example.com/plans 302
+func ParseRedirects(r io.Reader) ([]Redirect, error) {
+	var rules []Redirect
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("handlers: redirects line %d: expected 2 or 3 fields, got %d", line, len(fields))
+		}
+		rule := Redirect{From: fields[0], To: fields[1]}
+		if len(fields) == 3 {
+			code, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("handlers: redirects line %d: invalid status code %q", line, fields[2])
+			}
+			rule.Code = code
+		}
+		rules = append(rules, rule)
+	}
+	return rules, s.Err()
+}
+
+// Handler returns a handler redirecting requests matching a rule and passing
+// all others to h.
+func (m *RedirectMap) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, code, ok := m.match(r); ok {
+			http.Redirect(w, r, target, code)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// match returns the target and status of the first rule matching r.
+func (m *RedirectMap) match(r *http.Request) (string, int, bool) {
+	rules, _ := m.rules.Load().([]compiledRedirect)
+	for _, rule := range rules {
+		subject := r.URL.Path
+		if rule.withHost {
+			subject = strings.ToLower(hostWithoutPort(r.Host)) + subject
+		}
+		match := rule.re.FindStringSubmatchIndex(subject)
+		if match == nil {
+			continue
+		}
+		target := string(rule.re.ExpandString(nil, rule.To, subject, match))
+		if rule.KeepQuery && r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+			target += "?" + r.URL.RawQuery
+		}
+		return target, rule.Code, true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedirectMap(t *testing.T) {
+	m, err := NewRedirectMap([]Redirect{
+		{From: `/blog/(?P<id>\d+)/.*`, To: "/posts/${id}"},
+		{From: `/docs(/.*)?`, To: "https://docs.example.com$1", Code: http.StatusFound},
+		{From: `old\.example\.com/(.*)`, To: "https://example.com/$1", Code: http.StatusPermanentRedirect, KeepQuery: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Handler(okHandler)
+
+	tests := []struct {
+		url, location string
+		code          int
+	}{
+		{"http://example.com/blog/42/some-title", "/posts/42", http.StatusMovedPermanently},
+		{"http://example.com/docs", "https://docs.example.com", http.StatusFound},
+		{"http://example.com/docs/api", "https://docs.example.com/api", http.StatusFound},
+		{"http://OLD.example.com:8080/a/b?x=1", "https://example.com/a/b?x=1", http.StatusPermanentRedirect},
+		{"http://example.com/blog/abc", "", http.StatusOK},
+		{"http://example.com/documents", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, test.url))
+		if rr.Code != test.code || rr.Header().Get("Location") != test.location {
+			t.Errorf("%s: got %v %q want %v %q", test.url, rr.Code, rr.Header().Get("Location"), test.code, test.location)
+		}
+	}
+
+	if err := m.Load([]Redirect{{From: "/a", To: "/b", Code: http.StatusOK}}); err == nil {
+		t.Fatal("invalid status code accepted")
+	}
+	if err := m.Load([]Redirect{{From: "/a(", To: "/b"}}); err == nil {
+		t.Fatal("invalid pattern accepted")
+	}
+	if err := m.Load([]Redirect{{From: "/blog/.*", To: "/"}}); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "http://example.com/blog/42/x"))
+	if rr.Header().Get("Location") != "/" {
+		t.Fatalf("rules not reloaded: %q", rr.Header().Get("Location"))
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	rules, err := ParseRedirects(strings.NewReader(`
+# comment
+/old   /new
+/tmp   /elsewhere   307
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0] != (Redirect{From: "/old", To: "/new"}) || rules[1].Code != http.StatusTemporaryRedirect {
+		t.Fatalf("bad rules: %+v", rules)
+	}
+
+	for _, s := range []string{"/only-one-field", "/a /b /c /d", "/a /b permanent"} {
+		if _, err := ParseRedirects(strings.NewReader(s)); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+)
+
+type requestHeaderChangesKey struct{}
+
+// RequestHeaderChange records a request header set by
+// RequestHeaderDefaults.
+type RequestHeaderChange struct {
+	Name  string
+	Value string
+	// Previous is the value the header had before, if it was overwritten.
+	Previous string
+	// Overwritten reports whether the header was present before.
+	Overwritten bool
+}
+
+type requestHeaderRule struct {
+	name      string
+	fn        func(*http.Request) string
+	overwrite bool
+}
+
+type requestHeaderDefaults struct {
+	h     http.Handler
+	rules []requestHeaderRule
+}
+
+// RequestHeaderOption provides a functional approach to configure the
+// RequestHeaderDefaults middleware.
+type RequestHeaderOption func(*requestHeaderDefaults)
+
+// RequestHeaderDefaults is HTTP middleware adding default and computed
+// headers to requests before they reach the wrapped handler, e.g. a default
+// Accept header, a tenant header derived from the host, or the client's
+// country looked up from its address. Rules are applied in order, so later
+// ones see the headers set by earlier ones.
+//
+// The changes made are available to the next handler via
+// RequestHeaderChanges, for auditing.
+//
+// Example:
+//
+//	defaults := handlers.RequestHeaderDefaults(
+//		handlers.RequestHeaderDefault("Accept", "application/json"),
+//		handlers.RequestHeaderSet("X-Client-Country", func(r *http.Request) string {
+//			return geo.Country(r.RemoteAddr)
+//		}),
+//	)
+//	http.ListenAndServe(":8000", defaults(r))
+func RequestHeaderDefaults(opts ...RequestHeaderOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		d := &requestHeaderDefaults{h: h}
+		for _, option := range opts {
+			option(d)
+		}
+		return d
+	}
+}
+
+// RequestHeaderDefault is a functional option that sets the header name to
+// value on requests which lack it.
+func RequestHeaderDefault(name, value string) RequestHeaderOption {
+	return RequestHeaderDefaultFunc(name, func(*http.Request) string {
+		return value
+	})
+}
+
+// RequestHeaderDefaultFunc is a functional option that sets the header name
+// to the value computed by fn on requests which lack it. Nothing is set if fn
+// returns "".
+func RequestHeaderDefaultFunc(name string, fn func(*http.Request) string) RequestHeaderOption {
+	return func(d *requestHeaderDefaults) {
+		d.rules = append(d.rules, requestHeaderRule{name: http.CanonicalHeaderKey(name), fn: fn})
+	}
+}
+
+// RequestHeaderSet is a functional option that sets the header name to the
+// value computed by fn on every request, replacing any value sent by the
+// client, which must not be trusted for headers such as geolocation. If fn
+// returns "", the header is removed.
+func RequestHeaderSet(name string, fn func(*http.Request) string) RequestHeaderOption {
+	return func(d *requestHeaderDefaults) {
+		d.rules = append(d.rules, requestHeaderRule{name: http.CanonicalHeaderKey(name), fn: fn, overwrite: true})
+	}
+}
+
+// RequestHeaderChanges returns the request header changes made by
+// RequestHeaderDefaults, in the order they were made.
+func RequestHeaderChanges(ctx context.Context) []RequestHeaderChange {
+	changes, _ := ctx.Value(requestHeaderChangesKey{}).([]RequestHeaderChange)
+	return changes
+}
+
+func (d *requestHeaderDefaults) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var changes []RequestHeaderChange
+	for _, rule := range d.rules {
+		previous, present := r.Header[rule.name]
+		if present && !rule.overwrite {
+			continue
+		}
+		value := rule.fn(r)
+		if value == "" && !present {
+			continue
+		}
+		change := RequestHeaderChange{Name: rule.name, Value: value, Overwritten: present}
+		if present && len(previous) > 0 {
+			change.Previous = previous[0]
+		}
+		if value == "" {
+			r.Header.Del(rule.name)
+		} else {
+			r.Header.Set(rule.name, value)
+		}
+		changes = append(changes, change)
+	}
+
+	if len(changes) > 0 {
+		changes = append(RequestHeaderChanges(r.Context()), changes...)
+		r = r.WithContext(context.WithValue(r.Context(), requestHeaderChangesKey{}, changes))
+	}
+	d.h.ServeHTTP(w, r)
+}
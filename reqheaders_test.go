@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestHeaderDefaults(t *testing.T) {
+	var got http.Header
+	var changes []RequestHeaderChange
+	h := RequestHeaderDefaults(
+		RequestHeaderDefault("Accept", "application/json"),
+		RequestHeaderDefaultFunc("X-Tenant", func(r *http.Request) string {
+			return strings.TrimSuffix(hostWithoutPort(r.Host), ".example.com")
+		}),
+		RequestHeaderSet("X-Client-Country", func(r *http.Request) string {
+			if strings.HasPrefix(r.RemoteAddr, "192.0.2.") {
+				return "NZ"
+			}
+			return ""
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		changes = RequestHeaderChanges(r.Context())
+	}))
+
+	r := newRequest(http.MethodGet, "http://acme.example.com/")
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Accept", "text/html")
+	r.Header.Set("X-Client-Country", "US")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got.Get("Accept") != "text/html" || got.Get("X-Tenant") != "acme" || got.Get("X-Client-Country") != "NZ" {
+		t.Fatalf("bad headers: %v", got)
+	}
+	want := []RequestHeaderChange{
+		{Name: "X-Tenant", Value: "acme"},
+		{Name: "X-Client-Country", Value: "NZ", Previous: "US", Overwritten: true},
+	}
+	if len(changes) != len(want) || changes[0] != want[0] || changes[1] != want[1] {
+		t.Fatalf("bad changes: got %+v want %+v", changes, want)
+	}
+
+	// Spoofed computed headers are removed when nothing is computed.
+	r = newRequest(http.MethodGet, "http://acme.example.com/")
+	r.RemoteAddr = "198.51.100.1:1234"
+	r.Header.Set("X-Client-Country", "US")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if _, ok := got["X-Client-Country"]; ok || got.Get("Accept") != "application/json" {
+		t.Fatalf("bad headers: %v", got)
+	}
+	if len(changes) != 3 || changes[2].Value != "" || !changes[2].Overwritten {
+		t.Fatalf("bad changes: %+v", changes)
+	}
+}
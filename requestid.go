@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	maxRequestIDLength     = 128
+)
+
+type requestIDKey struct{}
+
+type requestIDHandler struct {
+	h            http.Handler
+	header       string
+	generator    func() string
+	trustInbound bool
+}
+
+// RequestIDOption provides a functional approach to configure the
+// RequestIDHandler middleware.
+type RequestIDOption func(*requestIDHandler)
+
+// RequestIDHandler is HTTP middleware that assigns an ID to every request.
+// The ID is taken from the X-Request-ID header of the incoming request when
+// present and well-formed, and generated otherwise. It is stored in the
+// request context (see RequestIDFromContext) and set on the response.
+//
+// LoggingHandler and RecoveryHandler pick up the ID, regardless of whether they
+// wrap RequestIDHandler or are wrapped by it, so access log entries and panic
+// logs can be joined with application logs.
+//
+// Example:
+//
+//	r := mux.NewRouter()
+//	r.HandleFunc("/", YourHandler)
+//
+//	h := handlers.LoggingHandler(os.Stdout, handlers.RequestIDHandler()(r))
+//	http.ListenAndServe(":8000", h)
+func RequestIDHandler(opts ...RequestIDOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		rh := &requestIDHandler{
+			h:            h,
+			header:       defaultRequestIDHeader,
+			generator:    GenerateUUID,
+			trustInbound: true,
+		}
+		for _, option := range opts {
+			option(rh)
+		}
+		return rh
+	}
+}
+
+// RequestIDHeader is a functional option that sets the name of the request
+// and response header carrying the request ID. It defaults to X-Request-ID.
+func RequestIDHeader(name string) RequestIDOption {
+	return func(rh *requestIDHandler) {
+		rh.header = http.CanonicalHeaderKey(name)
+	}
+}
+
+// RequestIDGenerator is a functional option that sets the function generating
+// new request IDs. It defaults to GenerateUUID; GenerateULID may be used for
+// lexically sortable IDs.
+func RequestIDGenerator(fn func() string) RequestIDOption {
+	return func(rh *requestIDHandler) {
+		rh.generator = fn
+	}
+}
+
+// RequestIDTrustInbound is a functional option that controls whether a
+// request ID supplied by the client is re-used. It defaults to true; disable
+// it when the server is directly exposed to untrusted clients.
+func RequestIDTrustInbound(trust bool) RequestIDOption {
+	return func(rh *requestIDHandler) {
+		rh.trustInbound = trust
+	}
+}
+
+// RequestIDFromContext returns the request ID assigned by RequestIDHandler, or
+// the empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func (rh *requestIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(rh.header)
+	if !rh.trustInbound || !validRequestID(id) {
+		id = rh.generator()
+	}
+
+	if rec := requestRecordFromContext(r.Context()); rec != nil {
+		rec.setRequestID(id)
+	}
+
+	w.Header().Set(rh.header, id)
+	rh.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+}
+
+// validRequestID reports whether id is safe to re-use: it must be non-empty,
+// reasonably short and consist of printable ASCII characters only, so that it
+// cannot be used to forge log entries.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateUUID returns a random (version 4) UUID in its canonical textual
+// representation.
+func GenerateUUID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // variant 10
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// crockford is the base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// GenerateULID returns a ULID (https://github.com/ulid/spec) for the current
+// time. ULIDs sort lexically in order of creation, to millisecond precision.
+func GenerateULID() string {
+	var u [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(u[:6], ts[2:])
+	_, _ = rand.Read(u[6:])
+
+	// Encode the 128 bits as 26 characters of 5 bits each, the first
+	// character only carrying the 3 most significant bits.
+	var buf [26]byte
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDHandlerGenerates(t *testing.T) {
+	var got string
+	h := RequestIDHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestIDFromContext(r.Context())
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	if got == "" {
+		t.Fatal("expected a request ID in the context")
+	}
+	if hdr := rr.Header().Get("X-Request-ID"); hdr != got {
+		t.Fatalf("bad response header: got %q want %q", hdr, got)
+	}
+}
+
+func TestRequestIDHandlerInbound(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []RequestIDOption
+		header  string
+		inbound string
+		reused  bool
+	}{
+		{"default header", nil, "X-Request-ID", "abc-123", true},
+		{"custom header", []RequestIDOption{RequestIDHeader("x-correlation-id")}, "X-Correlation-Id", "abc-123", true},
+		{"untrusted", []RequestIDOption{RequestIDTrustInbound(false)}, "X-Request-ID", "abc-123", false},
+		{"invalid characters", nil, "X-Request-ID", "abc\n123", false},
+		{"too long", nil, "X-Request-ID", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		var got string
+		h := RequestIDHandler(tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = RequestIDFromContext(r.Context())
+		}))
+
+		r := newRequest(http.MethodGet, "/")
+		r.Header.Set(tt.header, tt.inbound)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if reused := got == tt.inbound; reused != tt.reused {
+			t.Errorf("%s: got ID %q, inbound %q, want reused=%v", tt.name, got, tt.inbound, tt.reused)
+		}
+		if hdr := rr.Header().Get(tt.header); hdr != got {
+			t.Errorf("%s: bad response header: got %q want %q", tt.name, hdr, got)
+		}
+	}
+}
+
+func TestRequestIDLogging(t *testing.T) {
+	var params LogFormatterParams
+	formatter := func(w io.Writer, p LogFormatterParams) { params = p }
+	gen := RequestIDGenerator(func() string { return "generated" })
+
+	// LoggingHandler wrapping RequestIDHandler.
+	CustomLoggingHandler(io.Discard, RequestIDHandler(gen)(okHandler), formatter).
+		ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	if params.RequestID != "generated" {
+		t.Fatalf("bad request ID: got %q want %q", params.RequestID, "generated")
+	}
+
+	// RequestIDHandler wrapping LoggingHandler.
+	params = LogFormatterParams{}
+	RequestIDHandler(gen)(CustomLoggingHandler(io.Discard, okHandler, formatter)).
+		ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	if params.RequestID != "generated" {
+		t.Fatalf("bad request ID: got %q want %q", params.RequestID, "generated")
+	}
+}
+
+func TestRequestIDRecovery(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("Unexpected error!")
+	})
+	gen := RequestIDGenerator(func() string { return "generated" })
+
+	RecoveryHandler(RecoveryLogger(logger))(RequestIDHandler(gen)(panicking)).
+		ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	RequestIDHandler(gen)(RecoveryHandler(RecoveryLogger(logger))(panicking)).
+		ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+
+	want := "request_id=generated Unexpected error!\n"
+	if got := buf.String(); got != want+want {
+		t.Fatalf("Got log %q, wanted %q", got, want+want)
+	}
+}
+
+func TestGenerateRequestIDs(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if id := GenerateUUID(); !uuid.MatchString(id) {
+		t.Errorf("GenerateUUID() = %q, not a version 4 UUID", id)
+	}
+
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	a, b := GenerateULID(), GenerateULID()
+	if !ulid.MatchString(a) {
+		t.Errorf("GenerateULID() = %q, not a ULID", a)
+	}
+	if a == b {
+		t.Errorf("GenerateULID() returned %q twice", a)
+	}
+}
@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+const (
+	defaultResponseCacheTTL        = time.Minute
+	defaultResponseCacheMaxEntries = 1024
+	defaultResponseCacheMaxBytes   = 32 << 20
+)
+
+// ResponseCache is an in-memory cache for responses to idempotent GET and
+// HEAD requests, for use in front of expensive, read-mostly endpoints. Create
+// one with NewResponseCache and wrap handlers with its Handler method; a single
+// cache may wrap several handlers.
+//
+// Only successful (200 OK) responses to GET requests are stored, and only when
+// neither the request nor the response opt out of caching: requests carrying
+// an Authorization header and responses with Cache-Control no-store, no-cache
+// or private, a Set-Cookie header or a Vary: * header are never stored. Stored
+// responses are served to both GET and HEAD requests. Entries are keyed by
+// host and request URI, plus the values of the request headers named in the
+// Vary header of the response.
+//
+// A successful request with an unsafe method (such as POST or DELETE)
+// invalidates the entries stored for its URI, as recommended by RFC 7234,
+// section 4.4. Entries can also be invalidated explicitly using Invalidate,
+// InvalidateFunc and Purge.
+type ResponseCache struct {
+	ttl        time.Duration
+	swr        time.Duration
+	maxEntries int
+	maxBytes   int64
+	keyFunc    func(*http.Request) string
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	varies  map[string][]string
+	lru     *list.List
+	size    int64
+}
+
+type cacheEntry struct {
+	key        string
+	base       string
+	code       int
+	header     http.Header
+	body       []byte
+	size       int64
+	stored     time.Time
+	expires    time.Time
+	refreshing bool
+	elem       *list.Element
+}
+
+// ResponseCacheOption provides a functional approach to configure a
+// ResponseCache.
+type ResponseCacheOption func(*ResponseCache)
+
+// NewResponseCache returns a new ResponseCache. By default entries live for a
+// minute, and the cache holds at most 1024 entries and 32 MiB of responses.
+//
+// Example:
+//
+//	cache := handlers.NewResponseCache(
+//		handlers.ResponseCacheTTL(30*time.Second),
+//		handlers.ResponseCacheStaleWhileRevalidate(time.Minute),
+//	)
+//	r.Handle("/leaderboard", cache.Handler(LeaderboardHandler))
+func NewResponseCache(opts ...ResponseCacheOption) *ResponseCache {
+	c := &ResponseCache{
+		ttl:        defaultResponseCacheTTL,
+		maxEntries: defaultResponseCacheMaxEntries,
+		maxBytes:   defaultResponseCacheMaxBytes,
+		keyFunc:    defaultResponseCacheKey,
+		entries:    make(map[string]*cacheEntry),
+		varies:     make(map[string][]string),
+		lru:        list.New(),
+	}
+	for _, option := range opts {
+		option(c)
+	}
+	return c
+}
+
+// ResponseCacheTTL is a functional option that sets how long responses are
+// served from the cache.
+func ResponseCacheTTL(d time.Duration) ResponseCacheOption {
+	return func(c *ResponseCache) {
+		c.ttl = d
+	}
+}
+
+// ResponseCacheStaleWhileRevalidate is a functional option that keeps serving
+// expired entries for up to d past their expiry, while a single request
+// refreshes the entry in the background.
+func ResponseCacheStaleWhileRevalidate(d time.Duration) ResponseCacheOption {
+	return func(c *ResponseCache) {
+		c.swr = d
+	}
+}
+
+// ResponseCacheMaxEntries is a functional option that bounds the number of
+// entries in the cache. The least recently used entries are evicted first.
+func ResponseCacheMaxEntries(n int) ResponseCacheOption {
+	return func(c *ResponseCache) {
+		c.maxEntries = n
+	}
+}
+
+// ResponseCacheMaxBytes is a functional option that bounds the total size of
+// the cached responses. Larger responses are never stored.
+func ResponseCacheMaxBytes(n int64) ResponseCacheOption {
+	return func(c *ResponseCache) {
+		c.maxBytes = n
+	}
+}
+
+// ResponseCacheKey is a functional option that sets the function computing
+// the cache key of a request, before Vary processing. It defaults to the host
+// followed by the request URI.
+func ResponseCacheKey(fn func(*http.Request) string) ResponseCacheOption {
+	return func(c *ResponseCache) {
+		c.keyFunc = fn
+	}
+}
+
+func defaultResponseCacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+// Handler returns a handler serving responses of h from the cache.
+func (c *ResponseCache) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			SetCacheStatus(r.Context(), "BYPASS")
+			logger, lw := makeLogger(w)
+			h.ServeHTTP(lw, r)
+			if logger.Status() < http.StatusBadRequest {
+				c.invalidateBase(c.keyFunc(r))
+			}
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			SetCacheStatus(r.Context(), "BYPASS")
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		base := c.keyFunc(r)
+		if entry, stale := c.lookup(base, r); entry != nil {
+			if stale {
+				SetCacheStatus(r.Context(), "STALE")
+				refresh := r.Clone(context.Background())
+				// HEAD responses have no body to store.
+				refresh.Method = http.MethodGet
+				go c.refresh(h, refresh, base)
+			} else {
+				SetCacheStatus(r.Context(), "HIT")
+			}
+			c.serve(w, r, entry)
+			return
+		}
+		SetCacheStatus(r.Context(), "MISS")
+
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &cacheWriter{w: w, limit: c.maxBytes}
+		h.ServeHTTP(cw.wrap(), r)
+		c.store(base, r, cw)
+	})
+}
+
+// Invalidate removes the entries stored for the URI of r, in all variants.
+func (c *ResponseCache) Invalidate(r *http.Request) {
+	c.invalidateBase(c.keyFunc(r))
+}
+
+// InvalidateFunc removes all entries whose key, as computed by the key
+// function before Vary processing, satisfies fn.
+func (c *ResponseCache) InvalidateFunc(fn func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.entries {
+		if fn(e.base) {
+			c.remove(e)
+		}
+	}
+}
+
+// Purge removes all entries from the cache.
+func (c *ResponseCache) Purge() {
+	c.InvalidateFunc(func(string) bool { return true })
+}
+
+// Len returns the number of entries in the cache.
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *ResponseCache) invalidateBase(base string) {
+	c.InvalidateFunc(func(key string) bool { return key == base })
+}
+
+// lookup returns the fresh or stale entry for r, if any. stale is only true
+// for the caller which is responsible for refreshing the entry.
+func (c *ResponseCache) lookup(base string, r *http.Request) (entry *cacheEntry, stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[variantKey(base, c.varies[base], r)]
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	if now.Before(e.expires) {
+		c.lru.MoveToFront(e.elem)
+		return e, false
+	}
+	if now.Before(e.expires.Add(c.swr)) {
+		c.lru.MoveToFront(e.elem)
+		if !e.refreshing {
+			e.refreshing = true
+			return e, true
+		}
+		return e, false
+	}
+
+	c.remove(e)
+	return nil, false
+}
+
+// refresh runs h for r in the background and stores its response.
+func (c *ResponseCache) refresh(h http.Handler, r *http.Request, base string) {
+	cw := &cacheWriter{w: &responseBuffer{header: make(http.Header)}, limit: c.maxBytes}
+	h.ServeHTTP(cw.wrap(), r)
+	if !c.store(base, r, cw) {
+		// Let another request try again.
+		c.mu.Lock()
+		if e, ok := c.entries[variantKey(base, c.varies[base], r)]; ok {
+			e.refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+// store adds the response captured by cw to the cache if it may be stored,
+// reporting whether it was.
+func (c *ResponseCache) store(base string, r *http.Request, cw *cacheWriter) bool {
+	if cw.code == 0 {
+		// Nothing was written: an empty 200 OK.
+		cw.code = http.StatusOK
+		cw.header = cw.w.Header().Clone()
+	}
+	if cw.overflow || cw.code != http.StatusOK || !cacheableResponse(cw.header) {
+		return false
+	}
+
+	vary := varyHeaders(cw.header)
+	now := time.Now()
+	e := &cacheEntry{
+		base:    base,
+		code:    cw.code,
+		header:  cw.header,
+		body:    cw.buf.Bytes(),
+		stored:  now,
+		expires: now.Add(c.ttl),
+	}
+	e.size = int64(len(e.body))
+	for k, vv := range e.header {
+		e.size += int64(len(k))
+		for _, v := range vv {
+			e.size += int64(len(v))
+		}
+	}
+	if e.size > c.maxBytes {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.varies[base] = vary
+	e.key = variantKey(base, vary, r)
+	if old, ok := c.entries[e.key]; ok {
+		c.remove(old)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[e.key] = e
+	c.size += e.size
+
+	for len(c.entries) > c.maxEntries || c.size > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*cacheEntry))
+	}
+	return true
+}
+
+// remove deletes e from the cache. The caller must hold c.mu.
+func (c *ResponseCache) remove(e *cacheEntry) {
+	if c.entries[e.key] != e {
+		return
+	}
+	delete(c.entries, e.key)
+	c.lru.Remove(e.elem)
+	c.size -= e.size
+
+	for _, other := range c.entries {
+		if other.base == e.base {
+			return
+		}
+	}
+	delete(c.varies, e.base)
+}
+
+// serve writes the cached response e to w.
+func (c *ResponseCache) serve(w http.ResponseWriter, r *http.Request, e *cacheEntry) {
+	h := w.Header()
+	for k, vv := range e.header {
+		h[k] = append([]string(nil), vv...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(e.stored)/time.Second)))
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.code)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.body)
+	}
+}
+
+// cacheableResponse reports whether a response with headers h may be stored
+// in a shared cache.
+func cacheableResponse(h http.Header) bool {
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, v := range h.Values(cacheControlHeader) {
+		for _, directive := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-store", "no-cache", "private":
+				return false
+			}
+		}
+	}
+	for _, name := range varyHeaders(h) {
+		if name == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// varyHeaders returns the sorted, canonicalized header names listed in the
+// Vary headers of h.
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variantKey returns the key of the variant of base selected by the values of
+// the vary headers of r.
+func variantKey(base string, vary []string, r *http.Request) string {
+	if len(vary) == 0 {
+		return base
+	}
+
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// cacheWriter passes a response through to w while keeping a copy of it, up
+// to limit bytes.
+type cacheWriter struct {
+	w        http.ResponseWriter
+	limit    int64
+	code     int
+	header   http.Header
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (cw *cacheWriter) wrap() http.ResponseWriter {
+	return httpsnoop.Wrap(cw.w, httpsnoop.Hooks{
+		WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return cw.WriteHeader
+		},
+		Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return cw.Write
+		},
+		ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				return io.Copy(writerOnly{cw}, src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				if cw.code == 0 {
+					cw.WriteHeader(http.StatusOK)
+				}
+				next()
+			}
+		},
+	})
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	// Interim responses are passed on but not stored.
+	if cw.code == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		cw.code = code
+		cw.header = cw.w.Header().Clone()
+	}
+	cw.w.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.overflow {
+		if int64(cw.buf.Len()+len(b)) > cw.limit {
+			cw.overflow = true
+			cw.buf = bytes.Buffer{}
+		} else {
+			cw.buf.Write(b)
+		}
+	}
+	return cw.w.Write(b)
+}
+
+// responseBuffer is an http.ResponseWriter recording a response in memory.
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(code int) {
+	// Interim responses can't be replayed.
+	if rb.code == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		rb.code = code
+	}
+}
+
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	if rb.code == 0 {
+		rb.code = http.StatusOK
+	}
+	return rb.body.Write(b)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingHandler responds with the number of times it has been called.
+func countingHandler(calls *int32, header http.Header) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		for k, vv := range header {
+			w.Header()[k] = vv
+		}
+		fmt.Fprintf(w, "%d:%s", n, r.Header.Get("Accept-Language"))
+	})
+}
+
+func cacheGet(h http.Handler, method, url string, header ...string) *httptest.ResponseRecorder {
+	r := newRequest(method, url)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	var calls int32
+	h := NewResponseCache().Handler(countingHandler(&calls, nil))
+
+	for i := 0; i < 3; i++ {
+		if rr := cacheGet(h, http.MethodGet, "http://example.com/a"); rr.Body.String() != "1:" {
+			t.Fatalf("bad body: got %q want %q", rr.Body.String(), "1:")
+		}
+	}
+	rr := cacheGet(h, http.MethodHead, "http://example.com/a")
+	if rr.Body.Len() != 0 || rr.Header().Get("Content-Length") != "2" {
+		t.Fatalf("bad HEAD response: body %q, Content-Length %q", rr.Body.String(), rr.Header().Get("Content-Length"))
+	}
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/b"); rr.Body.String() != "2:" {
+		t.Fatalf("bad body: got %q want %q", rr.Body.String(), "2:")
+	}
+}
+
+func TestResponseCacheNotStored(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		req    []string
+	}{
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, nil},
+		{"private", http.Header{"Cache-Control": {"max-age=60, private"}}, nil},
+		{"cookie", http.Header{"Set-Cookie": {"a=b"}}, nil},
+		{"vary all", http.Header{"Vary": {"*"}}, nil},
+		{"authorization", nil, []string{"Authorization", "Basic Zm9vOmJhcg=="}},
+	}
+
+	for _, tt := range tests {
+		var calls int32
+		h := NewResponseCache().Handler(countingHandler(&calls, tt.header))
+		cacheGet(h, http.MethodGet, "http://example.com/", tt.req...)
+		cacheGet(h, http.MethodGet, "http://example.com/", tt.req...)
+		if calls != 2 {
+			t.Errorf("%s: handler called %d times, want 2", tt.name, calls)
+		}
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	var calls int32
+	h := NewResponseCache().Handler(countingHandler(&calls, http.Header{"Vary": {"Accept-Language"}}))
+
+	tests := []struct {
+		lang, body string
+	}{
+		{"en", "1:en"},
+		{"fr", "2:fr"},
+		{"en", "1:en"},
+		{"fr", "2:fr"},
+	}
+	for _, tt := range tests {
+		if rr := cacheGet(h, http.MethodGet, "http://example.com/", "Accept-Language", tt.lang); rr.Body.String() != tt.body {
+			t.Fatalf("bad body for %s: got %q want %q", tt.lang, rr.Body.String(), tt.body)
+		}
+	}
+}
+
+func TestResponseCacheExpiry(t *testing.T) {
+	var calls int32
+	h := NewResponseCache(ResponseCacheTTL(10 * time.Millisecond)).Handler(countingHandler(&calls, nil))
+
+	cacheGet(h, http.MethodGet, "http://example.com/")
+	time.Sleep(20 * time.Millisecond)
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/"); rr.Body.String() != "2:" {
+		t.Fatalf("bad body: got %q want %q", rr.Body.String(), "2:")
+	}
+}
+
+func TestResponseCacheStaleWhileRevalidate(t *testing.T) {
+	var calls int32
+	h := NewResponseCache(
+		ResponseCacheTTL(10*time.Millisecond),
+		ResponseCacheStaleWhileRevalidate(time.Minute),
+	).Handler(countingHandler(&calls, nil))
+
+	cacheGet(h, http.MethodGet, "http://example.com/")
+	time.Sleep(20 * time.Millisecond)
+
+	// The stale entry is served while it is refreshed in the background.
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/"); rr.Body.String() != "1:" {
+		t.Fatalf("bad body: got %q want %q", rr.Body.String(), "1:")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		rr := cacheGet(h, http.MethodGet, "http://example.com/")
+		if rr.Body.String() == "2:" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry not refreshed, last body %q", rr.Body.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("handler called %d times, want 2", n)
+	}
+}
+
+func TestResponseCacheStaleHead(t *testing.T) {
+	var calls int32
+	// Like http.ServeContent, the handler writes no body for HEAD requests.
+	h := NewResponseCache(
+		ResponseCacheTTL(10*time.Millisecond),
+		ResponseCacheStaleWhileRevalidate(time.Minute),
+	).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.Method != http.MethodHead {
+			fmt.Fprintf(w, "%d", n)
+		}
+	}))
+
+	cacheGet(h, http.MethodGet, "http://example.com/")
+	time.Sleep(20 * time.Millisecond)
+
+	// A HEAD request triggers the refresh of the stale entry.
+	cacheGet(h, http.MethodHead, "http://example.com/")
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("entry not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for {
+		rr := cacheGet(h, http.MethodGet, "http://example.com/")
+		if rr.Body.String() == "2" {
+			break
+		}
+		if rr.Body.String() != "1" || time.Now().After(deadline) {
+			t.Fatalf("bad body after HEAD refresh: got %q want %q", rr.Body.String(), "2")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResponseCacheBounds(t *testing.T) {
+	var calls int32
+	c := NewResponseCache(ResponseCacheMaxEntries(2))
+	h := c.Handler(countingHandler(&calls, nil))
+
+	cacheGet(h, http.MethodGet, "http://example.com/a")
+	cacheGet(h, http.MethodGet, "http://example.com/b")
+	cacheGet(h, http.MethodGet, "http://example.com/a") // a is now most recently used
+	cacheGet(h, http.MethodGet, "http://example.com/c") // evicts b
+
+	if n := c.Len(); n != 2 {
+		t.Fatalf("bad number of entries: got %d want 2", n)
+	}
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/a"); rr.Body.String() != "1:" {
+		t.Fatalf("bad body for a: got %q want %q", rr.Body.String(), "1:")
+	}
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/b"); rr.Body.String() != "4:" {
+		t.Fatalf("bad body for b: got %q want %q", rr.Body.String(), "4:")
+	}
+
+	c = NewResponseCache(ResponseCacheMaxBytes(8))
+	large := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 16)))
+	}))
+	if rr := cacheGet(large, http.MethodGet, "http://example.com/"); rr.Body.Len() != 16 {
+		t.Fatalf("bad body length: got %d want 16", rr.Body.Len())
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("oversized response stored")
+	}
+}
+
+func TestResponseCacheInvalidation(t *testing.T) {
+	var calls int32
+	c := NewResponseCache()
+	h := c.Handler(countingHandler(&calls, nil))
+
+	cacheGet(h, http.MethodGet, "http://example.com/a")
+	c.Invalidate(newRequest(http.MethodGet, "http://example.com/a"))
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/a"); rr.Body.String() != "2:" {
+		t.Fatalf("bad body after Invalidate: got %q want %q", rr.Body.String(), "2:")
+	}
+
+	// Successful unsafe requests invalidate the URI.
+	cacheGet(h, http.MethodPost, "http://example.com/a")
+	if rr := cacheGet(h, http.MethodGet, "http://example.com/a"); rr.Body.String() != "4:" {
+		t.Fatalf("bad body after POST: got %q want %q", rr.Body.String(), "4:")
+	}
+
+	cacheGet(h, http.MethodGet, "http://example.com/b")
+	c.InvalidateFunc(func(key string) bool { return strings.HasSuffix(key, "/b") })
+	if n := c.Len(); n != 1 {
+		t.Fatalf("bad number of entries after InvalidateFunc: got %d want 1", n)
+	}
+	c.Purge()
+	if n := c.Len(); n != 0 {
+		t.Fatalf("bad number of entries after Purge: got %d want 0", n)
+	}
+}
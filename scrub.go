@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type scrubHandler struct {
+	h        http.Handler
+	remove   []string
+	prefixes []string
+	rewrite  map[string]func(string) string
+}
+
+// ScrubOption provides a functional approach to configure the
+// ScrubResponseHeaders middleware.
+type ScrubOption func(*scrubHandler)
+
+// ScrubResponseHeaders is HTTP middleware removing or rewriting response
+// headers right before they are sent, whichever handler down the chain set
+// them. The Server and X-Powered-By headers are removed by default, as they
+// needlessly reveal the software being run.
+//
+// Example:
+//
+//	scrub := handlers.ScrubResponseHeaders(
+//		handlers.ScrubPrefix("X-Debug-"),
+//		handlers.ScrubRewrite("Via", func(string) string { return "proxy" }),
+//	)
+//	http.ListenAndServe(":8000", scrub(r))
+func ScrubResponseHeaders(opts ...ScrubOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		s := &scrubHandler{
+			h:       h,
+			remove:  []string{"Server", "X-Powered-By"},
+			rewrite: make(map[string]func(string) string),
+		}
+		for _, option := range opts {
+			option(s)
+		}
+		return s
+	}
+}
+
+// ScrubRemove is a functional option that removes the given headers, in
+// addition to the defaults.
+func ScrubRemove(names ...string) ScrubOption {
+	return func(s *scrubHandler) {
+		for _, name := range names {
+			s.remove = append(s.remove, http.CanonicalHeaderKey(name))
+		}
+	}
+}
+
+// ScrubKeep is a functional option that stops removing the given headers,
+// e.g. to keep the Server header which is removed by default.
+func ScrubKeep(names ...string) ScrubOption {
+	return func(s *scrubHandler) {
+		keep := make(map[string]bool, len(names))
+		for _, name := range names {
+			keep[http.CanonicalHeaderKey(name)] = true
+		}
+		kept := s.remove[:0]
+		for _, name := range s.remove {
+			if !keep[name] {
+				kept = append(kept, name)
+			}
+		}
+		s.remove = kept
+	}
+}
+
+// ScrubPrefix is a functional option that removes all headers whose name
+// begins with one of prefixes, compared case-insensitively.
+func ScrubPrefix(prefixes ...string) ScrubOption {
+	return func(s *scrubHandler) {
+		for _, prefix := range prefixes {
+			s.prefixes = append(s.prefixes, http.CanonicalHeaderKey(prefix))
+		}
+	}
+}
+
+// ScrubRewrite is a functional option that replaces every value of the
+// header name with the result of fn. Values for which fn returns "" are
+// removed.
+func ScrubRewrite(name string, fn func(value string) string) ScrubOption {
+	return func(s *scrubHandler) {
+		s.rewrite[http.CanonicalHeaderKey(name)] = fn
+	}
+}
+
+func (s *scrubHandler) scrub(h http.Header) {
+	for _, name := range s.remove {
+		delete(h, name)
+	}
+	for name, values := range h {
+		if hasAnyPrefix(name, s.prefixes) {
+			delete(h, name)
+			continue
+		}
+		fn, ok := s.rewrite[name]
+		if !ok {
+			continue
+		}
+		rewritten := values[:0]
+		for _, v := range values {
+			if v = fn(v); v != "" {
+				rewritten = append(rewritten, v)
+			}
+		}
+		if len(rewritten) == 0 {
+			delete(h, name)
+		} else {
+			h[name] = rewritten
+		}
+	}
+}
+
+func (s *scrubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wroteHeader := false
+	scrub := func() {
+		if !wroteHeader {
+			wroteHeader = true
+			s.scrub(w.Header())
+		}
+	}
+
+	s.h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+					scrub()
+				} else {
+					// Interim responses carry the headers set so far too.
+					s.scrub(w.Header())
+				}
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				scrub()
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				scrub()
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				scrub()
+				next()
+			}
+		},
+	}), r)
+
+	scrub()
+}
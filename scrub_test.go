@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScrubResponseHeaders(t *testing.T) {
+	h := ScrubResponseHeaders(
+		ScrubRemove("x-internal-host"),
+		ScrubPrefix("x-debug-"),
+		ScrubRewrite("Via", func(v string) string {
+			if v == "secret-proxy" {
+				return ""
+			}
+			return "proxy"
+		}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Server", "nginx/1.2.3")
+		h.Set("X-Powered-By", "PHP/5.4")
+		h.Set("X-Internal-Host", "db7.internal")
+		h.Set("X-Debug-Query-Time", "12ms")
+		h.Add("Via", "1.1 lb-3")
+		h.Add("Via", "secret-proxy")
+		h.Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	for _, name := range []string{"Server", "X-Powered-By", "X-Internal-Host", "X-Debug-Query-Time"} {
+		if v := rr.Header().Get(name); v != "" {
+			t.Errorf("%s not removed: %q", name, v)
+		}
+	}
+	if via := rr.Header().Values("Via"); len(via) != 1 || via[0] != "proxy" {
+		t.Errorf("bad Via: %q", via)
+	}
+	if rr.Header().Get("Content-Type") != "text/plain" || rr.Body.String() != ok {
+		t.Errorf("bad response: %v %q", rr.Header(), rr.Body.String())
+	}
+}
+
+func TestScrubKeep(t *testing.T) {
+	h := ScrubResponseHeaders(ScrubKeep("server"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "gorilla")
+		w.Header().Set("X-Powered-By", "Go")
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if rr.Header().Get("Server") != "gorilla" || rr.Header().Get("X-Powered-By") != "" {
+		t.Fatalf("bad headers: %v", rr.Header())
+	}
+}
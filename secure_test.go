@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecureHeadersDefaults(t *testing.T) {
+	h := SecureHeaders()(okHandler)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "http://www.example.com/"))
+
+	want := map[string]string{
+		"Strict-Transport-Security": "",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Content-Security-Policy":   "",
+		"Permissions-Policy":        "",
+	}
+	for k, v := range want {
+		if got := rr.Header().Get(k); got != v {
+			t.Errorf("bad %s header: got %q want %q", k, got, v)
+		}
+	}
+
+	r := newRequest(http.MethodGet, "https://www.example.com/")
+	r.TLS = &tls.ConnectionState{}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	if got, want := rr.Header().Get("Strict-Transport-Security"), "max-age=31536000"; got != want {
+		t.Errorf("bad HSTS header over TLS: got %q want %q", got, want)
+	}
+}
+
+func TestSecureHeadersOptions(t *testing.T) {
+	h := SecureHeaders(
+		SecureHSTS(time.Hour, true, false),
+		SecureHSTSOverPlainHTTP(),
+		SecureContentTypeOptions(false),
+		SecureFrameOptions(""),
+		SecureReferrerPolicy("no-referrer"),
+		SecurePermissionsPolicy("geolocation=()"),
+		SecureContentSecurityPolicy("default-src 'self'"),
+		SecureContentSecurityPolicyReportOnly("script-src 'none'"),
+	)(okHandler)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "http://www.example.com/"))
+
+	want := map[string]string{
+		"Strict-Transport-Security":           "max-age=3600; includeSubDomains",
+		"X-Content-Type-Options":              "",
+		"X-Frame-Options":                     "",
+		"Referrer-Policy":                     "no-referrer",
+		"Permissions-Policy":                  "geolocation=()",
+		"Content-Security-Policy":             "default-src 'self'",
+		"Content-Security-Policy-Report-Only": "script-src 'none'",
+	}
+	for k, v := range want {
+		if got := rr.Header().Get(k); got != v {
+			t.Errorf("bad %s header: got %q want %q", k, got, v)
+		}
+	}
+}
+
+func TestSecureHeadersPathOverride(t *testing.T) {
+	h := SecureHeaders(
+		SecurePathOverride("/embed/", SecureFrameOptions("SAMEORIGIN")),
+		SecurePathOverride("/embed/raw/", SecureFrameOptions("")),
+		SecureReferrerPolicy("no-referrer"),
+	)(okHandler)
+
+	tests := []struct {
+		path, frame string
+	}{
+		{"/", "DENY"},
+		{"/embed/video", "SAMEORIGIN"},
+		{"/embed/raw/video", ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "http://www.example.com"+tt.path))
+		if got := rr.Header().Get("X-Frame-Options"); got != tt.frame {
+			t.Errorf("%s: bad X-Frame-Options: got %q want %q", tt.path, got, tt.frame)
+		}
+		// Overrides build on the complete base policy.
+		if got := rr.Header().Get("Referrer-Policy"); got != "no-referrer" {
+			t.Errorf("%s: bad Referrer-Policy: got %q want %q", tt.path, got, "no-referrer")
+		}
+	}
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+	base := NewContentSecurityPolicy().
+		Add("default-src", "'self'").
+		Add("img-src", "'self'", "data:")
+	extra := NewContentSecurityPolicy().
+		Add("IMG-SRC", "data:", "https://cdn.example.com").
+		Add("upgrade-insecure-requests")
+
+	got := base.Merge(extra).String()
+	want := "default-src 'self'; img-src 'self' data: https://cdn.example.com; upgrade-insecure-requests"
+	if got != want {
+		t.Fatalf("bad policy: got %q want %q", got, want)
+	}
+
+	got = base.Set("default-src", "'none'").Remove("upgrade-insecure-requests").String()
+	want = "default-src 'none'; img-src 'self' data: https://cdn.example.com"
+	if got != want {
+		t.Fatalf("bad policy: got %q want %q", got, want)
+	}
+}
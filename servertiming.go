@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+type serverTimingKey struct{}
+
+// ServerTiming collects named timing metrics for a request. It is safe for
+// concurrent use. Methods on a nil *ServerTiming do nothing, so handlers can
+// record timings regardless of whether ServerTimingHandler is installed.
+type ServerTiming struct {
+	mu      sync.Mutex
+	metrics []ServerTimingMetric
+}
+
+// ServerTimingMetric is a single metric of a Server-Timing header.
+type ServerTimingMetric struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// Timing returns the ServerTiming of the request context ctx, as installed by
+// ServerTimingHandler, or nil if there is none.
+//
+// Example:
+//
+//	start := time.Now()
+//	rows, err := db.QueryContext(r.Context(), query)
+//	handlers.Timing(r.Context()).Add("db", time.Since(start))
+func Timing(ctx context.Context) *ServerTiming {
+	t, _ := ctx.Value(serverTimingKey{}).(*ServerTiming)
+	return t
+}
+
+// Add records a metric with the given name and duration.
+func (t *ServerTiming) Add(name string, d time.Duration) {
+	t.AddMetric(ServerTimingMetric{Name: name, Duration: d})
+}
+
+// AddMetric records m.
+func (t *ServerTiming) AddMetric(m ServerTimingMetric) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.metrics = append(t.metrics, m)
+	t.mu.Unlock()
+}
+
+// Start starts timing the metric name and returns a function that records it
+// when called.
+//
+//	defer handlers.Timing(r.Context()).Start("render")()
+func (t *ServerTiming) Start(name string) func() {
+	start := time.Now()
+	return func() {
+		t.Add(name, time.Since(start))
+	}
+}
+
+// Metrics returns a copy of the metrics recorded so far.
+func (t *ServerTiming) Metrics() []ServerTimingMetric {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ServerTimingMetric(nil), t.metrics...)
+}
+
+// String returns the metrics formatted as a Server-Timing header value.
+func (t *ServerTiming) String() string {
+	var b strings.Builder
+	for i, m := range t.Metrics() {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(m.String())
+	}
+	return b.String()
+}
+
+// String returns m formatted as a Server-Timing header metric.
+func (m ServerTimingMetric) String() string {
+	var b strings.Builder
+	b.WriteString(m.Name)
+	if m.Duration != 0 {
+		ms := float64(m.Duration.Round(time.Microsecond)) / float64(time.Millisecond)
+		b.WriteString(";dur=")
+		b.WriteString(strconv.FormatFloat(ms, 'f', -1, 64))
+	}
+	if m.Description != "" {
+		b.WriteString(";desc=")
+		b.WriteString(strconv.Quote(m.Description))
+	}
+	return b.String()
+}
+
+type serverTimingHandler struct {
+	h       http.Handler
+	total   string
+	enabled func(*http.Request) bool
+}
+
+// ServerTimingOption provides a functional approach to configure the
+// ServerTimingHandler middleware.
+type ServerTimingOption func(*serverTimingHandler)
+
+// ServerTimingHandler is HTTP middleware that makes a ServerTiming available
+// through Timing(r.Context()) and emits the metrics recorded on it in a
+// Server-Timing response header, so that browser developer tools show backend
+// phase timings.
+//
+// The header is set when the response header is written; metrics recorded
+// afterwards are not sent.
+//
+// Example:
+//
+//	http.ListenAndServe(":8000", handlers.ServerTimingHandler(handlers.ServerTimingTotal("total"))(r))
+func ServerTimingHandler(opts ...ServerTimingOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		s := &serverTimingHandler{h: h}
+		for _, option := range opts {
+			option(s)
+		}
+		return s
+	}
+}
+
+// ServerTimingTotal is a functional option that adds a metric with the given
+// name holding the time elapsed between the start of the request and the
+// writing of the response header.
+func ServerTimingTotal(name string) ServerTimingOption {
+	return func(s *serverTimingHandler) {
+		s.total = name
+	}
+}
+
+// ServerTimingEnabled is a functional option that limits the Server-Timing
+// header to requests for which fn returns true, e.g. those from internal
+// networks, as timings can reveal details about the backend.
+func ServerTimingEnabled(fn func(*http.Request) bool) ServerTimingOption {
+	return func(s *serverTimingHandler) {
+		s.enabled = fn
+	}
+}
+
+func (s *serverTimingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.enabled != nil && !s.enabled(r) {
+		s.h.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+	t := &ServerTiming{}
+	r = r.WithContext(context.WithValue(r.Context(), serverTimingKey{}, t))
+
+	wroteHeader := false
+	writeTiming := func() {
+		if wroteHeader {
+			return
+		}
+		wroteHeader = true
+		if s.total != "" {
+			t.Add(s.total, time.Since(start))
+		}
+		if v := t.String(); v != "" {
+			w.Header().Add("Server-Timing", v)
+		}
+	}
+
+	s.h.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				if code >= http.StatusOK || code == http.StatusSwitchingProtocols {
+					writeTiming()
+				}
+				next(code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(b []byte) (int, error) {
+				writeTiming()
+				return next(b)
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				writeTiming()
+				return next(src)
+			}
+		},
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				writeTiming()
+				next()
+			}
+		},
+	}), r)
+
+	if !wroteHeader {
+		writeTiming()
+	}
+}
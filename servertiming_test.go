@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTimingHandler(t *testing.T) {
+	h := ServerTimingHandler(ServerTimingTotal("total"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tm := Timing(r.Context())
+		tm.Add("db", 12500*time.Microsecond)
+		tm.AddMetric(ServerTimingMetric{Name: "cache", Description: `hit "warm"`})
+		_, _ = w.Write([]byte(ok))
+		tm.Add("late", time.Second)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	got := rr.Header().Get("Server-Timing")
+	want := `db;dur=12.5, cache;desc="hit \"warm\"", total;dur=`
+	if !strings.HasPrefix(got, want) {
+		t.Fatalf("bad Server-Timing: got %q want prefix %q", got, want)
+	}
+	if strings.Contains(got, "late") {
+		t.Fatalf("metric recorded after the header was written: %q", got)
+	}
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	h := ServerTimingHandler(ServerTimingEnabled(func(r *http.Request) bool {
+		return false
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tm := Timing(r.Context())
+		if tm != nil {
+			t.Error("got a ServerTiming for a disabled request")
+		}
+		tm.Add("db", time.Millisecond)
+		tm.Start("render")()
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+	if got := rr.Header().Get("Server-Timing"); got != "" {
+		t.Fatalf("unexpected Server-Timing: %q", got)
+	}
+	if Timing(context.Background()) != nil {
+		t.Fatal("got a ServerTiming from an empty context")
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type singleFlight struct {
+	h       http.Handler
+	headers []string
+	keyFunc func(*http.Request) string
+
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is an in-flight execution of the wrapped handler shared by
+// concurrent identical requests.
+type flightCall struct {
+	done chan struct{}
+	rb   *responseBuffer
+	ok   bool
+}
+
+// SingleFlightOption provides a functional approach to configure the
+// SingleFlight middleware.
+type SingleFlightOption func(*singleFlight)
+
+// SingleFlight is HTTP middleware that coalesces concurrent identical GET
+// requests into a single execution of the wrapped handler. The response of
+// that execution is buffered in memory and written to every request waiting
+// on it, protecting slow backends from thundering herds.
+//
+// Requests are identical when they share the host, request URI and the values
+// of a set of request headers (see SingleFlightHeaders). Streaming responses
+// are buffered in full before being sent. If the shared execution panics or
+// its client goes away before it completes, the waiting requests run the
+// wrapped handler themselves.
+//
+// Example:
+//
+//	r := mux.NewRouter()
+//	r.Handle("/report", handlers.SingleFlight()(reportHandler))
+func SingleFlight(opts ...SingleFlightOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		s := &singleFlight{
+			h:       h,
+			headers: []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"},
+			calls:   make(map[string]*flightCall),
+		}
+		for _, option := range opts {
+			option(s)
+		}
+		return s
+	}
+}
+
+// SingleFlightHeaders is a functional option that sets the request headers
+// whose values must match for requests to be coalesced. It defaults to
+// Accept, Accept-Encoding, Accept-Language, Authorization and Cookie, so that
+// responses are never shared between differently negotiated or authenticated
+// requests.
+func SingleFlightHeaders(names ...string) SingleFlightOption {
+	return func(s *singleFlight) {
+		s.headers = names
+	}
+}
+
+// SingleFlightKey is a functional option that sets the function computing the
+// key identifying identical requests, replacing the default URL and header
+// based key. Requests for which fn returns "" are never coalesced.
+func SingleFlightKey(fn func(*http.Request) string) SingleFlightOption {
+	return func(s *singleFlight) {
+		s.keyFunc = fn
+	}
+}
+
+func (s *singleFlight) key(r *http.Request) string {
+	if s.keyFunc != nil {
+		return s.keyFunc(r)
+	}
+	var b strings.Builder
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+	for _, name := range s.headers {
+		b.WriteByte(0)
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+func (s *singleFlight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.h.ServeHTTP(w, r)
+		return
+	}
+	key := s.key(r)
+	if key == "" {
+		s.h.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	if c, ok := s.calls[key]; ok {
+		s.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-r.Context().Done():
+			return
+		}
+		if !c.ok {
+			s.h.ServeHTTP(w, r)
+			return
+		}
+		c.rb.writeTo(w)
+		return
+	}
+	c := &flightCall{
+		done: make(chan struct{}),
+		rb:   &responseBuffer{header: make(http.Header)},
+	}
+	s.calls[key] = c
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.calls, key)
+		s.mu.Unlock()
+		close(c.done)
+	}()
+
+	s.h.ServeHTTP(c.rb, r)
+	c.ok = r.Context().Err() == nil
+	c.rb.writeTo(w)
+}
+
+// writeTo writes the buffered response to w. Its header values replace those
+// already set on w under the same names; other headers set on w are kept.
+func (rb *responseBuffer) writeTo(w http.ResponseWriter) {
+	header := w.Header()
+	for k, v := range rb.header {
+		header[k] = append([]string(nil), v...)
+	}
+	code := rb.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	_, _ = w.Write(rb.body.Bytes())
+}
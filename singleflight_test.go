@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// waitingContext closes waiting once its Done channel is asked for, which
+// requests coalesced by SingleFlight do right before waiting on the in-flight
+// call.
+type waitingContext struct {
+	context.Context
+	once    sync.Once
+	waiting chan struct{}
+}
+
+func (c *waitingContext) Done() <-chan struct{} {
+	c.once.Do(func() { close(c.waiting) })
+	return c.Context.Done()
+}
+
+// waitingRequest returns a request whose returned channel is closed once it
+// waits on an in-flight call.
+func waitingRequest(target string) (*http.Request, <-chan struct{}) {
+	ctx := &waitingContext{Context: context.Background(), waiting: make(chan struct{})}
+	return newRequest(http.MethodGet, target).WithContext(ctx), ctx.waiting
+}
+
+func TestSingleFlight(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	s := SingleFlight()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+		}
+		<-release
+		w.Header().Set("X-Result", "shared")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(ok))
+	})).(*singleFlight)
+
+	const n = 5
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	serve := func(rr *httptest.ResponseRecorder, r *http.Request) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ServeHTTP(rr, r)
+		}()
+	}
+	recs[0] = httptest.NewRecorder()
+	serve(recs[0], newRequest(http.MethodGet, "/report?q=1"))
+	<-entered
+	for i := 1; i < n; i++ {
+		recs[i] = httptest.NewRecorder()
+		r, waiting := waitingRequest("/report?q=1")
+		serve(recs[i], r)
+		<-waiting
+	}
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("bad number of calls: got %d want 1", got)
+	}
+	for i, rr := range recs {
+		if rr.Code != http.StatusAccepted || rr.Body.String() != ok || rr.Header().Get("X-Result") != "shared" {
+			t.Fatalf("%d: bad response: %v %q %v", i, rr.Code, rr.Body.String(), rr.Header())
+		}
+	}
+}
+
+func TestSingleFlightKey(t *testing.T) {
+	s := SingleFlight()(okHandler).(*singleFlight)
+
+	a := newRequest(http.MethodGet, "/a")
+	b := newRequest(http.MethodGet, "/a")
+	if s.key(a) != s.key(b) {
+		t.Fatal("identical requests have different keys")
+	}
+	b.Header.Set("Authorization", "Bearer token")
+	if s.key(a) == s.key(b) {
+		t.Fatal("requests with different credentials share a key")
+	}
+	if s.key(a) == s.key(newRequest(http.MethodGet, "/a?x=1")) {
+		t.Fatal("requests with different queries share a key")
+	}
+}
+
+func TestSingleFlightFallback(t *testing.T) {
+	var calls int32
+	entered := make(chan struct{})
+	s := SingleFlight()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(ok))
+	})).(*singleFlight)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leader := newRequest(http.MethodGet, "/a").WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		s.ServeHTTP(httptest.NewRecorder(), leader)
+		close(done)
+	}()
+	<-entered
+
+	waiter := httptest.NewRecorder()
+	waiterDone := make(chan struct{})
+	r, waiting := waitingRequest("/a")
+	go func() {
+		s.ServeHTTP(waiter, r)
+		close(waiterDone)
+	}()
+	<-waiting
+	cancel()
+	<-done
+	<-waiterDone
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("bad number of calls: got %d want 2", got)
+	}
+	if waiter.Code != http.StatusOK || waiter.Body.String() != ok {
+		t.Fatalf("bad waiter response: %v %q", waiter.Code, waiter.Body.String())
+	}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, newRequest(http.MethodPost, "/a"))
+	if rr.Body.String() != ok {
+		t.Fatalf("bad POST response: %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SlashPolicy determines how TrailingSlashHandler treats trailing slashes.
+type SlashPolicy int
+
+const (
+	// SlashKeep leaves trailing slashes as they are.
+	SlashKeep SlashPolicy = iota
+	// SlashAdd adds a trailing slash to paths lacking one, except for paths
+	// whose last segment has a file extension, such as /robots.txt.
+	SlashAdd
+	// SlashStrip removes trailing slashes from all paths but the root.
+	SlashStrip
+)
+
+type trailingSlashHandler struct {
+	h        http.Handler
+	policy   SlashPolicy
+	collapse bool
+	code     int
+}
+
+// SlashOption provides a functional approach to configure the
+// TrailingSlashHandler middleware.
+type SlashOption func(*trailingSlashHandler)
+
+// TrailingSlashHandler is HTTP middleware that normalizes request paths
+// according to policy, and optionally collapses duplicate slashes (see
+// SlashCollapse). It should be applied before routing, so that route tables
+// only need to contain the normalized form of each path.
+//
+// By default the request is rewritten in place and passed to the next handler.
+// Use SlashRedirect to re-direct clients to the normalized path instead.
+//
+// Example:
+//
+//	r := mux.NewRouter()
+//	r.HandleFunc("/users", UsersHandler)
+//
+//	slashes := handlers.TrailingSlashHandler(handlers.SlashStrip, handlers.SlashCollapse())
+//	http.ListenAndServe(":8000", slashes(r))
+func TrailingSlashHandler(policy SlashPolicy, opts ...SlashOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		t := &trailingSlashHandler{h: h, policy: policy}
+		for _, option := range opts {
+			option(t)
+		}
+		return t
+	}
+}
+
+// SlashCollapse is a functional option that collapses runs of slashes in the
+// request path into a single slash.
+func SlashCollapse() SlashOption {
+	return func(t *trailingSlashHandler) {
+		t.collapse = true
+	}
+}
+
+// SlashRedirect is a functional option that re-directs clients to the
+// normalized path with the given status code, typically 301 "Moved
+// Permanently" or 308 "Permanent Redirect" (which preserves the request
+// method), instead of rewriting the request.
+func SlashRedirect(code int) SlashOption {
+	return func(t *trailingSlashHandler) {
+		t.code = code
+	}
+}
+
+func (t *trailingSlashHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	escaped := r.URL.EscapedPath()
+	normalized := t.normalize(escaped)
+	if normalized == escaped {
+		t.h.ServeHTTP(w, r)
+		return
+	}
+
+	if t.code != 0 {
+		// Never re-direct to a protocol-relative URL such as //example.org.
+		loc := "/" + strings.TrimLeft(normalized, "/")
+		if r.URL.RawQuery != "" {
+			loc += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, loc, t.code)
+		return
+	}
+
+	unescaped, err := url.PathUnescape(normalized)
+	if err != nil {
+		t.h.ServeHTTP(w, r)
+		return
+	}
+	// Rewrite a copy, as http.StripPrefix does, so that the request seen by
+	// outer middleware is left alone.
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = unescaped
+	r2.URL.RawPath = ""
+	if r2.URL.EscapedPath() != normalized {
+		r2.URL.RawPath = normalized
+	}
+	t.h.ServeHTTP(w, r2)
+}
+
+// normalize applies the configured policy to the escaped path p.
+func (t *trailingSlashHandler) normalize(p string) string {
+	if t.collapse {
+		for strings.Contains(p, "//") {
+			p = strings.ReplaceAll(p, "//", "/")
+		}
+	}
+
+	switch t.policy {
+	case SlashAdd:
+		if !strings.HasSuffix(p, "/") && path.Ext(p) == "" {
+			p += "/"
+		}
+	case SlashStrip:
+		if trimmed := strings.TrimRight(p, "/"); trimmed != "" {
+			p = trimmed
+		} else if p != "" {
+			p = "/"
+		}
+	}
+	return p
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrailingSlashHandlerRewrite(t *testing.T) {
+	tests := []struct {
+		policy   SlashPolicy
+		collapse bool
+		in, want string
+	}{
+		{SlashKeep, false, "/users//42/", "/users//42/"},
+		{SlashKeep, true, "/users//42//", "/users/42/"},
+		{SlashAdd, false, "/users", "/users/"},
+		{SlashAdd, false, "/users/", "/users/"},
+		{SlashAdd, false, "/robots.txt", "/robots.txt"},
+		{SlashStrip, false, "/users/", "/users"},
+		{SlashStrip, false, "/users///", "/users"},
+		{SlashStrip, false, "/", "/"},
+		{SlashStrip, true, "//", "/"},
+		{SlashStrip, true, "/a%2Fb//c/", "/a%2Fb/c"},
+	}
+
+	for _, tt := range tests {
+		var got string
+		opts := []SlashOption{}
+		if tt.collapse {
+			opts = append(opts, SlashCollapse())
+		}
+		h := TrailingSlashHandler(tt.policy, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.EscapedPath()
+		}))
+
+		rr := httptest.NewRecorder()
+		r := newRequest(http.MethodGet, "http://example.com"+tt.in)
+		h.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%q: bad status: got %v want %v", tt.in, rr.Code, http.StatusOK)
+		}
+		if got != tt.want {
+			t.Errorf("%q: bad path: got %q want %q", tt.in, got, tt.want)
+		}
+		if r.URL.EscapedPath() != tt.in {
+			t.Errorf("%q: caller's request rewritten to %q", tt.in, r.URL.EscapedPath())
+		}
+	}
+}
+
+func TestTrailingSlashHandlerRedirect(t *testing.T) {
+	called := false
+	h := TrailingSlashHandler(SlashStrip, SlashRedirect(http.StatusPermanentRedirect))(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, "http://example.com/users/?page=2"))
+	if rr.Code != http.StatusPermanentRedirect {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusPermanentRedirect)
+	}
+	if got, want := rr.Header().Get("Location"), "/users?page=2"; got != want {
+		t.Fatalf("bad location: got %q want %q", got, want)
+	}
+	if called {
+		t.Fatal("next handler called for re-directed request")
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "http://example.com//evil.example.org/"))
+	if got, want := rr.Header().Get("Location"), "/evil.example.org"; got != want {
+		t.Fatalf("bad location: got %q want %q", got, want)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "http://example.com/users"))
+	if !called || rr.Code != http.StatusOK {
+		t.Fatalf("normalized request not passed through: status %v", rr.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultSPAIndex        = "index.html"
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// defaultSPAHashedAsset matches file names carrying a content hash, as
+// produced by common bundlers, e.g. app.3f2a9c1b.js or chunk-3F2A9C1B.css.
+var defaultSPAHashedAsset = regexp.MustCompile(`[.-][0-9a-zA-Z_]{8,}\.[0-9a-zA-Z]+$`)
+
+type spaHandler struct {
+	fs       http.FileSystem
+	index    string
+	notFound http.Handler
+	hashed   func(name string) bool
+}
+
+// SPAOption provides a functional approach to configure the SPAHandler.
+type SPAOption func(*spaHandler)
+
+// SPAHandler returns a handler serving a single-page application from fs.
+//
+// Requests for existing files are served as by http.FileServer, except that
+// directory listings are never shown. Requests for paths which don't exist and
+// don't look like assets, i.e. whose last segment has no file extension, are
+// served the index page so that client-side routing can handle them. Requests
+// for missing assets are answered with a 404 "Not Found", which may be
+// customized with SPANotFound.
+//
+// Assets whose name carries a content hash (see SPAHashedAssets) are served
+// with a long-lived immutable Cache-Control header, while the index page is
+// served with Cache-Control: no-cache so that new deployments are picked up.
+//
+// Example:
+//
+//	http.Handle("/", handlers.SPAHandler(http.Dir("./dist")))
+func SPAHandler(fs http.FileSystem, opts ...SPAOption) http.Handler {
+	s := &spaHandler{
+		fs:       fs,
+		index:    defaultSPAIndex,
+		notFound: http.NotFoundHandler(),
+		hashed:   defaultSPAHashedAsset.MatchString,
+	}
+	for _, option := range opts {
+		option(s)
+	}
+	return s
+}
+
+// SPAIndex is a functional option that sets the name of the index page served
+// for client-side routes. It defaults to index.html.
+func SPAIndex(name string) SPAOption {
+	return func(s *spaHandler) {
+		s.index = name
+	}
+}
+
+// SPANotFound is a functional option that sets the handler responding to
+// requests for missing assets.
+func SPANotFound(h http.Handler) SPAOption {
+	return func(s *spaHandler) {
+		s.notFound = h
+	}
+}
+
+// SPAHashedAssets is a functional option that sets the function reporting
+// whether the file at the given path carries a content hash in its name, and
+// may therefore be cached forever.
+func SPAHashedAssets(fn func(name string) bool) SPAOption {
+	return func(s *spaHandler) {
+		s.hashed = fn
+	}
+}
+
+func (s *spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if s.serveFile(w, r, name) {
+		return
+	}
+
+	if path.Ext(name) != "" {
+		s.notFound.ServeHTTP(w, r)
+		return
+	}
+	if !s.serveFile(w, r, "/"+strings.TrimPrefix(s.index, "/")) {
+		s.notFound.ServeHTTP(w, r)
+	}
+}
+
+// serveFile serves the regular file name from s.fs, reporting whether it
+// exists.
+func (s *spaHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		return false
+	}
+
+	if s.hashed(name) {
+		w.Header().Set(cacheControlHeader, immutableCacheControl)
+	} else if path.Base(name) == path.Base(s.index) {
+		w.Header().Set(cacheControlHeader, revalidateCacheControl)
+	}
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), f.(io.ReadSeeker))
+	return true
+}
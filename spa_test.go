@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSPAHandler(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":              "<html>index</html>",
+		"favicon.ico":             "icon",
+		"assets/app.3f2a9c1b.js":  "console.log('app')",
+		"assets/docs/readme.html": "<html>readme</html>",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := SPAHandler(http.Dir(dir), SPANotFound(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "missing asset", http.StatusNotFound)
+	})))
+
+	tests := []struct {
+		path  string
+		code  int
+		body  string
+		cache string
+	}{
+		{"/", http.StatusOK, "<html>index</html>", "no-cache"},
+		{"/users/42/settings", http.StatusOK, "<html>index</html>", "no-cache"},
+		{"/assets", http.StatusOK, "<html>index</html>", "no-cache"},
+		{"/favicon.ico", http.StatusOK, "icon", ""},
+		{"/assets/app.3f2a9c1b.js", http.StatusOK, "console.log('app')", "public, max-age=31536000, immutable"},
+		{"/assets/docs/readme.html", http.StatusOK, "<html>readme</html>", ""},
+		{"/assets/missing.js", http.StatusNotFound, "missing asset\n", ""},
+		{"/../../etc/hosts.txt", http.StatusNotFound, "missing asset\n", ""},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, newRequest(http.MethodGet, "http://example.com"+tt.path))
+
+		if rr.Code != tt.code {
+			t.Errorf("%s: bad status: got %v want %v", tt.path, rr.Code, tt.code)
+		}
+		if rr.Body.String() != tt.body {
+			t.Errorf("%s: bad body: got %q want %q", tt.path, rr.Body.String(), tt.body)
+		}
+		if got := rr.Header().Get("Cache-Control"); got != tt.cache {
+			t.Errorf("%s: bad Cache-Control: got %q want %q", tt.path, got, tt.cache)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodPost, "http://example.com/"))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bad status for POST: got %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type timeoutHandler struct {
+	h           http.Handler
+	timeout     time.Duration
+	timeoutFunc func(*http.Request) time.Duration
+	onTimeout   http.Handler
+}
+
+// TimeoutOption provides a functional approach to configure the
+// TimeoutHandler middleware.
+type TimeoutOption func(*timeoutHandler)
+
+// TimeoutHandler is HTTP middleware that runs the next handler with a time
+// limit. It improves on http.TimeoutHandler in a few ways:
+//
+//   - The request context is cancelled when the time limit is reached, so that
+//     well-behaved handlers stop working on behalf of a client that has
+//     already been answered.
+//   - The limit may be chosen per request (see TimeoutFunc), e.g. to give
+//     report or upload routes more time than the rest of an API.
+//   - The timeout response is customizable (see TimeoutResponse and
+//     TimeoutJSON). It defaults to a 503 "Service Unavailable".
+//
+// Like http.TimeoutHandler, the response of the next handler is buffered and
+// only written once the handler returns in time. Writes made after the time
+// limit fail with http.ErrHandlerTimeout. Because all writes to the underlying
+// http.ResponseWriter happen on the serving goroutine, the middleware may be
+// freely combined with LoggingHandler and CompressHandler: the logged status is
+// that of the response the client actually received.
+//
+// A panic in the next handler is re-raised on the serving goroutine, so that
+// an outer RecoveryHandler can handle it.
+//
+// Example:
+//
+//	r := mux.NewRouter()
+//	r.HandleFunc("/search", SearchHandler)
+//
+//	timeout := handlers.TimeoutHandler(2*time.Second, handlers.TimeoutJSON(
+//		http.StatusGatewayTimeout, map[string]string{"error": "request timed out"}))
+//	http.ListenAndServe(":8000", timeout(r))
+func TimeoutHandler(timeout time.Duration, opts ...TimeoutOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		t := &timeoutHandler{
+			h:         h,
+			timeout:   timeout,
+			onTimeout: http.HandlerFunc(defaultTimeoutResponse),
+		}
+		for _, option := range opts {
+			option(t)
+		}
+		return t
+	}
+}
+
+// TimeoutFunc is a functional option that computes the time limit for each
+// request, overriding the default passed to TimeoutHandler. A non-positive
+// duration serves the request without any time limit.
+func TimeoutFunc(fn func(*http.Request) time.Duration) TimeoutOption {
+	return func(t *timeoutHandler) {
+		t.timeoutFunc = fn
+	}
+}
+
+// TimeoutResponse is a functional option that sets the handler used to respond
+// to requests that timed out. The handler is given the request whose context
+// has expired.
+func TimeoutResponse(h http.Handler) TimeoutOption {
+	return func(t *timeoutHandler) {
+		t.onTimeout = h
+	}
+}
+
+// TimeoutJSON is a functional option that responds to requests that timed
+// out with the given status code and the JSON encoding of v.
+func TimeoutJSON(code int, v interface{}) TimeoutOption {
+	return TimeoutResponse(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := json.Marshal(v)
+		if err != nil {
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(code)
+		_, _ = w.Write(append(body, '\n'))
+	}))
+}
+
+func defaultTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+func (t *timeoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d := t.timeout
+	if t.timeoutFunc != nil {
+		d = t.timeoutFunc(r)
+	}
+	if d <= 0 {
+		t.h.ServeHTTP(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	panicChan := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		t.h.ServeHTTP(tw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+
+		dst := w.Header()
+		for k, vv := range tw.header {
+			dst[k] = vv
+		}
+		if tw.code == 0 {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		_, _ = w.Write(tw.buf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		tw.timedOut = true
+		tw.mu.Unlock()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			t.onTimeout.ServeHTTP(w, r)
+		}
+	}
+}
+
+// timeoutWriter buffers the response of a handler run by TimeoutHandler.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	// Interim responses can't be sent from the buffer.
+	if tw.timedOut || tw.code != 0 || (code < http.StatusOK && code != http.StatusSwitchingProtocols) {
+		return
+	}
+	tw.code = code
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutHandlerCompletes(t *testing.T) {
+	h := TimeoutHandler(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if got := rr.Header().Get("X-Test"); got != "yes" {
+		t.Fatalf("bad header: got %q want %q", got, "yes")
+	}
+	if rr.Body.String() != ok {
+		t.Fatalf("bad body: got %q want %q", rr.Body.String(), ok)
+	}
+}
+
+func TestTimeoutHandlerTimesOut(t *testing.T) {
+	cancelled := make(chan error, 1)
+	h := TimeoutHandler(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cancelled <- r.Context().Err()
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if err := <-cancelled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("handler context not cancelled: got %v", err)
+	}
+}
+
+func TestTimeoutHandlerLateWrite(t *testing.T) {
+	result := make(chan error, 1)
+	h := TimeoutHandler(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		_, err := w.Write([]byte(ok))
+		result <- err
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+
+	if err := <-result; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Fatalf("bad write error: got %v want %v", err, http.ErrHandlerTimeout)
+	}
+}
+
+func TestTimeoutHandlerJSON(t *testing.T) {
+	h := TimeoutHandler(10*time.Millisecond, TimeoutJSON(http.StatusGatewayTimeout, map[string]string{"error": "timeout"}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/"))
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusGatewayTimeout)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("bad content type: got %q want %q", got, "application/json")
+	}
+	if got, want := rr.Body.String(), "{\"error\":\"timeout\"}\n"; got != want {
+		t.Fatalf("bad body: got %q want %q", got, want)
+	}
+}
+
+func TestTimeoutHandlerPerRoute(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+		}
+	})
+	h := TimeoutHandler(10*time.Millisecond, TimeoutFunc(func(r *http.Request) time.Duration {
+		if strings.HasPrefix(r.URL.Path, "/reports") {
+			return 0
+		}
+		return 10 * time.Millisecond
+	}), TimeoutResponse(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))(slow)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/reports/yearly"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, newRequest(http.MethodGet, "/search"))
+	if rr.Code != http.StatusTeapot {
+		t.Fatalf("bad status: got %v want %v", rr.Code, http.StatusTeapot)
+	}
+}
+
+func TestTimeoutHandlerPropagatesPanic(t *testing.T) {
+	h := TimeoutHandler(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("Unexpected error!")
+	}))
+
+	defer func() {
+		if p := recover(); p != "Unexpected error!" {
+			t.Fatalf("bad panic value: got %v", p)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TraceParent is a W3C Trace Context traceparent header value.
+type TraceParent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+var errInvalidTraceParent = errors.New("handlers: invalid traceparent")
+
+// ParseTraceParent parses a W3C traceparent header value such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceParent(s string) (TraceParent, error) {
+	var tp TraceParent
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tp, errInvalidTraceParent
+	}
+	version, err := decodeLowerHex(s[:2])
+	if err != nil || version[0] == 0xff {
+		return tp, errInvalidTraceParent
+	}
+	// Future versions may append fields, version 00 may not.
+	if len(s) > 55 && (version[0] == 0 || s[55] != '-') {
+		return tp, errInvalidTraceParent
+	}
+	traceID, err := decodeLowerHex(s[3:35])
+	if err != nil {
+		return tp, errInvalidTraceParent
+	}
+	spanID, err := decodeLowerHex(s[36:52])
+	if err != nil {
+		return tp, errInvalidTraceParent
+	}
+	flags, err := decodeLowerHex(s[53:55])
+	if err != nil {
+		return tp, errInvalidTraceParent
+	}
+	copy(tp.TraceID[:], traceID)
+	copy(tp.SpanID[:], spanID)
+	tp.Flags = flags[0]
+	if !tp.IsValid() {
+		return TraceParent{}, errInvalidTraceParent
+	}
+	return tp, nil
+}
+
+func decodeLowerHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, errInvalidTraceParent
+	}
+	return hex.DecodeString(s)
+}
+
+// IsValid reports whether tp has non-zero trace and span IDs.
+func (tp TraceParent) IsValid() bool {
+	return tp.TraceID != [16]byte{} && tp.SpanID != [8]byte{}
+}
+
+// Sampled reports whether the sampled flag of tp is set.
+func (tp TraceParent) Sampled() bool {
+	return tp.Flags&0x01 != 0
+}
+
+// TraceIDString returns the hex encoded trace ID of tp.
+func (tp TraceParent) TraceIDString() string {
+	return hex.EncodeToString(tp.TraceID[:])
+}
+
+// SpanIDString returns the hex encoded span ID of tp.
+func (tp TraceParent) SpanIDString() string {
+	return hex.EncodeToString(tp.SpanID[:])
+}
+
+// String returns tp formatted as a version 00 traceparent header value.
+func (tp TraceParent) String() string {
+	return "00-" + tp.TraceIDString() + "-" + tp.SpanIDString() + "-" + hex.EncodeToString([]byte{tp.Flags})
+}
+
+// requestTraceIDs returns the hex encoded trace and span IDs propagated with
+// the W3C traceparent header or, failing that, the B3 headers of Zipkin, or
+// empty strings if there are none.
+func requestTraceIDs(h http.Header) (traceID, spanID string) {
+	if tp, err := ParseTraceParent(h.Get("traceparent")); err == nil {
+		return tp.TraceIDString(), tp.SpanIDString()
+	}
+	// b3: {TraceId}-{SpanId}[-{SamplingState}[-{ParentSpanId}]]
+	if b3 := h.Get("b3"); b3 != "" {
+		traceID, rest, _ := strings.Cut(b3, "-")
+		spanID, _, _ = strings.Cut(rest, "-")
+		if isB3ID(traceID, true) && isB3ID(spanID, false) {
+			return traceID, spanID
+		}
+		return "", ""
+	}
+	traceID, spanID = h.Get("X-B3-TraceId"), h.Get("X-B3-SpanId")
+	if isB3ID(traceID, true) && isB3ID(spanID, false) {
+		return traceID, spanID
+	}
+	return "", ""
+}
+
+// isB3ID reports whether s is a valid B3 span ID, or a trace ID if trace is
+// set, which may be 64 or 128 bits long.
+func isB3ID(s string, trace bool) bool {
+	if len(s) != 16 && (!trace || len(s) != 32) {
+		return false
+	}
+	_, err := decodeLowerHex(s)
+	return err == nil && strings.Count(s, "0") != len(s)
+}
+
+// SpanAttribute is a key-value attribute of a span. Keys follow the
+// OpenTelemetry semantic conventions for HTTP servers, e.g.
+// "http.request.method" or "http.response.status_code".
+type SpanAttribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Tracer starts server spans. It is a minimal interface which is easily
+// implemented on top of an OpenTelemetry trace.Tracer, keeping this package
+// free of the dependency.
+type Tracer interface {
+	// Start starts a server span named name. The remote parent is the
+	// traceparent of the request, if it had a valid one, and state its
+	// tracestate header. The returned context carries the span.
+	Start(ctx context.Context, name string, parent TraceParent, state string, attrs []SpanAttribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttributes adds attrs to the span.
+	SetAttributes(attrs ...SpanAttribute)
+	// End completes the span. Implementations should mark the span as failed
+	// if it has an http.response.status_code attribute of 500 or greater or
+	// an error.type attribute.
+	End()
+}
+
+type tracingHandler struct {
+	h        http.Handler
+	tracer   Tracer
+	route    func(*http.Request) string
+	spanName func(r *http.Request, route string) string
+	filter   func(*http.Request) bool
+}
+
+// TracingOption provides a functional approach to configure the Tracing
+// middleware.
+type TracingOption func(*tracingHandler)
+
+// Tracing is HTTP middleware that starts a server span per request using
+// tracer. It extracts the remote parent from the W3C traceparent and
+// tracestate request headers, sets semantic HTTP attributes on the span and
+// ends it once the response is complete, recording its status and size.
+//
+// Example, using gorilla/mux to provide the route:
+//
+//	r := mux.NewRouter()
+//	r.Use(handlers.Tracing(tracer, handlers.TracingRoute(func(r *http.Request) string {
+//		tmpl, _ := mux.CurrentRoute(r).GetPathTemplate()
+//		return tmpl
+//	})))
+func Tracing(tracer Tracer, opts ...TracingOption) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		t := &tracingHandler{h: h, tracer: tracer}
+		for _, option := range opts {
+			option(t)
+		}
+		return t
+	}
+}
+
+// TracingRoute is a functional option that sets the function returning the
+// route template matched by the request, such as "/users/{id}". It is used for
+// the http.route attribute and the span name. With gorilla/mux, install the
+// middleware with Router.Use so that mux.CurrentRoute is available.
+func TracingRoute(fn func(*http.Request) string) TracingOption {
+	return func(t *tracingHandler) {
+		t.route = fn
+	}
+}
+
+// TracingSpanName is a functional option that sets the function naming spans.
+// By default, spans are named after the request method followed by the route,
+// if any.
+func TracingSpanName(fn func(r *http.Request, route string) string) TracingOption {
+	return func(t *tracingHandler) {
+		t.spanName = fn
+	}
+}
+
+// TracingFilter is a functional option that limits tracing to requests for
+// which fn returns true, e.g. to skip health checks.
+func TracingFilter(fn func(*http.Request) bool) TracingOption {
+	return func(t *tracingHandler) {
+		t.filter = fn
+	}
+}
+
+func (t *tracingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if t.filter != nil && !t.filter(r) {
+		t.h.ServeHTTP(w, r)
+		return
+	}
+
+	var route string
+	if t.route != nil {
+		route = t.route(r)
+	}
+	name := r.Method
+	if t.spanName != nil {
+		name = t.spanName(r, route)
+	} else if route != "" {
+		name += " " + route
+	}
+
+	parent, _ := ParseTraceParent(r.Header.Get("traceparent"))
+	ctx, span := t.tracer.Start(r.Context(), name, parent, r.Header.Get("tracestate"), requestAttributes(r, route))
+
+	logger, w := makeLogger(w)
+	completed := false
+	defer func() {
+		attrs := []SpanAttribute{
+			{"http.response.status_code", logger.Status()},
+			{"http.response.body.size", logger.Size()},
+		}
+		if !completed {
+			attrs = append(attrs, SpanAttribute{"error.type", "panic"})
+		}
+		span.SetAttributes(attrs...)
+		span.End()
+	}()
+
+	t.h.ServeHTTP(w, r.WithContext(ctx))
+	completed = true
+}
+
+func requestAttributes(r *http.Request, route string) []SpanAttribute {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	attrs := []SpanAttribute{
+		{"http.request.method", r.Method},
+		{"url.scheme", scheme},
+		{"url.path", r.URL.Path},
+		{"server.address", r.Host},
+		{"network.protocol.version", strings.TrimPrefix(r.Proto, "HTTP/")},
+	}
+	if r.URL.RawQuery != "" {
+		attrs = append(attrs, SpanAttribute{"url.query", r.URL.RawQuery})
+	}
+	if route != "" {
+		attrs = append(attrs, SpanAttribute{"http.route", route})
+	}
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, SpanAttribute{"user_agent.original", ua})
+	}
+	if ip, ok := remoteIP(r); ok {
+		attrs = append(attrs, SpanAttribute{"client.address", ip.String()})
+	}
+	return attrs
+}
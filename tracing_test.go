@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name   string
+	parent TraceParent
+	state  string
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+type testSpanKey struct{}
+
+func (t *testTracer) Start(ctx context.Context, name string, parent TraceParent, state string, attrs []SpanAttribute) (context.Context, Span) {
+	s := &testSpan{name: name, parent: parent, state: state, attrs: make(map[string]interface{})}
+	s.SetAttributes(attrs...)
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func (s *testSpan) SetAttributes(attrs ...SpanAttribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) End() {
+	s.ended = true
+}
+
+func TestParseTraceParent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tp, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp.String() != valid || !tp.Sampled() || tp.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || tp.SpanIDString() != "00f067aa0ba902b7" {
+		t.Fatalf("bad traceparent: %+v", tp)
+	}
+	if _, err := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Fatalf("future version rejected: %v", err)
+	}
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, err := ParseTraceParent(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	tracer := &testTracer{}
+	h := Tracing(tracer, TracingRoute(func(r *http.Request) string {
+		return "/users/{id}"
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(testSpanKey{}) == nil {
+			t.Error("span missing from the request context")
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(ok))
+	}))
+
+	r := newRequest(http.MethodPost, "http://example.com/users/42?x=1")
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("tracestate", "vendor=value")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(tracer.spans) != 1 {
+		t.Fatalf("bad number of spans: %d", len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if !s.ended || s.name != "POST /users/{id}" || s.state != "vendor=value" || s.parent.SpanIDString() != "00f067aa0ba902b7" {
+		t.Fatalf("bad span: %+v", s)
+	}
+	for k, v := range map[string]interface{}{
+		"http.request.method":       http.MethodPost,
+		"http.route":                "/users/{id}",
+		"url.path":                  "/users/42",
+		"url.query":                 "x=1",
+		"server.address":            "example.com",
+		"client.address":            "192.0.2.1",
+		"http.response.status_code": http.StatusCreated,
+		"http.response.body.size":   len(ok),
+	} {
+		if s.attrs[k] != v {
+			t.Errorf("bad %s attribute: got %v want %v", k, s.attrs[k], v)
+		}
+	}
+}
+
+func TestTracingPanic(t *testing.T) {
+	tracer := &testTracer{}
+	h := Tracing(tracer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "/"))
+	}()
+
+	s := tracer.spans[0]
+	if !s.ended || s.name != http.MethodGet || s.attrs["error.type"] != "panic" || s.parent.IsValid() {
+		t.Fatalf("bad span: %+v", s)
+	}
+}
+
+func TestRequestTraceIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		trace  string
+		span   string
+	}{
+		{"none", http.Header{}, "", ""},
+		{"traceparent", http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"b3 single", http.Header{"B3": {"80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"}}, "80f198ee56343ba864fe8b2a57d3eff7", "e457b5a2e4d86bd1"},
+		{"b3 sampling only", http.Header{"B3": {"0"}}, "", ""},
+		{"b3 multi", http.Header{"X-B3-Traceid": {"463ac35c9f6413ad"}, "X-B3-Spanid": {"a2fb4a1d1a96d312"}}, "463ac35c9f6413ad", "a2fb4a1d1a96d312"},
+		{"b3 zero", http.Header{"X-B3-Traceid": {"0000000000000000"}, "X-B3-Spanid": {"a2fb4a1d1a96d312"}}, "", ""},
+		{"invalid traceparent", http.Header{"Traceparent": {"00-xyz"}, "X-B3-Traceid": {"463ac35c9f6413ad"}, "X-B3-Spanid": {"a2fb4a1d1a96d312"}}, "463ac35c9f6413ad", "a2fb4a1d1a96d312"},
+	}
+	for _, test := range tests {
+		trace, span := requestTraceIDs(test.header)
+		if trace != test.trace || span != test.span {
+			t.Errorf("%s: got %q, %q, want %q, %q", test.name, trace, span, test.trace, test.span)
+		}
+	}
+}